@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configValueは設定ファイルの1つの値を、フラグに渡す文字列にしたものです（スカラーの場合は要素が1つ）。
+// リストは、繰り返し指定できるフラグ（-headerなど）には要素ごとに、それ以外（-include-extなど）にはカンマ区切りにして渡します。
+type configValue []string
+
+// repeatableValueは繰り返し指定できるフラグの値です。
+type repeatableValue interface {
+	flag.Value
+	repeatable()
+}
+
+// UnmarshalYAMLはスカラーとスカラーのリストをconfigValueにします。
+func (v *configValue) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*v = configValue{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%d行目: リストの要素は文字列か数値で指定してください", item.Line)
+			}
+			items = append(items, item.Value)
+		}
+		*v = items
+		return nil
+	}
+	return fmt.Errorf("%d行目: 値は文字列・数値・真偽値か、それらのリストで指定してください", node.Line)
+}
+
+// UnmarshalJSONは文字列・数値・真偽値と、それらのリストをconfigValueにします。
+func (v *configValue) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if list, ok := value.([]any); ok {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			s, err := jsonScalar(item)
+			if err != nil {
+				return err
+			}
+			items = append(items, s)
+		}
+		*v = items
+		return nil
+	}
+	s, err := jsonScalar(value)
+	*v = configValue{s}
+	return err
+}
+
+// jsonScalarはJSONの文字列・数値・真偽値をフラグに渡す文字列にします。
+func jsonScalar(value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool, float64:
+		return fmt.Sprint(value), nil
+	}
+	return "", errors.New("値は文字列・数値・真偽値か、それらのリストで指定してください")
+}
+
+// loadConfigFileはpathのYAMLまたはJSON（拡張子が.jsonの場合）の設定ファイルを読み込みます。
+// キーはフラグ名（先頭の"-"なし）で、値はコマンドラインと同じ形式です（例: timeout: 30s、max-size: 50MB）。
+func loadConfigFile(path string) (map[string]configValue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]configValue)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &values)
+	} else if err = yaml.NewDecoder(bytes.NewReader(data)).Decode(&values); errors.Is(err, io.EOF) {
+		err = nil // 空のファイル
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// applyConfigFileはpathの設定ファイルの値を、コマンドラインで明示的に指定されていないfsのフラグに設定します。
+// 値の解析はコマンドラインと同じく各フラグが行います。存在しないフラグ名のキーはまとめてエラーにします。
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	values, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	var unknown []string
+	for name := range values {
+		if fs.Lookup(name) == nil || name == "config" {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%s: 不明なキー: %s", path, strings.Join(unknown, ", "))
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if set[name] {
+			continue
+		}
+		items := []string(values[name])
+		if _, ok := fs.Lookup(name).Value.(repeatableValue); !ok {
+			items = []string{strings.Join(items, ",")}
+		}
+		for _, item := range items {
+			if err := fs.Set(name, item); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// secretEnvsは、コマンドラインに書くとシェルの履歴やプロセスの一覧に残ってしまう値を読み込む環境変数です。
+var secretEnvs = []struct {
+	flag string
+	env  string
+}{
+	{"api-token", "GDA_API_TOKEN"},
+	{"proxy", "GDA_PROXY"},
+	{"basic-pass", "GDA_BASIC_PASS"},
+}
+
+// proxyPasswordEnvは-proxyのURLにユーザー名だけを書いた場合に、パスワードを読み込む環境変数です。
+const proxyPasswordEnv = "GDA_PROXY_PASSWORD"
+
+// applySecretEnvsはsecretEnvsの環境変数の値を、コマンドラインで指定されていないfsのフラグに設定します。
+// 設定ファイルより前に呼ぶため、優先順位はコマンドライン > 環境変数 > 設定ファイルになります。
+func applySecretEnvs(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range secretEnvs {
+		value := os.Getenv(s.env)
+		if value == "" || set[s.flag] {
+			continue
+		}
+		if err := fs.Set(s.flag, value); err != nil {
+			// 値は秘密の情報のため、エラーに含めない
+			return fmt.Errorf("%s: -%sに設定できません", s.env, s.flag)
+		}
+	}
+	return nil
+}
+
+// proxyWithPasswordはproxyのURLにユーザー名があってパスワードがない場合に、環境変数のパスワードを付けたURLを返します。
+func proxyWithPassword(proxy string) (string, error) {
+	password := os.Getenv(proxyPasswordEnv)
+	if proxy == "" || password == "" {
+		return proxy, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return "", errors.New("-proxyのURLが不正です")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return proxy, nil
+	}
+	if _, ok := u.User.Password(); ok {
+		return proxy, nil
+	}
+	u.User = url.UserPassword(u.User.Username(), password)
+	return u.String(), nil
+}
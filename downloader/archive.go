@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Archiveはダウンロードしたファイルを個別のファイルではなく1つのアーカイブファイル（zipまたはtar.gz）に書き込みます。
+// 複数のゴルーチンから同時に使えます。
+type Archive struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     archiveWriter
+	names map[string]bool // 書き込んだエントリの名前
+}
+
+// archiveWriterはアーカイブの形式ごとのエントリの書き込み方です。
+type archiveWriter interface {
+	// writeEntryはsizeバイトのrの内容をnameのエントリとして書き込みます。
+	writeEntry(name string, size int64, modTime time.Time, r io.Reader) error
+	// Closeはアーカイブの末尾を書き込みます（ファイルは閉じません）。
+	Close() error
+}
+
+// CreateZipはfilePathにzipファイルを作成します。書き込みが終わったらCloseを呼び出してください。
+func CreateZip(filePath string) (*Archive, error) {
+	return createArchive(filePath, func(w io.Writer) archiveWriter {
+		return zipWriter{zip.NewWriter(w)}
+	})
+}
+
+// CreateTarGzはfilePathにgzipで圧縮したtarファイルを作成します。書き込みが終わったらCloseを呼び出してください。
+func CreateTarGz(filePath string) (*Archive, error) {
+	return createArchive(filePath, func(w io.Writer) archiveWriter {
+		gz := gzip.NewWriter(w)
+		return tarGzWriter{tw: tar.NewWriter(gz), gz: gz}
+	})
+}
+
+// createArchiveはfilePathを作成し、newWriterで作成した形式で書き込むArchiveを返します。
+func createArchive(filePath string, newWriter func(io.Writer) archiveWriter) (*Archive, error) {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{file: f, w: newWriter(f), names: make(map[string]bool)}, nil
+}
+
+// addはrの内容をnameのエントリとして書き込み、実際に使ったエントリの名前と書き込んだバイト数を返します。
+// 同じ名前のエントリが既にある場合は"image (1).png"のように番号を付けます。
+// 内容はいったん一時ファイルに書き込んでからエントリにコピーするため、ダウンロードが途中で失敗しても
+// 不完全なエントリは残らず、ダウンロード中に他のファイルの書き込みを待たせることもありません
+// （tarのヘッダに必要なサイズも、Content-Lengthの有無によらず決まります）。
+// verifyの場合はverifyFileで内容を確認し、拡張子の形式でなければ書き込みません。
+func (a *Archive) add(name string, r io.Reader, modTime time.Time, verify bool) (string, int64, error) {
+	tmpFile, err := os.CreateTemp("", "go_download_attachment-*"+path.Ext(name))
+	if err != nil {
+		return name, 0, err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	n, err := io.Copy(tmpFile, r)
+	if err != nil {
+		return name, n, err
+	}
+	if verify {
+		if err := verifyFile(tmpFile.Name()); err != nil {
+			return name, n, err
+		}
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return name, n, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	name = a.uniqueName(name)
+	if err := a.w.writeEntry(name, n, modTime, tmpFile); err != nil {
+		return name, n, err
+	}
+	a.names[name] = true
+	return name, n, nil
+}
+
+// uniqueNameはnameがまだ使われていなければそのまま、使われていれば番号を付けた名前を返します。
+func (a *Archive) uniqueName(name string) string {
+	for i := 0; ; i++ {
+		if candidate := numberedName(name, i); !a.names[candidate] {
+			return candidate
+		}
+	}
+}
+
+// Closeはアーカイブの末尾（zipのセントラルディレクトリなど）を書き込んで閉じます。
+// 一部のダウンロードに失敗していても、書き込めたエントリだけの正しいアーカイブになります。
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.w.Close()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// zipWriterはzip形式のarchiveWriterです。
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w zipWriter) writeEntry(name string, size int64, modTime time.Time, r io.Reader) error {
+	entry, err := w.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
+
+func (w zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// tarGzWriterはgzipで圧縮したtar形式のarchiveWriterです。
+type tarGzWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (w tarGzWriter) writeEntry(name string, size int64, modTime time.Time, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX, // 日本語のファイル名を正しく記録するため
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w tarGzWriter) Close() error {
+	err := w.tw.Close()
+	if gzErr := w.gz.Close(); err == nil {
+		err = gzErr
+	}
+	return err
+}
+
+// archiveEntryNameはdir（ページごとのサブディレクトリなど）のfileNameのアーカイブ内での名前を返します。
+func archiveEntryName(dir, fileName string) string {
+	return filepath.ToSlash(filepath.Join(dir, fileName))
+}
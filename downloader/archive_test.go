@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// archiveTestServerは/missing以外のパスにパスそのものを内容として返すテスト用のサーバーを起動します。
+func archiveTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// archiveTestJobsは同じ名前になる2つのファイルと、ダウンロードに失敗するファイルのジョブを返します。
+func archiveTestJobs(srv *httptest.Server) []downloadJob {
+	return []downloadJob{
+		{url: srv.URL + "/a/image.png", fileName: "image.png"},
+		{url: srv.URL + "/b/image.png", fileName: "image.png"},
+		{url: srv.URL + "/missing.png", fileName: "missing.png"},
+		{url: "data:image/png;base64," + onePixelPNG, fileName: "inline"},
+	}
+}
+
+// TestRunDownloadsZipはzipファイルにダウンロードした全てのファイルが書き込まれ、同じ名前には番号が付き、
+// 失敗したファイルがあっても正しいzipファイルになることを確認します。
+func TestRunDownloadsZip(t *testing.T) {
+	srv := archiveTestServer(t)
+	zipPath := filepath.Join(t.TempDir(), "out.zip")
+	archive, err := CreateZip(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DownloadOptions{Archive: archive}
+	results, err := runDownloads(context.Background(), srv.Client(), srv.URL, archiveTestJobs(srv), 4, false, opts)
+	if err != nil {
+		t.Fatalf("runDownloads: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatalf("zipファイルを開けません: %v", err)
+	}
+	defer zr.Close()
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		got[f.Name] = string(data)
+	}
+	assertArchiveContents(t, results, got)
+}
+
+// TestRunDownloadsTarGzはtar.gzファイルにダウンロードした全てのファイルが正しいサイズと更新日時で書き込まれることを確認します。
+func TestRunDownloadsTarGz(t *testing.T) {
+	srv := archiveTestServer(t)
+	tarGzPath := filepath.Join(t.TempDir(), "out.tar.gz")
+	archive, err := CreateTarGz(tarGzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DownloadOptions{Archive: archive}
+	results, err := runDownloads(context.Background(), srv.Client(), srv.URL, archiveTestJobs(srv), 4, false, opts)
+	if err != nil {
+		t.Fatalf("runDownloads: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := os.Open(tarGzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzipとして読めません: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	got := make(map[string]string)
+	lastModified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tarとして読めません: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("%s: %v", header.Name, err)
+		}
+		if header.Size != int64(len(data)) {
+			t.Errorf("%s: ヘッダのサイズ = %d, 内容 %dバイト", header.Name, header.Size, len(data))
+		}
+		if header.Name != "inline.png" && !header.ModTime.Equal(lastModified) {
+			t.Errorf("%s: 更新日時 = %v, want %v", header.Name, header.ModTime, lastModified)
+		}
+		got[header.Name] = string(data)
+	}
+	assertArchiveContents(t, results, got)
+}
+
+// assertArchiveContentsはアーカイブのエントリ（名前 → 内容）がダウンロードに成功したファイルと一致することを確認します。
+func assertArchiveContents(t *testing.T, results []Result, entries map[string]string) {
+	t.Helper()
+	want := make(map[string]bool)
+	for _, r := range results {
+		if !r.Success {
+			if _, ok := entries[r.File]; ok {
+				t.Errorf("失敗したファイルがアーカイブにあります: %s", r.File)
+			}
+			continue
+		}
+		want[r.File] = true
+		if _, ok := entries[r.File]; !ok {
+			t.Errorf("アーカイブに%sがありません", r.File)
+		}
+	}
+	if len(entries) != len(want) {
+		t.Errorf("エントリ数 = %d, want %d: %v", len(entries), len(want), entries)
+	}
+	if entries["image.png"] == entries["image (1).png"] || entries["image (1).png"] == "" {
+		t.Errorf("同じ名前のファイルが区別されていません: %v", entries)
+	}
+	if entries["inline.png"] == "" {
+		t.Error("data: URIの画像がアーカイブにありません")
+	}
+	// OutDirが空のため、ファイルとして保存されていればカレントディレクトリにある
+	if _, err := os.Stat("image.png"); err == nil {
+		os.Remove("image.png")
+		t.Error("アーカイブではなくファイルとして保存されています")
+	}
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checksumsFileNameはWriteChecksumsが出力するファイルの名前です。
+const checksumsFileName = "checksums.txt"
+
+// WriteChecksumsはresultsの保存したファイルのSHA-256を、sha256sumと同じ形式でOutDir/checksums.txtに書き込みます。
+// パスはOutDirからの相対パスのため、OutDirで"sha256sum -c checksums.txt"を実行して確認できます。
+// 既に存在するためスキップしたファイルは、保存されているファイルから計算します。
+func (d *Downloader) WriteChecksums(results []Result) error {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if !r.Success || r.Filtered || r.File == "" {
+			continue
+		}
+		rel := d.savedPath(r)
+		if seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		digest, err := d.contentSHA256(r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", digest, filepath.ToSlash(rel))
+	}
+	_, err := writeFileAtomic(filepath.Join(d.opts.OutDir, checksumsFileName), &buf)
+	return err
+}
+
+// contentSHA256はrのファイルのSHA-256を返します。ダウンロード時に計算していない場合（スキップした場合）は保存されているファイルから計算します。
+func (d *Downloader) contentSHA256(r Result) (string, error) {
+	if r.SHA256 != "" {
+		return r.SHA256, nil
+	}
+	sum := sha256.New()
+	if err := hashFile(sum, filepath.Join(d.opts.OutDir, d.savedPath(r))); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// hashFileはpathのファイルの内容をhに書き込みます。
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// helloSHA256は"hello"のSHA-256です（sha256sumで計算した値）。
+const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+// TestDownloadFileSHA256はダウンロードしながら計算したSHA-256が内容のSHA-256と一致することを確認します。
+func TestDownloadFileSHA256(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir()}
+	result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.txt", "a.txt", opts)
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if result.SHA256 != helloSHA256 {
+		t.Errorf("SHA256 = %s, want %s", result.SHA256, helloSHA256)
+	}
+	result, err = saveDataURI("data:text/plain;base64,aGVsbG8=", "inline", opts)
+	if err != nil || result.SHA256 != helloSHA256 {
+		t.Errorf("data: URI: result = %+v, err = %v", result, err)
+	}
+}
+
+// TestWriteChecksumsはsha256sumと同じ形式でOutDirからの相対パスとSHA-256を書き込み、
+// スキップしたファイルは保存されているファイルから計算することを確認します。
+func TestWriteChecksums(t *testing.T) {
+	d := &Downloader{opts: Options{OutDir: t.TempDir(), PerPageDir: true}}
+	page := "https://growi.example.com/Docs"
+	dir := filepath.Join(d.opts.OutDir, "Docs")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results := []Result{
+		{Page: page, File: "a.txt", SHA256: "0123", Success: true},
+		{Page: page, File: "old.txt", Success: true, Skipped: true},
+		{Page: page, File: "a.txt", SHA256: "0123", Success: true},
+		{Page: page, File: "icon.svg", Success: true, Skipped: true, Filtered: true},
+		{Page: page, File: "missing.png", Error: "404"},
+	}
+	if err := d.WriteChecksums(results); err != nil {
+		t.Fatalf("WriteChecksums: %v", err)
+	}
+	want := "0123  Docs/a.txt\n" + helloSHA256 + "  Docs/old.txt\n"
+	if got := readFile(t, filepath.Join(d.opts.OutDir, "checksums.txt")); got != want {
+		t.Errorf("checksums.txt = %q, want %q", got, want)
+	}
+}
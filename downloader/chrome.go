@@ -0,0 +1,192 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// DefaultUserAgentはOptions.UserAgentの既定値です。
+// ヘッドレスChromeの既定のUser-Agent（HeadlessChrome）を拒否するサーバーがあるため、通常のデスクトップ版Chromeのものを使います。
+const DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/132.0.0.0 Safari/537.36"
+
+// newAllocatorはchromedpのアロケータを作成します。
+// opts.RemoteURLが指定されている場合は起動済みのChromeに接続し、そのブラウザのプロファイル（Cookie）を使います。
+// 指定されていない場合はカレントユーザのChromeプロファイルでChromeを起動します。
+func newAllocator(parent context.Context, opts *Options) (context.Context, context.CancelFunc) {
+	if opts.RemoteURL != "" {
+		return chromedp.NewRemoteAllocator(parent, opts.RemoteURL)
+	}
+
+	// chromedp用のExecAllocatorオプションを生成
+	allocOpts := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+	if !opts.Headless {
+		allocOpts = append(allocOpts, chromedp.Flag("headless", false))
+	}
+	if opts.ChromePath != "" {
+		allocOpts = append(allocOpts, chromedp.ExecPath(opts.ChromePath))
+	}
+	// 画面の大きさはタブごとにも設定するが、ブラウザを表示する場合にウィンドウも同じ大きさにする
+	if opts.Extract.WindowWidth > 0 && opts.Extract.WindowHeight > 0 {
+		allocOpts = append(allocOpts, chromedp.WindowSize(opts.Extract.WindowWidth, opts.Extract.WindowHeight))
+	}
+	if opts.Insecure {
+		allocOpts = append(allocOpts, chromedp.IgnoreCertErrors)
+	} else if opts.CACert != "" {
+		// Chromeには証明書ストアを追加するフラグがないため、証明書チェーンの公開鍵ハッシュで信頼させる
+		// （サーバーがCACertの証明書を含むチェーンを送る場合のみ有効）
+		hashes, err := certSPKIHashes(opts.CACert)
+		if err != nil {
+			slog.Warn("CA証明書をChromeに設定できませんでした", "error", err)
+		} else {
+			allocOpts = append(allocOpts, chromedp.Flag("ignore-certificate-errors-spki-list", strings.Join(hashes, ",")))
+		}
+	}
+	// Chromeは--proxy-serverの認証情報を使わないため、ホスト部分だけを渡し、認証はタブごとに応答する
+	// （SOCKS5の場合もChrome側で名前解決はプロキシ経由になる）
+	if proxyURL, err := ParseProxyURL(opts.Proxy); err == nil {
+		scheme := proxyURL.Scheme
+		if isSOCKSProxy(proxyURL) {
+			scheme = "socks5"
+			if proxyURL.User != nil {
+				slog.Warn("ChromeはSOCKS5プロキシの認証に対応していないため、ページの表示には認証情報が使われません")
+			}
+		}
+		allocOpts = append(allocOpts, chromedp.ProxyServer(scheme+"://"+proxyURL.Host))
+	}
+	// カレントユーザのChromeのユーザーデータディレクトリとプロファイルを設定
+	// （--user-data-dirにプロファイルのディレクトリを渡すと、Chromeはその中に新しいプロファイルを作ってしまうため、
+	// 親ディレクトリを渡し、プロファイルは--profile-directoryで選ぶ）
+	userDataDir := opts.UserDataDir
+	if userDataDir == "" {
+		userDataDir = getChromeUserDataDir()
+	}
+	if userDataDir != "" {
+		// プロファイルが違うとログインCookieを使えないため、存在しない場合ははっきり警告する
+		profileDir := filepath.Join(userDataDir, opts.ChromeProfile)
+		if info, err := os.Stat(profileDir); err != nil || !info.IsDir() {
+			slog.Warn("Chromeプロファイルディレクトリが存在しません（ログインが必要な画像はダウンロードできません）", "dir", profileDir)
+		}
+		allocOpts = append(allocOpts, chromedp.Flag("user-data-dir", userDataDir))
+		if opts.ChromeProfile != "" {
+			allocOpts = append(allocOpts, chromedp.Flag("profile-directory", opts.ChromeProfile))
+		}
+	} else {
+		slog.Info("Chromeプロファイルディレクトリが見つかりませんでした。デフォルト設定で起動します")
+	}
+	return chromedp.NewExecAllocator(parent, allocOpts...)
+}
+
+// getChromeUserDataDirはOSごとのカレントユーザのChromeのユーザーデータディレクトリ（プロファイルの親ディレクトリ）のパスを返します。
+func getChromeUserDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		slog.Warn("ユーザのホームディレクトリの取得に失敗", "error", err)
+		return ""
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		// Windowsの場合: %LOCALAPPDATA%\Google\Chrome\User Data
+		localAppData := os.Getenv("LOCALAPPDATA")
+		if localAppData == "" {
+			return ""
+		}
+		return filepath.Join(localAppData, "Google", "Chrome", "User Data")
+	case "darwin":
+		// macOSの場合: ~/Library/Application Support/Google/Chrome
+		return filepath.Join(home, "Library", "Application Support", "Google", "Chrome")
+	case "linux":
+		// Linuxの場合: ~/.config/google-chrome
+		return filepath.Join(home, ".config", "google-chrome")
+	default:
+		return ""
+	}
+}
+
+// ChromeInfoはDetectChromeで調べたChromeの情報です。
+type ChromeInfo struct {
+	Path    string // 実行ファイルのパス（RemoteURLに接続した場合は空）
+	Product string // Chromeの製品名とバージョン（"Chrome/132.0.6834.83"など）
+}
+
+// DetectChromeはoptsの設定で使うChromeを起動（またはopts.RemoteURLに接続）し、実行ファイルのパスとバージョンを返します。
+// 不具合の報告に使うため、プロファイルは使わずに一時的なユーザーデータディレクトリで起動します。
+func DetectChrome(ctx context.Context, opts Options) (ChromeInfo, error) {
+	var info ChromeInfo
+	var allocCtx context.Context
+	var cancel context.CancelFunc
+	if opts.RemoteURL != "" {
+		allocCtx, cancel = chromedp.NewRemoteAllocator(ctx, opts.RemoteURL)
+	} else {
+		info.Path = opts.ChromePath
+		if info.Path == "" {
+			info.Path = FindChrome()
+		}
+		if info.Path == "" {
+			return info, errors.New("Chromeが見つかりません")
+		}
+		allocOpts := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+		allocOpts = append(allocOpts, chromedp.ExecPath(info.Path))
+		allocCtx, cancel = chromedp.NewExecAllocator(ctx, allocOpts...)
+	}
+	defer cancel()
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	defer cancelBrowser()
+	err := chromedp.Run(browserCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		_, info.Product, _, _, _, err = browser.GetVersion().Do(ctx)
+		return err
+	}))
+	return info, err
+}
+
+// FindChromeはChromeの実行ファイルを、chromedpが起動時に探すのと同じ場所から探します。見つからない場合は空文字列を返します。
+func FindChrome() string {
+	var locations []string
+	switch runtime.GOOS {
+	case "darwin":
+		locations = []string{
+			"/Applications/Chromium.app/Contents/MacOS/Chromium",
+			"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+		}
+	case "windows":
+		locations = []string{
+			"chrome",
+			"chrome.exe",
+			`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+			`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+			filepath.Join(os.Getenv("USERPROFILE"), `AppData\Local\Google\Chrome\Application\chrome.exe`),
+			filepath.Join(os.Getenv("USERPROFILE"), `AppData\Local\Chromium\Application\chrome.exe`),
+		}
+	default:
+		locations = []string{
+			"headless_shell",
+			"headless-shell",
+			"chromium",
+			"chromium-browser",
+			"google-chrome",
+			"google-chrome-stable",
+			"google-chrome-beta",
+			"google-chrome-unstable",
+			"/usr/bin/google-chrome",
+			"/usr/local/bin/chrome",
+			"/snap/bin/chromium",
+			"chrome",
+		}
+	}
+	for _, location := range locations {
+		if path, err := exec.LookPath(location); err == nil {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,614 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// TestRemoteChromeSmokeはGDA_REMOTE_URLに指定した起動済みのChromeに接続し、ページの画像を保存できることを確認します。
+// 例: chrome --headless --remote-debugging-port=9222 を起動し、GDA_REMOTE_URL=http://127.0.0.1:9222 go test ./downloader
+// 接続先のChromeからテストのサーバーに接続できなくてもよいよう、ページも画像もdata: URIにしています。
+func TestRemoteChromeSmoke(t *testing.T) {
+	remoteURL := os.Getenv("GDA_REMOTE_URL")
+	if remoteURL == "" {
+		t.Skip("GDA_REMOTE_URLが設定されていません")
+	}
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.RemoteURL = remoteURL
+	opts.Timeout = 30 * time.Second
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+
+	page := `data:text/html,<img src="data:image/png;base64,` + onePixelPNG + `">`
+	results, err := d.Download(context.Background(), page)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("results = %+v", results)
+	}
+	readFile(t, filepath.Join(opts.OutDir, results[0].File))
+}
+
+// chromeTestOptionsはChromeを使うテストの設定を返します。
+// GDA_REMOTE_URLが設定されていればそのChromeに接続し、なければPATHにあるChrome/Chromiumを起動します。
+// どちらもない場合はテストをスキップします。
+func chromeTestOptions(t *testing.T) Options {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.Timeout = 30 * time.Second
+	opts.Extract.MaxWait = 5 * time.Second
+	if remoteURL := os.Getenv("GDA_REMOTE_URL"); remoteURL != "" {
+		opts.RemoteURL = remoteURL
+		return opts
+	}
+	for _, name := range []string{"headless-shell", "headless_shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"} {
+		if path, err := exec.LookPath(name); err == nil {
+			opts.ChromePath = path
+			opts.UserDataDir = t.TempDir()
+			return opts
+		}
+	}
+	t.Skip("Chromeが見つかりません（GDA_REMOTE_URLで起動済みのChromeを指定できます）")
+	return opts
+}
+
+// newChromeDownloaderはoptsでChromeを使うDownloaderを作成し、テストの終了時に閉じます。
+func newChromeDownloader(t *testing.T, opts Options) *Downloader {
+	t.Helper()
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+// TestDownloadIframesは同一オリジンのiframe内の画像をフレームのURLを基準に解決して保存し、
+// 別オリジンのiframeは読まずに無視することを確認します。
+func TestDownloadIframes(t *testing.T) {
+	opts := chromeTestOptions(t)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<img src="/cross-origin.png">`))
+	}))
+	defer other.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<img src="top.png"><iframe src="/frames/inner.html"></iframe><iframe src="%s/frame.html"></iframe>`, other.URL)
+	})
+	mux.HandleFunc("/frames/inner.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<img src="img/inner.png">`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts.Extract.Iframes = true
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	var urls []string
+	for _, r := range results {
+		urls = append(urls, r.URL)
+	}
+	want := []string{srv.URL + "/top.png", srv.URL + "/frames/img/inner.png"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("URL = %v, want %v", urls, want)
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "inner.png")); got != "/frames/img/inner.png" {
+		t.Errorf("inner.png の内容 = %q", got)
+	}
+}
+
+// TestDownloadMediaはExtract.Mediaの場合に、videoタグのsourceタグとpictureタグのsourceタグのURLも抽出して保存することを確認します。
+func TestDownloadMedia(t *testing.T) {
+	opts := chromeTestOptions(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<video controls><source src="media/clip" type="video/mp4"></video>
+<audio src="/media/voice.mp3"></audio>
+<picture><source srcset="/wide.png" media="(min-width: 1px)"><img src="/narrow.png"></picture>`))
+	})
+	mux.HandleFunc("/media/clip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write([]byte("mp4"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts.Extract.Media = true
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	var files []string
+	for _, r := range results {
+		files = append(files, r.File)
+	}
+	want := []string{"narrow.png", "clip.mp4", "voice.mp3", "wide.png"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("ファイル = %v, want %v", files, want)
+	}
+}
+
+// TestDownloadPierceShadowはExtract.PierceShadowの場合に、openのshadow root（入れ子も含む）内の画像も抽出し、
+// closedのshadow root内の画像は抽出しないことを確認します。
+func TestDownloadPierceShadow(t *testing.T) {
+	opts := chromeTestOptions(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<img src="/light.png"><gallery-item></gallery-item><secret-item></secret-item>
+<script>
+const define = (name, mode, html) => customElements.define(name, class extends HTMLElement {
+	constructor() {
+		super();
+		this.attachShadow({mode}).innerHTML = html;
+	}
+});
+define("nested-item", "open", '<img src="/nested.png">');
+define("gallery-item", "open", '<img src="/shadow.png"><nested-item></nested-item>');
+define("secret-item", "closed", '<img src="/closed.png">');
+</script>`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts.Extract.PierceShadow = true
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	var urls []string
+	for _, r := range results {
+		urls = append(urls, r.URL)
+	}
+	want := []string{srv.URL + "/light.png", srv.URL + "/shadow.png", srv.URL + "/nested.png"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("URL = %v, want %v", urls, want)
+	}
+}
+
+// TestDownloadInlineDataはExtract.InlineDataの場合に、スクリプトがstyle属性に設定したbase64の画像も保存することを確認します。
+func TestDownloadInlineData(t *testing.T) {
+	opts := chromeTestOptions(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<h1>diagram</h1><div id="diagram"></div>
+<script>document.getElementById("diagram").style.backgroundImage = 'url("data:image/png;base64,` + onePixelPNG + `")';</script>`))
+	}))
+	defer srv.Close()
+
+	opts.Extract.InlineData = true
+	opts.Extract.WaitSelector = "h1"
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success || filepath.Ext(results[0].File) != ".png" {
+		t.Errorf("results = %+v, want PNGの画像1件", results)
+	}
+}
+
+// TestDownloadBaseHrefはページにbaseタグがある場合に、imgタグの相対URLをbaseタグのhref属性を基準に解決することを確認します。
+func TestDownloadBaseHref(t *testing.T) {
+	opts := chromeTestOptions(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wiki/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><base href="/assets/"></head><body><h1>page</h1><img src="a.png"></body></html>`))
+	})
+	mux.HandleFunc("/assets/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("a"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts.Extract.WaitSelector = "h1"
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/wiki/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success || results[0].URL != srv.URL+"/assets/a.png" {
+		t.Errorf("results = %+v, want %s/assets/a.png の1件", results, srv.URL)
+	}
+}
+
+// TestDownloadSelectorAttrはSelectorとAttrで画像以外の要素と属性（video source[src]、a[href]）を抽出できることを確認します。
+func TestDownloadSelectorAttr(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<video><source src="/movie.mp4" type="video/mp4"></video>
+<div class="attachment"><a href="/files/spec.pdf">spec</a><a title="it's" href="/files/quoted.pdf">quoted</a></div>
+<a href="/other.pdf">other</a><img src="/image.png">`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		selector string
+		attr     string
+		want     []string
+	}{
+		{"video source", "src", []string{"/movie.mp4"}},
+		{".attachment a", "href", []string{"/files/spec.pdf", "/files/quoted.pdf"}},
+		{`a[title="it's"]`, "href", []string{"/files/quoted.pdf"}},
+	}
+	opts := chromeTestOptions(t)
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			opts := opts
+			opts.OutDir = t.TempDir()
+			opts.Extract.Selector, opts.Extract.Attr, opts.Extract.WaitSelector = tt.selector, tt.attr, tt.selector
+			d := newChromeDownloader(t, opts)
+			results, err := d.Download(context.Background(), srv.URL+"/page")
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			var paths []string
+			for _, r := range results {
+				paths = append(paths, r.URL[len(srv.URL):])
+			}
+			if !reflect.DeepEqual(paths, tt.want) {
+				t.Errorf("URL = %v, want %v", paths, tt.want)
+			}
+		})
+	}
+}
+
+// TestDownloadTitlePrefixはTitlePrefixでページの<title>をファイル名の先頭に付けることを確認します。
+func TestDownloadTitlePrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>MyPage</title></head><body><img src="/image_1.png"></body></html>`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.TitlePrefix = true
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || results[0].File != "MyPage_image_1.png" {
+		t.Fatalf("results = %+v", results)
+	}
+	readFile(t, filepath.Join(opts.OutDir, "MyPage_image_1.png"))
+}
+
+// TestDownloadBasicAuthはBasic認証を求めるページをChromeで表示し、画像もBasic認証でダウンロードできることを確認します。
+func TestDownloadBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "p@ss" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="growi"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/a.png">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.BasicUser, opts.BasicPass = "user", "p@ss"
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("results = %+v", results)
+	}
+}
+
+// TestDownloadHeadersChromeは-headerのヘッダをChromeでのページの表示にも付けることを確認します。
+func TestDownloadHeadersChrome(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-User") != "alice" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/a.png">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.Headers = http.Header{"X-Forwarded-User": {"alice"}}
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("results = %+v", results)
+	}
+}
+
+// TestDownloadDeviceはDeviceを指定した場合に、ページの読み込み前にデバイスのUser-Agentと画面の大きさを設定し、
+// 画面の大きさで決まる画像（picture要素やsrcsetのレスポンシブな画像）をデバイスのものとして抽出することを確認します。
+func TestDownloadDevice(t *testing.T) {
+	info, err := LookupDevice(DefaultMobileDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pageUA, imageUA string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			pageUA = r.UserAgent()
+			// 読み込み時の画面の幅と解像度を画像のURLにする
+			w.Write([]byte(`<script>document.write('<img src="/w' + window.innerWidth + '_x' + window.devicePixelRatio + '.png">')</script>`))
+			return
+		}
+		imageUA = r.UserAgent()
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.Device = DefaultMobileDevice
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	want := fmt.Sprintf("w%d_x%g.png", info.Width, info.Scale)
+	if len(results) != 1 || results[0].File != want {
+		t.Errorf("results = %+v, want %s", results, want)
+	}
+	if pageUA != info.UserAgent || imageUA != info.UserAgent {
+		t.Errorf("User-Agent = %q（ページ）、%q（画像）, want %q", pageUA, imageUA, info.UserAgent)
+	}
+}
+
+// TestDownloadScaleFactorはScaleFactorを指定した場合に、ページの読み込み前に画面の解像度を設定し、
+// srcsetからはその解像度に合う候補をダウンロードすることを確認します。
+func TestDownloadScaleFactor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/a.png" srcset="/a.png 1x, /b.png 2x, /c.png 3x">` +
+				`<script>document.write('<img src="/dpr' + window.devicePixelRatio + '.png">')</script>`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.Extract.ScaleFactor = 2
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.File)
+	}
+	sort.Strings(got)
+	if want := []string{"b.png", "dpr2.png"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ファイル = %q, want %q", got, want)
+	}
+}
+
+// TestDownloadWindowSizeはWindowWidthとWindowHeightで指定した画面の大きさでページを読み込むことを確認します。
+func TestDownloadWindowSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<script>document.write('<img src="/w' + window.innerWidth + '_h' + window.innerHeight + '.png">')</script>`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.Extract.WindowWidth = 800
+	opts.Extract.WindowHeight = 600
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || results[0].File != "w800_h600.png" {
+		t.Errorf("results = %+v, want w800_h600.png", results)
+	}
+}
+
+// TestDownloadNetworkIdleはNetworkIdleを指定した場合に、最初の画像が表示された後にfetchで読み込まれる画像も
+// リクエストが終わるのを待ってから抽出することを確認します。
+func TestDownloadNetworkIdle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Write([]byte(`<img src="/first.png"><script>
+fetch("/list.json").then(r => r.json()).then(list => {
+	for (const src of list) {
+		const img = document.createElement("img");
+		img.src = src;
+		document.body.appendChild(img);
+	}
+});
+</script>`))
+		case "/list.json":
+			time.Sleep(time.Second)
+			w.Write([]byte(`["/second.png"]`))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		}
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.Extract.NetworkIdle = 300 * time.Millisecond
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.File)
+	}
+	sort.Strings(got)
+	if want := []string{"first.png", "second.png"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ファイル = %q, want %q", got, want)
+	}
+}
+
+// TestDownloadWaitJSはWaitJSを指定した場合に、式が真になるまで待ってから抽出することを確認します。
+func TestDownloadWaitJS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/first.png"><script>
+setTimeout(() => {
+	const img = document.createElement("img");
+	img.src = "/second.png";
+	document.body.appendChild(img);
+	window.__growiReady = true;
+}, 500);
+</script>`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.Extract.WaitJS = "window.__growiReady === true"
+	d := newChromeDownloader(t, opts)
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.File)
+	}
+	sort.Strings(got)
+	if want := []string{"first.png", "second.png"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ファイル = %q, want %q", got, want)
+	}
+}
+
+// TestDownloadScreenshotはScreenshotを指定した場合にページ全体のスクリーンショットを保存し、
+// 2ページ目以降は番号を付けたパスに保存することを確認します。
+func TestDownloadScreenshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<h1>Page</h1><div style="height:3000px">tall</div>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	opts := chromeTestOptions(t)
+	opts.Extract.WaitSelector = "h1"
+	opts.Screenshot.Path = filepath.Join(dir, "shots", "page.png")
+	d := newChromeDownloader(t, opts)
+	for _, path := range []string{"/a", "/b"} {
+		if _, err := d.Download(context.Background(), srv.URL+path); err != nil {
+			t.Fatalf("Download(%s): %v", path, err)
+		}
+	}
+	for _, name := range []string{"page.png", "page (1).png"} {
+		f, err := os.Open(filepath.Join(dir, "shots", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// 画面の外まで撮るため、高さは画面（1080）より大きい
+		if b := img.Bounds(); b.Dx() == 0 || b.Dy() < 3000 {
+			t.Errorf("%s: 大きさ = %v", name, b)
+		}
+	}
+}
+
+// TestDownloadPDFはPDFを指定した場合にページを印刷したPDFを保存することを確認します。
+func TestDownloadPDF(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<h1>Page</h1><p>text</p>`))
+	}))
+	defer srv.Close()
+
+	opts := chromeTestOptions(t)
+	opts.Extract.WaitSelector = "h1"
+	opts.PDF.Path = filepath.Join(t.TempDir(), "page.pdf")
+	opts.PDF.Background = true
+	d := newChromeDownloader(t, opts)
+	if _, err := d.Download(context.Background(), srv.URL+"/page"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	data, err := os.ReadFile(opts.PDF.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		t.Errorf("PDFではありません: %q", data[:min(len(data), 16)])
+	}
+}
+
+// TestDetectChromeMissingは存在しない実行ファイルを指定した場合に、そのパスとエラーを返すことを確認します。
+func TestDetectChromeMissing(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ChromePath = filepath.Join(t.TempDir(), "chrome")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := DetectChrome(ctx, opts)
+	if err == nil || info.Path != opts.ChromePath || info.Product != "" {
+		t.Errorf("DetectChrome = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// DefaultJPEGQualityはConvertToでJPEGに変換する場合の既定の品質です。
+const DefaultJPEGQuality = 90
+
+// convertExtsは変換先の形式（image.Decodeが返す形式名）ごとの、保存するファイルの拡張子です。
+var convertExts = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+}
+
+// ParseConvertFormatは-convert-toで指定した形式（jpg、jpeg、png）をDownloadOptions.ConvertToの値に変換します。
+// WebPはGoの標準ライブラリとgolang.org/x/imageにエンコーダがないため、変換先には指定できません（変換元としては読み込めます）。
+func ParseConvertFormat(s string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), ".")) {
+	case "jpg", "jpeg":
+		return "jpeg", nil
+	case "png":
+		return "png", nil
+	case "webp":
+		return "", errors.New("WebPへの変換には対応していません（エンコーダがないため）。jpgかpngを指定してください")
+	}
+	return "", fmt.Errorf("変換先の形式はjpgかpngを指定してください: %q", s)
+}
+
+// convertSavedはurlStrから保存したfilePathの画像をopts.ConvertToの形式に変換し、拡張子を変えた名前で保存し直します。
+// resultのFileName・Size・SHA256を変換後のファイルのものに更新し、保存したファイルのパスを返します。
+// JPEG・PNG・GIF・WebP以外のファイルと、既に変換先の形式の画像はそのまま残します。
+// デコードできない画像も変換せずに残します（DownloadOptions.Verifyの場合はその後の確認で失敗します）。
+// 変換後の名前がこの実行で別のURLに使われている場合は、番号を付けた名前にします。
+func convertSaved(filePath, urlStr string, result *DownloadResult, opts DownloadOptions) (string, error) {
+	targetExt, ok := convertExts[opts.ConvertTo]
+	if !ok {
+		return filePath, nil
+	}
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if _, isImage := imageFormats[ext]; !isImage && ext != ".webp" {
+		return filePath, nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return filePath, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		slog.Warn("画像をデコードできないため変換しません", "file", result.FileName, "error", err)
+		return filePath, nil
+	}
+	if format == opts.ConvertTo {
+		return filePath, nil
+	}
+
+	var buf bytes.Buffer
+	if opts.ConvertTo == "jpeg" {
+		quality := opts.JPEGQuality
+		if quality == 0 {
+			quality = DefaultJPEGQuality
+		}
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return filePath, fmt.Errorf("画像の変換に失敗しました: %w", err)
+	}
+
+	name := strings.TrimSuffix(result.FileName, filepath.Ext(result.FileName)) + targetExt
+	name, _ = opts.Names.reserve(opts.OutDir, name, urlStr, -1, true)
+	convertedPath := filepath.Join(opts.OutDir, name)
+	sum := sha256.New()
+	size, err := writeFileAtomic(convertedPath, io.TeeReader(&buf, sum))
+	if err != nil {
+		return filePath, err
+	}
+	if convertedPath != filePath {
+		os.Remove(filePath)
+	}
+	slog.Debug("画像を変換しました", "file", result.FileName, "converted", name, "format", format+" -> "+opts.ConvertTo)
+	result.FileName, result.Size, result.SHA256 = name, size, hex.EncodeToString(sum.Sum(nil))
+	return convertedPath, nil
+}
+
+// flattenはimgの透明な部分を白で塗った画像を返します。
+// JPEGは透明度を持てず、そのままエンコードすると透明な部分が黒くなるためです。不透明な画像はそのまま返します。
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
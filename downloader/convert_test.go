@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseConvertFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"jpg", "jpeg", false},
+		{"JPEG", "jpeg", false},
+		{".png", "png", false},
+		{"webp", "", true},
+		{"bmp", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseConvertFormat(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseConvertFormat(%q) = %q, %v, want %q (エラー: %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestDownloadFileConvertはPNGをJPEGに、そのJPEGをPNGに変換して保存し、拡張子と内容の形式が変換先のものになることを確認します。
+func TestDownloadFileConvert(t *testing.T) {
+	// 左半分が透明な画像（JPEGでは白になる）
+	src := testDiagram(64, 48, false)
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 32; x++ {
+			src.Set(x, y, color.Transparent)
+		}
+	}
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, src); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{"/diagram.png": pngData.Bytes(), "/notes.txt": []byte("hello")}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Names: NewNameRegistry(), ConvertTo: "jpeg", JPEGQuality: 80, Verify: true}
+	result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/diagram.png", "diagram.png", opts)
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if result.FileName != "diagram.jpg" {
+		t.Errorf("FileName = %q, want diagram.jpg", result.FileName)
+	}
+	if _, err := os.Stat(filepath.Join(opts.OutDir, "diagram.png")); !os.IsNotExist(err) {
+		t.Errorf("変換前のファイルが残っています: %v", err)
+	}
+	jpegData := assertImageFormat(t, filepath.Join(opts.OutDir, "diagram.jpg"), "jpeg", result)
+	img, _, _ := image.Decode(bytes.NewReader(jpegData))
+	if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 < 0xf0 || g>>8 < 0xf0 || b>>8 < 0xf0 {
+		t.Errorf("透明な部分の色 = (%d, %d, %d), want 白", r>>8, g>>8, b>>8)
+	}
+
+	// 画像以外のファイルは変換しない
+	result, err = DownloadFile(context.Background(), srv.Client(), srv.URL+"/notes.txt", "notes.txt", opts)
+	if err != nil || result.FileName != "notes.txt" || readFile(t, filepath.Join(opts.OutDir, "notes.txt")) != "hello" {
+		t.Errorf("notes.txt: %+v, %v", result, err)
+	}
+
+	// 変換したJPEGをPNGに戻す
+	files["/diagram.jpg"] = jpegData
+	opts = DownloadOptions{OutDir: t.TempDir(), Names: NewNameRegistry(), ConvertTo: "png"}
+	result, err = DownloadFile(context.Background(), srv.Client(), srv.URL+"/diagram.jpg", "diagram.jpg", opts)
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if result.FileName != "diagram.png" {
+		t.Errorf("FileName = %q, want diagram.png", result.FileName)
+	}
+	assertImageFormat(t, filepath.Join(opts.OutDir, "diagram.png"), "png", result)
+}
+
+// assertImageFormatはfilePathの画像の形式がformatで、resultのSizeとSHA256がファイルの内容と一致することを確認し、内容を返します。
+func assertImageFormat(t *testing.T, filePath, format string, result DownloadResult) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("変換後のファイルがありません: %v", err)
+	}
+	cfg, got, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || got != format || cfg.Width != 64 || cfg.Height != 48 {
+		t.Errorf("%s: 形式 = %q %dx%d (%v), want %s 64x48", filePath, got, cfg.Width, cfg.Height, err, format)
+	}
+	sum := sha256.Sum256(data)
+	if result.Size != int64(len(data)) || result.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("%s: Size = %d, SHA256 = %s, want %d, %x", filePath, result.Size, result.SHA256, len(data), sum)
+	}
+	return data
+}
@@ -0,0 +1,159 @@
+package downloader
+
+import (
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// extractLinksJSはページ内の全aタグのリンク先の絶対URLを取得するJavaScriptです。
+const extractLinksJS = `Array.from(document.querySelectorAll("a[href]"), a => a.href)`
+
+// growiSystemPathsはGROWIのページではないパス（管理画面や添付ファイルなど）です。
+// このほか"/_api"や"/_search"など"/_"で始まるパスもページではありません。
+var growiSystemPaths = []string{"/admin", "/me", "/login", "/logout", "/trash", "/attachment", "/files", "/share", "/tags"}
+
+// ParseLinksHTMLはHTMLを解析し、全aタグのhref属性を取得します。
+func ParseLinksHTML(r io.Reader) ([]string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			if href := firstAttr(n, "href"); href != "" {
+				links = append(links, href)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return links, nil
+}
+
+// pageLinksはbaseのページ内のhrefのうち、同じホストのGROWIのページと思われるものを絶対URLにして、重複を除いて返します。
+// URLのフラグメントは取り除きます。
+func pageLinks(base *url.URL, hrefs []string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, href := range hrefs {
+		u, err := base.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host != base.Host || !isPagePath(u.Path) {
+			continue
+		}
+		link := normalizePageURL(u)
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// isPagePathはpがGROWIのページのパスと思われるかどうかを返します。
+// ファイルの拡張子（MIMEタイプが分かるもの）で終わるパスとシステムのパスはページではないとみなします。
+// ページ名には"v1.2"のようにドットを含められるため、拡張子があるだけではページではないとみなしません。
+func isPagePath(p string) bool {
+	if mime.TypeByExtension(path.Ext(p)) != "" || strings.HasPrefix(p, "/_") {
+		return false
+	}
+	for _, prefix := range growiSystemPaths {
+		if hasPathPrefix(p, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPathPrefixはpがprefixと同じか、prefix配下のパスかどうかを返します（"/docs"は"/docs/a"に一致し、"/docs2"には一致しません）。
+func hasPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// normalizePageURLは同じページを同じ文字列にするため、uからフラグメントを取り除いた文字列を返します。
+func normalizePageURL(u *url.URL) string {
+	v := *u
+	v.Fragment, v.RawFragment = "", ""
+	return v.String()
+}
+
+// crawlPageはCrawlerでたどるページ1件です。
+type crawlPage struct {
+	url   string
+	depth int    // 起点のページからたどったリンクの数
+	root  string // 起点のページのパス（SamePathPrefixの場合にたどる範囲）
+}
+
+// Crawlerは起点のページからリンクをたどり、ダウンロードするページを順に返します（幅優先）。
+// 一度返したページは再び返しません。複数のゴルーチンから同時には使えません。
+type Crawler struct {
+	maxDepth       int
+	samePathPrefix bool
+	queue          []crawlPage
+	pages          map[string]crawlPage // 見つけたページ（URL → ページ）
+}
+
+// NewCrawlerはstartURLsを起点に、maxDepth回までリンクをたどるCrawlerを作成します（0の場合は起点のページのみ）。
+// samePathPrefixの場合は、起点のページのパス配下のページへのリンクのみをたどります。
+func NewCrawler(startURLs []string, maxDepth int, samePathPrefix bool) *Crawler {
+	c := &Crawler{maxDepth: maxDepth, samePathPrefix: samePathPrefix, pages: make(map[string]crawlPage)}
+	for _, s := range startURLs {
+		u, err := url.Parse(s)
+		if err != nil {
+			// パースできないURLはDownloadPageでエラーにするため、そのまま返す
+			c.push(crawlPage{url: s})
+			continue
+		}
+		c.push(crawlPage{url: normalizePageURL(u), root: u.Path})
+	}
+	return c
+}
+
+// pushはまだ見つけていないページをキューに追加します。
+func (c *Crawler) push(p crawlPage) {
+	if _, ok := c.pages[p.url]; ok {
+		return
+	}
+	c.pages[p.url] = p
+	c.queue = append(c.queue, p)
+}
+
+// Nextは次にダウンロードするページのURLを返します。残っていない場合はfalseを返します。
+func (c *Crawler) Next() (string, bool) {
+	if len(c.queue) == 0 {
+		return "", false
+	}
+	p := c.queue[0]
+	c.queue = c.queue[1:]
+	return p.url, true
+}
+
+// AddLinksはfromのページ内のリンク（DownloadPageが返したもの）を、深さの上限までキューに追加します。
+func (c *Crawler) AddLinks(from string, links []string) {
+	page, ok := c.pages[from]
+	if !ok || page.depth >= c.maxDepth {
+		return
+	}
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil || (c.samePathPrefix && !hasPathPrefix(u.Path, page.root)) {
+			continue
+		}
+		c.push(crawlPage{url: normalizePageURL(u), depth: page.depth + 1, root: page.root})
+	}
+}
+
+// Lenはこれまでに見つけたページの数（起点のページを含む）を返します。
+func (c *Crawler) Len() int {
+	return len(c.pages)
+}
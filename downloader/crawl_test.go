@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPageLinks(t *testing.T) {
+	base, _ := url.Parse("https://wiki.example.com/docs/top")
+	hrefs := []string{
+		"/docs/setup",
+		"setup#install", // 同じページのフラグメント違い
+		"/docs/v1.2",
+		"https://wiki.example.com/docs/api?revision=3",
+		"https://other.example.com/docs/x",
+		"/attachment/65a1f0c2e4b0a1b2c3d4e5f1",
+		"/_search?q=a",
+		"/admin/users",
+		"/docs/diagram.png",
+		"mailto:someone@example.com",
+		"javascript:void(0)",
+	}
+	want := []string{
+		"https://wiki.example.com/docs/setup",
+		"https://wiki.example.com/docs/v1.2",
+		"https://wiki.example.com/docs/api?revision=3",
+	}
+	if got := pageLinks(base, hrefs); !reflect.DeepEqual(got, want) {
+		t.Errorf("pageLinks = %q, want %q", got, want)
+	}
+}
+
+// crawlはsiteのリンクの構成（ページ → リンク先）でCrawlerを最後まで進め、返したページを順に返します。
+func crawl(c *Crawler, site map[string][]string) []string {
+	var visited []string
+	for {
+		page, ok := c.Next()
+		if !ok {
+			return visited
+		}
+		visited = append(visited, page)
+		c.AddLinks(page, site[page])
+	}
+}
+
+// TestCrawlerDepthはリンクをたどる深さの上限と、SamePathPrefixでたどる範囲を確認します。
+func TestCrawlerDepth(t *testing.T) {
+	const host = "https://wiki.example.com"
+	site := map[string][]string{
+		host + "/docs":       {host + "/docs/a", host + "/blog"},
+		host + "/docs/a":     {host + "/docs/a/b"},
+		host + "/docs/a/b":   {host + "/docs/a/b/c"},
+		host + "/blog":       {host + "/blog/post"},
+		host + "/docs/a/b/c": nil,
+	}
+	tests := []struct {
+		depth      int
+		samePrefix bool
+		want       []string
+	}{
+		{0, false, []string{host + "/docs"}},
+		{1, false, []string{host + "/docs", host + "/docs/a", host + "/blog"}},
+		{2, false, []string{host + "/docs", host + "/docs/a", host + "/blog", host + "/docs/a/b", host + "/blog/post"}},
+		{2, true, []string{host + "/docs", host + "/docs/a", host + "/docs/a/b"}},
+	}
+	for _, tt := range tests {
+		c := NewCrawler([]string{host + "/docs"}, tt.depth, tt.samePrefix)
+		if got := crawl(c, site); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("depth=%d, samePrefix=%v: %q, want %q", tt.depth, tt.samePrefix, got, tt.want)
+		}
+	}
+}
+
+// TestCrawlerVisitedは互いにリンクしているページや、フラグメント違いのURL、重複した起点のページを一度だけ返すことを確認します。
+func TestCrawlerVisited(t *testing.T) {
+	const host = "https://wiki.example.com"
+	site := map[string][]string{
+		host + "/a": {host + "/b", host + "/a#top", host + "/c"},
+		host + "/b": {host + "/a", host + "/c"},
+		host + "/c": {host + "/b", host + "/a"},
+	}
+	c := NewCrawler([]string{host + "/a", host + "/a#section", host + "/b"}, 5, false)
+	want := []string{host + "/a", host + "/b", host + "/c"}
+	if got := crawl(c, site); !reflect.DeepEqual(got, want) {
+		t.Errorf("%q, want %q", got, want)
+	}
+	if c.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", c.Len())
+	}
+}
+
+// TestDownloadPageLinksはDepthを指定した場合に、ページ内のGROWIのページへのリンクを返し、たどったページの画像もダウンロードできることを確認します。
+func TestDownloadPageLinks(t *testing.T) {
+	pages := map[string]string{
+		"/docs":       `<a href="/docs/setup">Setup</a><a href="/attachment/1">添付</a><img src="/top.png">`,
+		"/docs/setup": `<a href="/docs">Top</a><img src="/setup.png">`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Write([]byte(page))
+			return
+		}
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.Depth = 1
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	c := NewCrawler([]string{srv.URL + "/docs"}, opts.Depth, false)
+	var files []string
+	for {
+		pageURL, ok := c.Next()
+		if !ok {
+			break
+		}
+		results, links, err := d.DownloadPage(context.Background(), pageURL)
+		if err != nil {
+			t.Fatalf("DownloadPage(%s): %v", pageURL, err)
+		}
+		if pageURL == srv.URL+"/docs" && !reflect.DeepEqual(links, []string{srv.URL + "/docs/setup"}) {
+			t.Errorf("links = %q", links)
+		}
+		c.AddLinks(pageURL, links)
+		for _, r := range results {
+			files = append(files, r.File)
+		}
+	}
+	if want := []string{"top.png", "setup.png"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+}
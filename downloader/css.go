@@ -0,0 +1,70 @@
+package downloader
+
+import "strings"
+
+// parseCSSURLsはCSSの値（background-imageなど）に含まれるurl(...)のURLを順に返します。
+// 引用符（"と'）で囲まれたURLとバックスラッシュによるエスケープに対応し、
+// "none"やグラデーション（linear-gradient(...)など）のようにurl()でない値は無視します。
+func parseCSSURLs(value string) []string {
+	var urls []string
+	rest := value
+	for {
+		i := indexCSSURL(rest)
+		if i < 0 {
+			return urls
+		}
+		rest = strings.TrimLeft(rest[i+len("url("):], " \t\n\r\f")
+
+		var u string
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			u, rest = readCSSString(rest[1:], rest[0])
+		} else {
+			u, rest = readCSSString(rest, ')')
+		}
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+}
+
+// indexCSSURLはsの中で最初の"url("（ASCIIの大文字・小文字を区別しない）の位置を返します。
+// "url("が識別子の途中にある場合（"myurl("など）は対象にしません。
+// strings.ToLowerは非ASCII文字や不正なUTF-8でバイト数が変わり位置がずれるため、元のバイト列のまま比較します。
+func indexCSSURL(s string) int {
+	for i := 0; i+len("url(") <= len(s); i++ {
+		if isCSSURLPrefix(s[i:]) && (i == 0 || !isCSSIdentChar(s[i-1])) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isCSSURLPrefixはsが"url("（ASCIIの大文字・小文字を区別しない）で始まるかどうかを返します。
+// 英字のバイトは0x20のビットを立てると小文字になり、u・r・lにはそれ以外のバイトが一致しません。
+func isCSSURLPrefix(s string) bool {
+	return len(s) >= 4 && s[0]|0x20 == 'u' && s[1]|0x20 == 'r' && s[2]|0x20 == 'l' && s[3] == '('
+}
+
+// isCSSIdentCharはcがCSSの識別子に使える文字かどうかを返します。
+func isCSSIdentChar(c byte) bool {
+	return c == '-' || c == '_' || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// readCSSStringはsをendの文字まで読み、エスケープを解除した文字列と残りを返します。
+// endが見つからない場合は末尾までを返します。
+func readCSSString(s string, end byte) (string, string) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case c == end:
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), ""
+}
@@ -0,0 +1,37 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseCSSURLsはbackground-imageの値から、引用符・エスケープ・複数の背景に対応してurl()のURLを取得することを確認します。
+func TestParseCSSURLs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"none", nil},
+		{`url("/a.png")`, []string{"/a.png"}},
+		{`url('/a b.png')`, []string{"/a b.png"}},
+		{`url( /a.png )`, []string{"/a.png"}},
+		{`URL("/upper.png")`, []string{"/upper.png"}},
+		{`url("/a.png"), linear-gradient(red, blue), url(/b.png)`, []string{"/a.png", "/b.png"}},
+		{`linear-gradient(to right, rgba(0,0,0,0), #fff)`, nil},
+		{`url("/quote\"d.png")`, []string{`/quote"d.png`}},
+		{`url(/paren\).png)`, []string{"/paren).png"}},
+		{`myurl(/not.png)`, nil},
+		{`url("")`, nil},
+		{`url("/unterminated.png`, []string{"/unterminated.png"}},
+		{`image-set(url("/1x.png") 1x, url("/2x.png") 2x)`, []string{"/1x.png", "/2x.png"}},
+		// 非ASCII文字や不正なUTF-8を含んでも位置がずれない
+		{`İİİ url("/after.png")`, []string{"/after.png"}},
+		{"\xff\xfe url(/x.png) \xc0", []string{"/x.png"}},
+		{`İurl(/ident.png)`, nil},
+	}
+	for _, tt := range tests {
+		if got := parseCSSURLs(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCSSURLs(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// DedupContentはresultsの保存したファイルのうち、先に保存したファイルと内容（SHA-256）が同じファイルを
+// 先のファイルへのハードリンク（作成できない場合はシンボリックリンク）に置き換え、置き換えた件数を返します。
+// 置き換えたファイルの結果にはDuplicateOfに先のファイルのOutDirからの相対パスを記録します。
+// 既に存在するためスキップしたファイルは先のファイルとしてのみ使い、置き換えません。
+func (d *Downloader) DedupContent(results []Result) int {
+	canonical := make(map[string]string) // SHA-256 → 先に保存したファイルのOutDirからの相対パス
+	deduplicated := 0
+	for i := range results {
+		r := &results[i]
+		if !r.Success || r.Filtered || r.File == "" {
+			continue
+		}
+		digest, err := d.contentSHA256(*r)
+		if err != nil {
+			slog.Warn("ファイルのSHA-256を計算できないため、重複の確認から除外します", "file", r.File, "error", err)
+			continue
+		}
+		rel := d.savedPath(*r)
+		first, ok := canonical[digest]
+		if !ok {
+			canonical[digest] = rel
+			continue
+		}
+		if first == rel || r.Skipped {
+			continue
+		}
+		if err := d.linkDuplicate(first, rel); err != nil {
+			slog.Warn("内容が同じファイルをリンクに置き換えられませんでした", "file", rel, "original", first, "error", err)
+			continue
+		}
+		slog.Info("内容が同じファイルをリンクに置き換えました", "file", rel, "original", first)
+		r.DuplicateOf = first
+		deduplicated++
+	}
+	return deduplicated
+}
+
+// linkDuplicateはOutDir/dupをOutDir/firstへのハードリンクに置き換えます。
+// ハードリンクを作成できない場合（ファイルシステムが対応していない場合など）はシンボリックリンクにします。
+// リンクは一時的な名前で作成してからリネームするため、失敗してもdupは元のまま残ります。
+func (d *Downloader) linkDuplicate(first, dup string) error {
+	firstPath := filepath.Join(d.opts.OutDir, first)
+	dupPath := filepath.Join(d.opts.OutDir, dup)
+	if a, err := os.Stat(firstPath); err == nil {
+		if b, err := os.Stat(dupPath); err == nil && os.SameFile(a, b) {
+			return nil
+		}
+	}
+	dir, name := filepath.Split(dupPath)
+	tmpPath := filepath.Join(dir, "."+name+".dedup")
+	os.Remove(tmpPath)
+	if err := os.Link(firstPath, tmpPath); err != nil {
+		target, relErr := filepath.Rel(dir, firstPath)
+		if relErr != nil {
+			return err
+		}
+		if err := os.Symlink(target, tmpPath); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, dupPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDedupContentは別のURLから同じ内容のファイルをダウンロードした場合に、後のファイルを先のファイルへのリンクに置き換えることを確認します。
+func TestDedupContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/diagram.png"><img src="/copy-of-diagram.png"><img src="/other.png"><img src="/diagram-again.png">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/other.png" {
+			w.Write([]byte("other"))
+			return
+		}
+		w.Write([]byte("diagram"))
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n := d.DedupContent(results); n != 2 {
+		t.Errorf("置き換えた件数 = %d, want 2", n)
+	}
+	want := map[string]string{"diagram.png": "", "copy-of-diagram.png": "diagram.png", "other.png": "", "diagram-again.png": "diagram.png"}
+	for _, r := range results {
+		if r.DuplicateOf != want[r.File] {
+			t.Errorf("%s: DuplicateOf = %q, want %q", r.File, r.DuplicateOf, want[r.File])
+		}
+		// 置き換えたファイルも元の名前で読める
+		if got := readFile(t, filepath.Join(opts.OutDir, r.File)); (got == "diagram") != (r.File != "other.png") {
+			t.Errorf("%s の内容 = %q", r.File, got)
+		}
+	}
+	first, _ := os.Stat(filepath.Join(opts.OutDir, "diagram.png"))
+	dup, _ := os.Lstat(filepath.Join(opts.OutDir, "copy-of-diagram.png"))
+	if !os.SameFile(first, dup) && dup.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("copy-of-diagram.png がリンクになっていません: %v", dup.Mode())
+	}
+
+	// 2回目の実行で既存のファイルをスキップした場合も、既に置き換えたファイルは数えない
+	for i := range results {
+		results[i].Skipped, results[i].SHA256, results[i].DuplicateOf = true, "", ""
+	}
+	if n := d.DedupContent(results); n != 0 {
+		t.Errorf("スキップしたファイル: 置き換えた件数 = %d, want 0", n)
+	}
+}
@@ -0,0 +1,28 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/chromedp/device"
+)
+
+// DefaultMobileDeviceは-mobileでエミュレートするデバイスの名前です。
+const DefaultMobileDevice = "iPhone 12"
+
+// LookupDeviceはchromedpのdeviceパッケージに定義されたデバイス（"iPhone 12"や"Pixel 5 landscape"など）を名前で探します。
+// 大文字と小文字、空白の有無は区別しません（"iphone12"でも"iPhone 12"が見つかります）。
+func LookupDevice(name string) (device.Info, error) {
+	want := normalizeDeviceName(name)
+	for d := device.Reset + 1; d <= device.MotoG4landscape; d++ {
+		if info := d.Device(); normalizeDeviceName(info.Name) == want {
+			return info, nil
+		}
+	}
+	return device.Info{}, fmt.Errorf("デバイスが見つかりません（例: %q、\"Pixel 5\"、\"iPad Mini landscape\"）: %q", DefaultMobileDevice, name)
+}
+
+// normalizeDeviceNameはデバイス名を比べるため、小文字にして空白を取り除きます。
+func normalizeDeviceName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), ""))
+}
@@ -0,0 +1,37 @@
+package downloader
+
+import "testing"
+
+func TestLookupDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"iPhone 12", "iPhone 12", false},
+		{"iphone12", "iPhone 12", false},
+		{" pixel 5  LANDSCAPE ", "Pixel 5 landscape", false},
+		{"iPhone 99", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		info, err := LookupDevice(tt.name)
+		if (err != nil) != tt.wantErr || info.Name != tt.want {
+			t.Errorf("LookupDevice(%q) = %q, %v, want %q (エラー: %v)", tt.name, info.Name, err, tt.want, tt.wantErr)
+		}
+		if err == nil && (info.UserAgent == "" || info.Width == 0 || !info.Mobile) {
+			t.Errorf("LookupDevice(%q) = %+v", tt.name, info)
+		}
+	}
+}
+
+// TestNewUnknownDeviceは一覧にないデバイスを指定するとNewがエラーになることを確認します。
+func TestNewUnknownDevice(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.Device = "iPhone 99"
+	if d, err := New(opts); err == nil {
+		d.Close()
+		t.Error("エラーになりませんでした")
+	}
+}
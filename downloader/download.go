@@ -0,0 +1,670 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RetryPolicyはダウンロード失敗時の再試行の設定です。
+type RetryPolicy struct {
+	Retries int           // 最大再試行回数
+	Wait    time.Duration // 初回の待ち時間（再試行ごとに2倍になる）
+	MaxWait time.Duration // 待ち時間の上限
+}
+
+// DownloadOptionsはダウンロードの設定です。
+type DownloadOptions struct {
+	OutDir    string        // 保存先ディレクトリ
+	Overwrite bool          // 既存のファイルを上書きするかどうか
+	Retry     RetryPolicy   // 再試行の設定
+	Timeout   time.Duration // 1回のダウンロード（リクエストから保存まで）にかける時間の上限（0の場合は制限しない）
+	UserAgent string        // リクエストのUser-Agent（空の場合はGoの既定値）
+	Header    http.Header   // 全てのリクエストに追加するヘッダ（User-AgentとRefererより優先）
+	Referer   string        // リクエストのRefererヘッダ（空の場合は送らない）
+	ExtFilter ExtFilter     // 拡張子による絞り込み（レスポンスで決まった拡張子にも適用する）
+	// Namesには実行中に保存したファイルを記録し、別のURLのファイルが同じ名前になった場合に番号を付けて区別します
+	// （nilの場合は同じ実行で保存したファイルと既存のファイルを区別できません）。
+	Names *NameRegistry
+	// IgnoreContentDispositionの場合はContent-Dispositionのファイル名を使わず、指定したファイル名で保存します。
+	IgnoreContentDisposition bool
+	Verify                   bool   // 保存したファイルが拡張子の形式のものか確認し、違う場合は削除してエラーにするかどうか
+	CheckType                bool   // Content-Typeがファイルの拡張子と一致しない場合にログに出力するかどうか
+	FixExt                   bool   // CheckTypeで一致しない場合に、ファイルの拡張子をContent-Typeのものに付け替えるかどうか
+	MaxSize                  int64  // ファイルサイズの上限（バイト数。0の場合は制限しない）
+	ConvertTo                string // 保存した画像を変換する形式（"jpeg"か"png"。空の場合は変換しない。ParseConvertFormatで指定値を変換できる）
+	JPEGQuality              int    // JPEGに変換する場合の品質（1～100。0の場合はDefaultJPEGQuality）
+	StripMetadata            bool   // 保存したJPEG・PNGからEXIF・IPTC・XMPなどのメタデータを取り除くかどうか
+	SidecarAlt               bool   // 画像のalt属性が空でない場合に、保存したファイルの隣に"<ファイル名>.txt"として書き込むかどうか
+	// Downloadedにはダウンロードして保存したファイルのバイト数を加算します（nilの場合は数えません）。
+	// 同時に実行するダウンロードで共有するため、アトミックに加算します。
+	Downloaded *atomic.Int64
+	// Limiterは同時に実行する全てのダウンロードで共有する転送速度の制限です（nilの場合は制限しません）。
+	Limiter *rate.Limiter
+	// HostLimitは同時に実行する全てのダウンロードで共有する、ホストごとの同時ダウンロード数の制限です（nilの場合は制限しません）。
+	HostLimit *HostLimiter
+	// Stateにはダウンロードしたファイルを記録し、記録のあるURLには条件付きリクエストを送ります（nilの場合は記録しません）。
+	State *State
+	// Archiveを指定した場合はOutDirにファイルを保存せず、アーカイブにOutDirからの相対パスで書き込みます。
+	// 既存のファイルの確認とStateは使いません。
+	Archive *Archive
+	// Progressにはダウンロードの対象の件数と終わった件数を記録します（nilの場合は記録しません）。
+	Progress *Progress
+	// Thumbnailsには保存した画像を渡し、サムネイルを作成させます（nilの場合は作成しません）。
+	Thumbnails *Thumbnailer
+	// JSONLにはダウンロードが1件終わるごとに結果を書き込みます（nilの場合は書き込みません）。
+	JSONL *JSONLWriter
+}
+
+// DownloadResultはダウンロード1件の結果です。
+type DownloadResult struct {
+	FileName    string // 保存したファイル名（Content-Dispositionなどで決まった最終的な名前）
+	Size        int64  // 保存したファイルのバイト数（続きから再開した場合は前回までの分を含む）
+	ContentType string
+	StatusCode  int
+	FinalURL    string // リダイレクトされた場合の最終的なURL（されなかった場合は空）
+	SHA256      string // 保存したファイルの内容のSHA-256（16進数。保存しなかった場合は空）
+	Skipped     bool   // 既に存在するためダウンロードしなかった
+	Filtered    bool   // 拡張子やサイズが絞り込みの対象外のため保存しなかった（Skippedもtrueになる）
+}
+
+// DownloadFileは指定URLからデータを取得し、opts.OutDir/fileNameとして保存します。
+// clientにはページのセッションCookieを持つHTTPクライアントを渡します。
+// 受信したデータがContent-Lengthより短い場合（接続が途中で切れた場合）は、opts.Retryに従ってやり直します。
+func DownloadFile(ctx context.Context, client *http.Client, urlStr, fileName string, opts DownloadOptions) (DownloadResult, error) {
+	policy := opts.Retry
+	for attempt := 0; ; attempt++ {
+		// 再試行を待つ間は同じホストの別のダウンロードに枠を譲る
+		release, err := opts.HostLimit.acquire(ctx, urlStr)
+		if err != nil {
+			return DownloadResult{FileName: fileName}, err
+		}
+		result, err := downloadFileWithTimeout(ctx, client, urlStr, fileName, opts)
+		release()
+		if !errors.Is(err, errTruncated) || attempt >= policy.Retries || ctx.Err() != nil {
+			return result, err
+		}
+		wait := backoff(policy.Wait, attempt)
+		if policy.MaxWait > 0 && wait > policy.MaxWait {
+			wait = policy.MaxWait
+		}
+		slog.Warn("受信したデータが途中で切れたため再試行します", "url", urlStr, "attempt", attempt+1, "retries", policy.Retries, "wait", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+}
+
+// downloadFileWithTimeoutはopts.Timeoutを上限としてdownloadFileを実行します。
+// 応答しないサーバーの画像で他のダウンロードを待たせないよう、時間を超えた場合はダウンロードを中止してエラーを返します（再試行しません）。
+func downloadFileWithTimeout(ctx context.Context, client *http.Client, urlStr, fileName string, opts DownloadOptions) (DownloadResult, error) {
+	if opts.Timeout <= 0 {
+		return downloadFile(ctx, client, urlStr, fileName, opts)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	result, err := downloadFile(timeoutCtx, client, urlStr, fileName, opts)
+	if err != nil && ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("ダウンロードが%vを超えたため中止しました: %w", opts.Timeout, err)
+	}
+	return result, err
+}
+
+// downloadFileはDownloadFileの1回の試行です。
+// 保存先が既に存在する場合（またはopts.Stateに前回の記録がある場合）は条件付きリクエストを送り、304ならスキップし、
+// 変更されていれば同じファイルに上書きします（opts.Overwriteの場合は送りません）。URLに拡張子がなく保存先の名前が
+// レスポンスで決まる場合は、リクエストを送ってから既存のファイルを確認します。別のURLに使った名前には番号を付けます。
+// サーバーがRangeリクエストに対応している場合は途中までのファイル（partialPath）を残し、次の試行では続きから取得します。
+func downloadFile(ctx context.Context, client *http.Client, urlStr, fileName string, opts DownloadOptions) (DownloadResult, error) {
+	result := DownloadResult{FileName: fileName}
+	if err := checkSavePath(opts.OutDir, fileName, urlStr); err != nil {
+		return result, err
+	}
+
+	var entry StateEntry
+	var cached bool
+	if opts.Archive == nil {
+		entry, cached = opts.State.cached(urlStr, opts.OutDir)
+	}
+	if !cached && !opts.Overwrite && opts.Archive == nil {
+		// 既にファイルがあれば、その更新日時より後に変更されている場合のみダウンロードする
+		// （URLに拡張子がない場合（/attachment/<id>など）は保存したファイル名がレスポンスで決まるため、ここでは見つからず、
+		// リクエストを送ってから既存のファイルとサイズを比べる）
+		entry, cached = existingEntry(opts.Names, opts.OutDir, fileName, urlStr)
+	}
+	var header http.Header
+	if cached {
+		header = entry.conditionalHeader()
+	}
+	// 前回の途中までのファイルがあれば、Rangeリクエストで続きから取得する
+	partPath, offset := "", int64(0)
+	if !cached && !opts.Overwrite && opts.Archive == nil {
+		partPath = partialPath(opts.OutDir, fileName, urlStr)
+		if offset = partialSize(partPath); offset > 0 {
+			header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+		}
+	}
+
+	resp, err := getWithRetry(ctx, client, urlStr, header, opts)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	result.ContentType = resp.Header.Get("Content-Type")
+	if result.FinalURL = redirectedURL(resp); result.FinalURL != "" {
+		slog.Debug("リダイレクトされました", "url", displayURL(urlStr), "final_url", result.FinalURL)
+	}
+	if cached && resp.StatusCode == http.StatusNotModified {
+		if name, ok := opts.Names.reserveExisting(opts.OutDir, entry.relPath(opts.OutDir), urlStr); ok {
+			slog.Info("スキップしました (既に存在し、変更されていません)", "file", name)
+			result.FileName, result.Skipped = name, true
+			return result, nil
+		}
+	}
+	resumed := offset > 0 && resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp.Header.Get("Content-Range")) == offset
+	if offset > 0 && !resumed && (resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+		// 要求した位置から返されなかった場合（途中までのファイルがサーバーのファイルより大きい場合など）は最初から取得し直す
+		slog.Warn("途中までのファイルの続きを取得できないため、最初から取得し直します", "url", urlStr, "status", resp.Status)
+		resp.Body.Close()
+		os.Remove(partPath)
+		return downloadFile(ctx, client, urlStr, fileName, opts)
+	}
+	if resp.StatusCode != http.StatusOK && !resumed {
+		return result, fmt.Errorf("HTTPステータスがOKではありません: %s", resp.Status)
+	}
+	encodings := contentEncodings(resp)
+	if resumed && len(encodings) > 0 {
+		// 圧縮されたデータの続きは展開できないため、最初から取得し直す
+		slog.Warn("圧縮された続きは使えないため、最初から取得し直します", "url", urlStr, "content_encoding", resp.Header.Get("Content-Encoding"))
+		resp.Body.Close()
+		os.Remove(partPath)
+		return downloadFile(ctx, client, urlStr, fileName, opts)
+	}
+	// 206の場合のContent-Lengthは続きの部分の長さのため、ファイル全体のサイズにする
+	size := resp.ContentLength
+	if resumed && size >= 0 {
+		size += offset
+	}
+	if len(encodings) > 0 {
+		// 圧縮されている場合のContent-Lengthは圧縮後の大きさのため、保存するファイルの大きさは分からない
+		size = -1
+	}
+
+	fileName = responseFileName(fileName, resp, opts)
+	if opts.CheckType {
+		fileName = checkContentType(urlStr, fileName, result.ContentType, opts.FixExt)
+	}
+	if cached {
+		// 変更されたファイル（または条件付きリクエストに対応していないサーバーのファイル）は既存のファイルに上書きする
+		fileName = entry.relPath(opts.OutDir)
+	}
+	result.FileName = fileName
+	if err := checkSavePath(opts.OutDir, fileName, urlStr); err != nil {
+		return result, err
+	}
+	// URLに拡張子がなくContent-Typeで拡張子が決まった場合も、ここで絞り込む
+	if !opts.ExtFilter.Allow(filepath.Ext(fileName)) {
+		slog.Info("拡張子が対象外のためスキップしました", "file", fileName)
+		result.Skipped, result.Filtered = true, true
+		return result, nil
+	}
+
+	if opts.MaxSize > 0 && size > opts.MaxSize {
+		slog.Info("サイズが上限を超えるためスキップしました", "url", urlStr, "size", size, "max_size", opts.MaxSize)
+		result.Skipped, result.Filtered = true, true
+		return result, nil
+	}
+
+	var body io.Reader = resp.Body
+	if resp.ContentLength >= 0 {
+		body = &contentLengthReader{r: body, want: resp.ContentLength}
+	}
+	if len(encodings) > 0 {
+		if body, err = decodeBody(body, encodings); err != nil {
+			return result, err
+		}
+	}
+	if opts.MaxSize > 0 {
+		body = &maxSizeReader{r: body, max: opts.MaxSize - offset}
+	}
+	if opts.Limiter != nil {
+		body = &rateLimitedReader{ctx: ctx, r: body, limiter: opts.Limiter}
+	}
+	// 保存しながらSHA-256を計算する（ファイルを読み直さずに済むように）
+	sum := sha256.New()
+	body = io.TeeReader(body, sum)
+
+	var filePath string
+	if opts.Archive != nil {
+		result.FileName, result.Size, err = opts.Archive.add(archiveEntryName(opts.OutDir, fileName), body, responseModTime(resp), opts.Verify)
+	} else {
+		// Content-Dispositionなどで決まった名前のファイルが既にある場合も、サイズがContent-Lengthと同じならスキップする
+		name, exists := opts.Names.reserve(opts.OutDir, fileName, urlStr, size, opts.Overwrite || cached)
+		result.FileName = name
+		if exists {
+			slog.Info("スキップしました (既に存在します)", "file", name)
+			result.Skipped = true
+			return result, nil
+		}
+		if name != fileName {
+			slog.Info("別のURLのファイルと名前が同じため、番号を付けて保存します", "url", urlStr, "file", name)
+		}
+		filePath = filepath.Join(opts.OutDir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return result, err
+		}
+		switch {
+		case resumed:
+			slog.Info("途中までのファイルの続きからダウンロードします", "file", name, "offset", offset)
+			if err := hashFile(sum, partPath); err != nil {
+				return result, err
+			}
+			result.Size, err = writeFilePartial(filePath, partPath, body, true)
+			result.Size += offset
+		case partPath != "" && acceptsRanges(resp) && len(encodings) == 0:
+			// 接続が切れても続きから再開できるよう、途中までのファイルを残す
+			result.Size, err = writeFilePartial(filePath, partPath, body, false)
+		default:
+			if offset > 0 {
+				// Rangeリクエストを無視して全体を返すサーバーのため、途中までのファイルは使わない
+				os.Remove(partPath)
+			}
+			result.Size, err = writeFileAtomic(filePath, body)
+		}
+	}
+	if errors.Is(err, errTooLarge) {
+		if partPath != "" {
+			os.Remove(partPath)
+		}
+		slog.Info("サイズが上限を超えたため書き込みを中止してスキップしました", "url", urlStr, "size", result.Size, "max_size", opts.MaxSize)
+		result.Size, result.Skipped, result.Filtered = 0, true, true
+		return result, nil
+	}
+	if err == nil {
+		result.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	}
+	if err != nil || opts.Archive != nil {
+		return result, err
+	}
+	// 変換後のファイルを記録するため、状態ファイルへの記録より前に変換し、メタデータを取り除く
+	if filePath, err = convertSaved(filePath, urlStr, &result, opts); err != nil {
+		return result, err
+	}
+	if err := stripSaved(filePath, &result, opts); err != nil {
+		return result, err
+	}
+	if err := verifySaved(filePath, opts); err != nil {
+		return result, err
+	}
+	setModTime(filePath, resp)
+	opts.State.record(urlStr, filePath, resp)
+	return result, nil
+}
+
+// errTruncatedは受信したデータがContent-Lengthより短いことを表します。
+var errTruncated = errors.New("受信したデータがContent-Lengthより短いため保存しません")
+
+// contentLengthReaderはrの終わりまでに読んだバイト数がwant（Content-Length）と異なる場合にerrTruncatedを返すio.Readerです。
+// 書き込みはwriteFileAtomicの一時ファイルで行うため、途中で切れたファイルは保存先に残りません。
+type contentLengthReader struct {
+	r    io.Reader
+	want int64
+	read int64
+}
+
+// Readはrから読み、終わりに達した時点でContent-Lengthと読んだバイト数を比べます。
+func (r *contentLengthReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	// 接続が切れた場合、net/httpはio.ErrUnexpectedEOFを返す
+	if (err == io.EOF && r.read != r.want) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, fmt.Errorf("%w（Content-Length: %d、受信: %dバイト）", errTruncated, r.want, r.read)
+	}
+	return n, err
+}
+
+// responseModTimeはレスポンスのLast-Modifiedの日時を返します。ない場合は現在の日時を返します。
+func responseModTime(resp *http.Response) time.Time {
+	if t, ok := lastModified(resp); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// lastModifiedはレスポンスのLast-Modifiedの日時を返します。HTTPの3つの日時の形式のほか、
+// 一部のサーバーが返す数値のタイムゾーン（"+0900"など）の形式も受け付けます。
+func lastModified(resp *http.Response) (time.Time, bool) {
+	value := strings.TrimSpace(resp.Header.Get("Last-Modified"))
+	if value == "" {
+		return time.Time{}, false
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC1123Z, value); err == nil {
+		return t, true
+	}
+	slog.Debug("Last-Modifiedの日時を解析できません", "last_modified", value)
+	return time.Time{}, false
+}
+
+// setModTimeは保存したファイルの更新日時をレスポンスのLast-Modifiedの日時にします（ない場合は変えません）。
+// 次回の実行で既存のファイルの更新日時をIf-Modified-Sinceに使えるよう、またrsyncなどで比べられるようにします。
+func setModTime(filePath string, resp *http.Response) {
+	t, ok := lastModified(resp)
+	if !ok {
+		return
+	}
+	// アクセス日時は変えない
+	if err := os.Chtimes(filePath, time.Time{}, t); err != nil {
+		slog.Warn("ファイルの更新日時を設定できません", "file", filePath, "error", err)
+	}
+}
+
+// checkSavePathはcheckFileNameでurlStrの保存先のファイル名を確認し、dirの外を指している場合はログに出力してエラーを返します。
+func checkSavePath(dir, fileName, urlStr string) error {
+	err := checkFileName(dir, fileName)
+	if err != nil {
+		slog.Error("保存先が保存先ディレクトリの外を指しているため保存しません", "url", urlStr, "file", fileName)
+	}
+	return err
+}
+
+// existingEntryはdir/fileName（またはこの実行で別のURLに使われていない番号付きの名前）の既存のファイルをurlStrの保存先として予約し、
+// ファイルの更新日時をLast-Modifiedとした記録を返します。ファイルがない場合は何も予約しません。
+func existingEntry(names *NameRegistry, dir, fileName, urlStr string) (StateEntry, bool) {
+	name, ok := names.reserveExisting(dir, fileName, urlStr)
+	if !ok {
+		return StateEntry{}, false
+	}
+	filePath := filepath.Join(dir, name)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return StateEntry{}, false
+	}
+	return StateEntry{LastModified: info.ModTime().UTC().Format(http.TimeFormat), Path: filePath}, true
+}
+
+// responseFileNameはレスポンスのヘッダから保存するファイル名を決めます。
+// サーバーがContent-Dispositionでファイル名を指定していればURL由来のfileNameより優先し、
+// 拡張子がない場合（/attachment/649abcなど）は、リダイレクト先のURL（署名付きのCDNのURLなど）の拡張子か、
+// それもない場合はContent-Typeから拡張子を付けます。
+func responseFileName(fileName string, resp *http.Response, opts DownloadOptions) string {
+	if !opts.IgnoreContentDisposition {
+		if name := sanitizeFilename(contentDispositionFilename(resp.Header.Get("Content-Disposition"))); name != "" {
+			// URLのディレクトリ構造を再現する場合（PreservePath）は、ディレクトリをそのままにファイル名だけを置き換える
+			fileName = filepath.Join(filepath.Dir(fileName), name)
+		}
+	}
+	if filepath.Ext(fileName) == "" {
+		if ext := redirectedExt(resp); ext != "" {
+			fileName += ext
+		} else {
+			fileName += GetFileExtension(fileName, resp.Header.Get("Content-Type"))
+		}
+	}
+	return fileName
+}
+
+// redirectedURLはrespがリダイレクトの後のレスポンスの場合に最終的なURLを返します（リダイレクトされていない場合は空）。
+func redirectedURL(resp *http.Response) string {
+	if resp.Request == nil || resp.Request.Response == nil {
+		return ""
+	}
+	return resp.Request.URL.String()
+}
+
+// redirectedExtはリダイレクト先のURLのパスの拡張子を返します。
+// リダイレクトされていない場合や、拡張子として使えない文字を含む場合は空を返します。
+func redirectedExt(resp *http.Response) string {
+	if redirectedURL(resp) == "" {
+		return ""
+	}
+	ext := path.Ext(resp.Request.URL.Path)
+	if len(ext) < 2 || len(ext) > maxRedirectedExtLen || strings.IndexFunc(ext[1:], func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) >= 0 {
+		return ""
+	}
+	return ext
+}
+
+// maxRedirectedExtLenはリダイレクト先のURLから使う拡張子の長さの上限です（"."を含む）。
+const maxRedirectedExtLen = 8
+
+// writeFileAtomicはrの内容を同じディレクトリの一時ファイル（.<ファイル名>.tmp-<ランダムな文字列>）に書き込み、
+// 全て書き込めた場合のみfilePathにリネームします。
+// 途中で失敗した場合は一時ファイルを削除するため、中断しても不完全なファイルがfilePathに残りません。
+// 一時ファイルの名前は呼び出しごとに異なるため、同じfilePathに同時に書き込んでも内容が混ざりません。
+// 書き込んだバイト数を返します。
+func writeFileAtomic(filePath string, r io.Reader) (n int64, err error) {
+	dir, name := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	tmpFile, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// CreateTempは所有者のみ読み書きできるファイルを作るため、通常のファイルと同じ権限にする
+	if err = tmpFile.Chmod(0644); err != nil {
+		return 0, err
+	}
+	if n, err = io.Copy(tmpFile, r); err != nil {
+		return n, err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmpPath, filePath)
+}
+
+// newRequestはopts.UserAgentなどのヘッダを設定したurlStrへのmethodのリクエストを作成します。
+func newRequest(ctx context.Context, method, urlStr string, opts DownloadOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	if opts.Referer != "" {
+		req.Header.Set("Referer", opts.Referer)
+	}
+	for key, values := range opts.Header {
+		req.Header[key] = values
+	}
+	return req, nil
+}
+
+// getWithRetryはurlStrをheader（条件付きリクエストのヘッダなど。nilでもよい）を追加してGETし、
+// ネットワークエラーと5xx/429の場合はopts.Retryに従って再試行します。
+// 最後の試行のレスポンスはステータスに関わらずそのまま返します。
+// ctxがキャンセルされた場合は再試行を待たずにctx.Err()を返します。
+func getWithRetry(ctx context.Context, client *http.Client, urlStr string, header http.Header, opts DownloadOptions) (*http.Response, error) {
+	policy := opts.Retry
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest(ctx, http.MethodGet, urlStr, opts)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := client.Do(req)
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= policy.Retries {
+			return resp, err
+		}
+
+		wait := backoff(policy.Wait, attempt)
+		if err == nil {
+			if d, ok := retryAfter(resp, time.Now()); ok {
+				wait = d
+			}
+			resp.Body.Close()
+		}
+		if policy.MaxWait > 0 && wait > policy.MaxWait {
+			wait = policy.MaxWait
+		}
+
+		if err != nil {
+			slog.Warn("リクエストに失敗したため再試行します", "url", urlStr, "attempt", attempt+1, "retries", policy.Retries, "wait", wait, "error", err)
+		} else {
+			slog.Warn("HTTPステータスが再試行の対象のため再試行します", "url", urlStr, "status", resp.Status, "attempt", attempt+1, "retries", policy.Retries, "wait", wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// isImageURLはurlStrが画像を指しているかどうかを返します。
+// URLの拡張子で判断できない場合は、HEADリクエストのContent-Typeで判断します。
+func isImageURL(ctx context.Context, client *http.Client, urlStr string, opts DownloadOptions) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+	if ext := path.Ext(u.Path); ext != "" {
+		return strings.HasPrefix(mime.TypeByExtension(ext), "image/")
+	}
+	req, err := newRequest(ctx, http.MethodHead, urlStr, opts)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Debug("リンク先の種類を確認できませんでした", "url", urlStr, "error", err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "image/")
+}
+
+// isRetryableStatusは再試行すべきHTTPステータス（5xxと429）かどうかを返します。
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// backoffはattempt回目の再試行までの待ち時間（base×2^attempt＋最大50%のジッター）を返します。
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << attempt
+	if d <= 0 {
+		return 0
+	}
+	return d + rand.N(d/2+1)
+}
+
+// retryAfterは429/503レスポンスのRetry-Afterヘッダを待ち時間として返します。
+// 秒数とHTTP日付の両方の形式に対応し、HTTP日付の場合はnowからの差分を返します。
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// saveDataURIはdata: URIをデコードし、MIMEタイプに応じた拡張子を付けてopts.OutDir/baseNameとして保存します。
+// 既存のファイルと同じ名前になった場合の扱いはDownloadFileと同じです。
+func saveDataURI(uri, baseName string, opts DownloadOptions) (DownloadResult, error) {
+	mediaType, data, err := decodeDataURI(uri)
+	if err != nil {
+		return DownloadResult{FileName: baseName}, err
+	}
+	result := DownloadResult{FileName: baseName + extensionForMIME(mediaType), ContentType: mediaType}
+	if err := checkSavePath(opts.OutDir, result.FileName, displayURL(uri)); err != nil {
+		return result, err
+	}
+	if !opts.ExtFilter.Allow(filepath.Ext(result.FileName)) {
+		slog.Info("拡張子が対象外のためスキップしました", "file", result.FileName)
+		result.Skipped, result.Filtered = true, true
+		return result, nil
+	}
+	if opts.MaxSize > 0 && int64(len(data)) > opts.MaxSize {
+		slog.Info("サイズが上限を超えるためスキップしました", "file", result.FileName, "size", len(data), "max_size", opts.MaxSize)
+		result.Skipped, result.Filtered = true, true
+		return result, nil
+	}
+	sum := sha256.Sum256(data)
+	result.SHA256 = hex.EncodeToString(sum[:])
+	if opts.Archive != nil {
+		result.FileName, result.Size, err = opts.Archive.add(archiveEntryName(opts.OutDir, result.FileName), bytes.NewReader(data), time.Now(), opts.Verify)
+		return result, err
+	}
+	// HTTPでダウンロードするファイルと同じく、既存のファイルはOverwriteでなければスキップし、別のURLに使った名前には番号を付ける
+	name, exists := opts.Names.reserve(opts.OutDir, result.FileName, uri, int64(len(data)), opts.Overwrite)
+	if exists {
+		slog.Info("スキップしました (既に存在します)", "file", name)
+		result.FileName, result.Skipped = name, true
+		return result, nil
+	}
+	if name != result.FileName {
+		slog.Info("別のURLのファイルと名前が同じため、番号を付けて保存します", "url", displayURL(uri), "file", name)
+	}
+	result.FileName = name
+	filePath := filepath.Join(opts.OutDir, name)
+	if result.Size, err = writeFileAtomic(filePath, bytes.NewReader(data)); err != nil {
+		return result, err
+	}
+	if filePath, err = convertSaved(filePath, uri, &result, opts); err != nil {
+		return result, err
+	}
+	if err := stripSaved(filePath, &result, opts); err != nil {
+		return result, err
+	}
+	return result, verifySaved(filePath, opts)
+}
@@ -0,0 +1,789 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// readFileはテストで保存されたファイルの内容を返します。存在しない場合はテストを失敗させます。
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ファイルを読み込めません: %v", err)
+	}
+	return string(data)
+}
+
+// TestDownloadFileSessionCookieはページのCookieを持つクライアントの場合のみ、ログインが必要な画像を保存できることを確認します。
+func TestDownloadFileSessionCookie(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+	pageURL, _ := url.Parse(srv.URL + "/page")
+
+	t.Run("Cookieあり", func(t *testing.T) {
+		cookies := []*network.Cookie{{Name: "session", Value: "secret", Path: "/", Domain: pageURL.Hostname()}}
+		client, err := newSessionClient(pageURL, cookies, srv.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := DownloadOptions{OutDir: t.TempDir()}
+		if _, err := DownloadFile(context.Background(), client, srv.URL+"/a.png", "a.png", opts); err != nil {
+			t.Fatalf("DownloadFile: %v", err)
+		}
+		if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != "png" {
+			t.Errorf("内容 = %q, want %q", got, "png")
+		}
+	})
+
+	t.Run("Cookieなし", func(t *testing.T) {
+		client, err := newSessionClient(pageURL, nil, srv.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := DownloadOptions{OutDir: t.TempDir()}
+		if _, err := DownloadFile(context.Background(), client, srv.URL+"/a.png", "a.png", opts); err == nil {
+			t.Fatal("エラーになりませんでした")
+		}
+		if _, err := os.Stat(filepath.Join(opts.OutDir, "a.png")); !os.IsNotExist(err) {
+			t.Errorf("ファイルが保存されています: %v", err)
+		}
+	})
+}
+
+// TestDownloadHeadersは-headerのヘッダをページの取得と画像のダウンロードのリクエストに付け、User-Agentより優先することを確認します。
+func TestDownloadHeaders(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-User") != "alice" || len(r.Header.Values("X-Bypass")) != 2 || r.UserAgent() != "custom" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		requests.Add(1)
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/a.png">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.Headers = http.Header{"X-Forwarded-User": {"alice"}, "X-Bypass": {"1", "2"}, "User-Agent": {"custom"}}
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	// robots.txt・ページ・画像の全てのリクエストにヘッダを送る
+	if len(results) != 1 || !results[0].Success || requests.Load() != 3 {
+		t.Errorf("results = %+v, requests = %d", results, requests.Load())
+	}
+}
+
+// TestDownloadRefererは画像のリクエストのRefererにページのURL（Refererを指定した場合はその値）を送り、
+// Refererが必要な直リンク防止のサーバーからもダウンロードできることを確認します。
+func TestDownloadReferer(t *testing.T) {
+	var srv *httptest.Server
+	want := ""
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/a.png">`))
+			return
+		}
+		if r.Referer() != want {
+			http.Error(w, "hotlinking is not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		sendReferer bool
+		referer     string
+		want        string
+	}{
+		{"ページのURL", true, "", srv.URL + "/page"},
+		{"指定したURL", true, "https://growi.example.com/", "https://growi.example.com/"},
+		{"送らない", false, "https://growi.example.com/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.OutDir = t.TempDir()
+			opts.NoBrowser = true
+			opts.SendReferer, opts.Referer = tt.sendReferer, tt.referer
+			d, err := New(opts)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer d.Close()
+
+			want = "https://other.example.com/"
+			results, _ := d.Download(context.Background(), srv.URL+"/page")
+			if len(results) != 1 || results[0].Success || results[0].Status != http.StatusForbidden {
+				t.Errorf("異なるReferer: results = %+v", results)
+			}
+			want = tt.want
+			results, err = d.Download(context.Background(), srv.URL+"/page")
+			if err != nil || len(results) != 1 || !results[0].Success {
+				t.Errorf("results = %+v, err = %v", results, err)
+			}
+		})
+	}
+}
+
+// TestDownloadFileRedirectはリダイレクトをたどり、元のURLに拡張子がない場合はリダイレクト先のURLの拡張子を付けることと、
+// NoFollowとMaxRedirectsでリダイレクトを制限できることを確認します。
+func TestDownloadFileRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/attachment/649abc", http.RedirectHandler("/signed/649abc", http.StatusFound))
+	mux.Handle("/signed/649abc", http.RedirectHandler("/cdn/photo.jpg?sig=abc", http.StatusFound))
+	mux.Handle("/attachment/report.pdf", http.RedirectHandler("/cdn/photo.jpg", http.StatusFound))
+	mux.Handle("/attachment/weird", http.RedirectHandler("/cdn/file.tar~1", http.StatusFound))
+	mux.HandleFunc("/cdn/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path     string
+		fileName string
+		want     string
+	}{
+		{"/attachment/649abc", "649abc", "649abc.jpg"},
+		{"/attachment/report.pdf", "report.pdf", "report.pdf"},
+		{"/attachment/weird", "weird", "weird.bin"},
+	}
+	for _, tt := range tests {
+		opts := DownloadOptions{OutDir: t.TempDir()}
+		result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+tt.path, tt.fileName, opts)
+		if err != nil {
+			t.Fatalf("%s: DownloadFile: %v", tt.path, err)
+		}
+		if result.FileName != tt.want || !strings.HasPrefix(result.FinalURL, srv.URL+"/cdn/") {
+			t.Errorf("%s: result = %+v, want %s", tt.path, result, tt.want)
+		}
+	}
+
+	// リダイレクトされない場合はFinalURLを空にする
+	result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/cdn/a.png", "a.png", DownloadOptions{OutDir: t.TempDir()})
+	if err != nil || result.FinalURL != "" {
+		t.Errorf("リダイレクトなし: result = %+v, err = %v", result, err)
+	}
+
+	client := withRedirectPolicy(srv.Client(), true, 0)
+	result, err = DownloadFile(context.Background(), client, srv.URL+"/attachment/649abc", "649abc", DownloadOptions{OutDir: t.TempDir()})
+	if err == nil || result.StatusCode != http.StatusFound {
+		t.Errorf("NoFollow: result = %+v, err = %v", result, err)
+	}
+	client = withRedirectPolicy(srv.Client(), false, 1)
+	if _, err = DownloadFile(context.Background(), client, srv.URL+"/attachment/649abc", "649abc", DownloadOptions{OutDir: t.TempDir()}); err == nil || !strings.Contains(err.Error(), "リダイレクトが1回を超えました") {
+		t.Errorf("MaxRedirects: err = %v", err)
+	}
+	if _, err = DownloadFile(context.Background(), client, srv.URL+"/attachment/report.pdf", "report.pdf", DownloadOptions{OutDir: t.TempDir()}); err != nil {
+		t.Errorf("MaxRedirects以内: err = %v", err)
+	}
+}
+
+// TestRunDownloadsConcurrencyは同時ダウンロード数によらず全ファイルを正しく保存し、同時実行数が上限を超えないことを確認します。
+func TestRunDownloadsConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 8} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			var inFlight, maxInFlight atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				w.Write([]byte(r.URL.Path))
+			}))
+			defer srv.Close()
+
+			const n = 20
+			jobs := make([]downloadJob, n)
+			for i := range jobs {
+				name := fmt.Sprintf("%d.png", i)
+				jobs[i] = downloadJob{source: name, url: srv.URL + "/" + name, fileName: name}
+			}
+			opts := DownloadOptions{OutDir: t.TempDir(), Downloaded: new(atomic.Int64)}
+			results, err := runDownloads(context.Background(), srv.Client(), srv.URL, jobs, concurrency, false, opts)
+			if err != nil {
+				t.Fatalf("runDownloads: %v", err)
+			}
+			for i, r := range results {
+				if !r.Success || r.File != jobs[i].fileName {
+					t.Errorf("results[%d] = %+v", i, r)
+				}
+				if got := readFile(t, filepath.Join(opts.OutDir, jobs[i].fileName)); got != "/"+jobs[i].fileName {
+					t.Errorf("%s の内容 = %q", jobs[i].fileName, got)
+				}
+			}
+			var wantBytes int64
+			for _, job := range jobs {
+				wantBytes += int64(len("/" + job.fileName))
+			}
+			if got := opts.Downloaded.Load(); got != wantBytes {
+				t.Errorf("ダウンロードしたバイト数の合計 = %d, want %d", got, wantBytes)
+			}
+			if got := maxInFlight.Load(); got > int32(concurrency) {
+				t.Errorf("同時実行数 = %d, 上限 %d", got, concurrency)
+			}
+			if concurrency > 1 && maxInFlight.Load() < 2 {
+				t.Errorf("並行してダウンロードされていません")
+			}
+		})
+	}
+}
+
+// TestDownloadFileRetryは2回失敗した後に成功するサーバーから、再試行によりダウンロードできることを確認します。
+func TestDownloadFileRetry(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Retry: RetryPolicy{Retries: 3, Wait: time.Millisecond}}
+	if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("リクエスト数 = %d, want 3", got)
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != "ok" {
+		t.Errorf("内容 = %q, want %q", got, "ok")
+	}
+}
+
+// TestDownloadFileTruncatedはContent-Lengthより短いデータで接続が切れた場合に、ファイルを残さずに再試行することを確認します。
+func TestDownloadFileTruncated(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			w.Write([]byte("0123456789"))
+			return
+		}
+		// Content-Lengthより短いデータを送って接続を切る
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: image/png\r\nContent-Length: 10\r\n\r\n01234")
+		buf.Flush()
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Retry: RetryPolicy{Wait: time.Millisecond}}
+	if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts); !errors.Is(err, errTruncated) {
+		t.Errorf("再試行なし: err = %v", err)
+	}
+	if entries, _ := os.ReadDir(opts.OutDir); len(entries) != 0 {
+		t.Errorf("途中で切れたファイルが残っています: %v", entries)
+	}
+
+	requests.Store(0)
+	opts.Retry.Retries = 1
+	if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != "0123456789" || requests.Load() != 2 {
+		t.Errorf("内容 = %q、リクエスト数 = %d", got, requests.Load())
+	}
+}
+
+// TestContentLengthReaderはContent-Lengthと読んだバイト数が異なる場合にerrTruncatedを返すことを確認します。
+func TestContentLengthReader(t *testing.T) {
+	for _, tt := range []struct {
+		body    string
+		want    int64
+		wantErr bool
+	}{
+		{"0123456789", 10, false},
+		{"01234", 10, true},
+		{"", 0, false},
+	} {
+		_, err := io.ReadAll(&contentLengthReader{r: strings.NewReader(tt.body), want: tt.want})
+		if errors.Is(err, errTruncated) != tt.wantErr {
+			t.Errorf("%q (Content-Length %d): err = %v", tt.body, tt.want, err)
+		}
+	}
+}
+
+// TestDownloadFileTimeoutは本文の送信が止まったサーバーの画像をDownloadOptions.Timeoutで中止し、
+// 同じページの他の画像のダウンロードは続けることを確認します。
+func TestDownloadFileTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.png" {
+			w.Header().Set("Content-Length", "10")
+			w.Write([]byte("png"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Timeout: 100 * time.Millisecond}
+	jobs := []downloadJob{
+		{source: "slow.png", url: srv.URL + "/slow.png", fileName: "slow.png"},
+		{source: "fast.png", url: srv.URL + "/fast.png", fileName: "fast.png"},
+	}
+	start := time.Now()
+	results, err := runDownloads(context.Background(), srv.Client(), srv.URL, jobs, 1, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("中止までに%vかかりました", elapsed)
+	}
+	if results[0].Success || !strings.Contains(results[0].Error, "超えたため中止しました") {
+		t.Errorf("slow.png: Success = %v, Error = %q, want タイムアウトのエラー", results[0].Success, results[0].Error)
+	}
+	if !results[1].Success {
+		t.Errorf("fast.png: %s", results[1].Error)
+	}
+	if _, err := os.Stat(filepath.Join(opts.OutDir, "slow.png")); !os.IsNotExist(err) {
+		t.Errorf("中止した画像のファイルがあります: %v", err)
+	}
+}
+
+// TestDownloadFileNoRetryOn404は404の場合は再試行しないことを確認します。
+func TestDownloadFileNoRetryOn404(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Retry: RetryPolicy{Retries: 3, Wait: time.Millisecond}}
+	result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts)
+	if err == nil {
+		t.Fatal("エラーになりませんでした")
+	}
+	if result.StatusCode != http.StatusNotFound || requests.Load() != 1 {
+		t.Errorf("ステータス = %d, リクエスト数 = %d", result.StatusCode, requests.Load())
+	}
+}
+
+// TestBackoffは待ち時間が再試行ごとに2倍になり、ジッターが50%以内であることを確認します。
+func TestBackoff(t *testing.T) {
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		got := backoff(100*time.Millisecond, attempt)
+		if got < want || got > want+want/2 {
+			t.Errorf("backoff(100ms, %d) = %v, want %v〜%v", attempt, got, want, want+want/2)
+		}
+	}
+}
+
+// TestDownloadFileRetryAfterは429とRetry-After: 2を返すサーバーから、指定の秒数待ってダウンロードできることを確認します。
+func TestDownloadFileRetryAfter(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "2")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Retry: RetryPolicy{Retries: 1, Wait: time.Millisecond, MaxWait: 5 * time.Second}}
+	start := time.Now()
+	if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("Retry-Afterの秒数を待っていません: %v", elapsed)
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != "ok" {
+		t.Errorf("内容 = %q, want %q", got, "ok")
+	}
+}
+
+// TestRetryAfterはRetry-Afterヘッダの秒数とHTTP日付の形式を解析できることを確認します。
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		status int
+		value  string
+		want   time.Duration
+		ok     bool
+	}{
+		{http.StatusTooManyRequests, "2", 2 * time.Second, true},
+		{http.StatusServiceUnavailable, now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{http.StatusTooManyRequests, now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{http.StatusTooManyRequests, "", 0, false},
+		{http.StatusTooManyRequests, "-1", 0, false},
+		{http.StatusTooManyRequests, "soon", 0, false},
+		{http.StatusInternalServerError, "2", 0, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{"Retry-After": {tt.value}}}
+		got, ok := retryAfter(resp, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%d, %q) = %v, %v, want %v, %v", tt.status, tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestDownloadFileContentDispositionはContent-Dispositionのファイル名（filename*を含む）で保存することを確認します。
+func TestDownloadFileContentDisposition(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="fallback.png"; filename*=UTF-8''%E8%A8%AD%E8%A8%88.png`)
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir()}
+	result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/attachment/649abc", "649abc", opts)
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if result.FileName != "設計.png" {
+		t.Errorf("FileName = %q, want %q", result.FileName, "設計.png")
+	}
+	readFile(t, filepath.Join(opts.OutDir, "設計.png"))
+}
+
+// TestDownloadFileNameCollisionは別のURLのファイルが同じContent-Dispositionの名前の場合に、
+// スキップや上書きをせずに番号を付けて保存することを確認します。
+func TestDownloadFileNameCollision(t *testing.T) {
+	bodies := map[string]string{"/a": "first", "/b": "second image", "/c": "third"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="image.png"`)
+		w.Write([]byte(bodies[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Names: NewNameRegistry()}
+	want := map[string]string{"/a": "image.png", "/b": "image (1).png", "/c": "image (2).png"}
+	for _, p := range []string{"/a", "/b", "/c"} {
+		result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+p, strings.TrimPrefix(p, "/"), opts)
+		if err != nil {
+			t.Fatalf("DownloadFile(%s): %v", p, err)
+		}
+		if result.Skipped || result.FileName != want[p] {
+			t.Errorf("%s: result = %+v, want FileName %q", p, result, want[p])
+		}
+	}
+	for p, name := range want {
+		if got := readFile(t, filepath.Join(opts.OutDir, name)); got != bodies[p] {
+			t.Errorf("%s の内容 = %q, want %q", name, got, bodies[p])
+		}
+	}
+}
+
+// countingServerはリクエスト数を数え、パスごとにbodyを返すテスト用のサーバーです。
+// nameが空でなければContent-Dispositionでファイル名を指定します。
+func countingServer(t *testing.T, name string, body func(path string) string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// 内容が変わらないサーバーとして、条件付きリクエストには常に304を返す
+		if r.Header.Get("If-Modified-Since") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if name != "" {
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		}
+		w.Write([]byte(body(r.URL.Path)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// TestDownloadFileExistingは既存のファイルのスキップ、-overwriteでの上書き、書き込み途中のファイルの再取得を確認します。
+func TestDownloadFileExisting(t *testing.T) {
+	ctx := context.Background()
+	t.Run("スキップ", func(t *testing.T) {
+		srv, requests := countingServer(t, "", func(string) string { return "new" })
+		opts := DownloadOptions{OutDir: t.TempDir()}
+		os.WriteFile(filepath.Join(opts.OutDir, "a.png"), []byte("old"), 0644)
+		result, err := DownloadFile(ctx, srv.Client(), srv.URL+"/a.png", "a.png", opts)
+		if err != nil || !result.Skipped {
+			t.Fatalf("result = %+v, err = %v", result, err)
+		}
+		if requests.Load() != 1 {
+			t.Errorf("条件付きリクエストの数 = %d, want 1", requests.Load())
+		}
+		if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != "old" {
+			t.Errorf("内容 = %q, want %q", got, "old")
+		}
+	})
+
+	t.Run("上書き", func(t *testing.T) {
+		srv, _ := countingServer(t, "", func(string) string { return "new" })
+		opts := DownloadOptions{OutDir: t.TempDir(), Overwrite: true}
+		os.WriteFile(filepath.Join(opts.OutDir, "a.png"), []byte("old"), 0644)
+		result, err := DownloadFile(ctx, srv.Client(), srv.URL+"/a.png", "a.png", opts)
+		if err != nil || result.Skipped {
+			t.Fatalf("result = %+v, err = %v", result, err)
+		}
+		if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != "new" {
+			t.Errorf("内容 = %q, want %q", got, "new")
+		}
+	})
+
+	t.Run("書き込み途中", func(t *testing.T) {
+		srv, _ := countingServer(t, "image.png", func(path string) string { return "complete" + path })
+		opts := DownloadOptions{OutDir: t.TempDir(), Names: NewNameRegistry()}
+		if _, err := DownloadFile(ctx, srv.Client(), srv.URL+"/a", "a", opts); err != nil {
+			t.Fatal(err)
+		}
+		filePath := filepath.Join(opts.OutDir, "image.png")
+		os.Truncate(filePath, 3)
+		// 同じURLから保存したファイルのサイズが異なる場合は再取得する
+		result, err := DownloadFile(ctx, srv.Client(), srv.URL+"/a", "a", opts)
+		if err != nil || result.Skipped || result.FileName != "image.png" {
+			t.Fatalf("result = %+v, err = %v", result, err)
+		}
+		if got := readFile(t, filePath); got != "complete/a" {
+			t.Errorf("内容 = %q, want %q", got, "complete/a")
+		}
+	})
+
+	t.Run("サイズが異なる別のファイル", func(t *testing.T) {
+		srv, _ := countingServer(t, "image.png", func(string) string { return "another image" })
+		opts := DownloadOptions{OutDir: t.TempDir(), Names: NewNameRegistry()}
+		os.WriteFile(filepath.Join(opts.OutDir, "image.png"), []byte("old"), 0644)
+		result, err := DownloadFile(ctx, srv.Client(), srv.URL+"/b", "b", opts)
+		if err != nil || result.Skipped || result.FileName != "image (1).png" {
+			t.Fatalf("result = %+v, err = %v", result, err)
+		}
+		if got := readFile(t, filepath.Join(opts.OutDir, "image.png")); got != "old" {
+			t.Errorf("既存のファイルが上書きされています: %q", got)
+		}
+	})
+}
+
+// TestDownloadFileIfModifiedSinceは既存のファイルの更新日時で条件付きリクエストを送り、
+// 変更されていなければ304でスキップし、変更されているか条件付きリクエストに対応していないサーバーなら上書きすることを確認します。
+func TestDownloadFileIfModifiedSince(t *testing.T) {
+	serverModTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	conditional := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a.png", serverModTime, strings.NewReader("new"))
+	}))
+	defer conditional.Close()
+	unconditional := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	defer unconditional.Close()
+
+	tests := []struct {
+		name         string
+		srv          *httptest.Server
+		localModTime time.Time
+		wantSkipped  bool
+		wantContent  string
+	}{
+		{"変更なし", conditional, serverModTime.Add(time.Hour), true, "old"},
+		{"変更あり", conditional, serverModTime.Add(-time.Hour), false, "new"},
+		{"条件付きリクエストに未対応", unconditional, serverModTime.Add(time.Hour), false, "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DownloadOptions{OutDir: t.TempDir()}
+			filePath := filepath.Join(opts.OutDir, "a.png")
+			os.WriteFile(filePath, []byte("old"), 0644)
+			os.Chtimes(filePath, tt.localModTime, tt.localModTime)
+			result, err := DownloadFile(context.Background(), tt.srv.Client(), tt.srv.URL+"/a.png", "a.png", opts)
+			if err != nil {
+				t.Fatalf("DownloadFile: %v", err)
+			}
+			if result.Skipped != tt.wantSkipped || result.FileName != "a.png" {
+				t.Errorf("Skipped = %v, FileName = %q, want %v, a.png", result.Skipped, result.FileName, tt.wantSkipped)
+			}
+			if got := readFile(t, filePath); got != tt.wantContent {
+				t.Errorf("内容 = %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+// TestDownloadFileModTimeは保存したファイルの更新日時をLast-Modifiedの日時にし、ない場合や解析できない場合は変えないことを確認します。
+func TestDownloadFileModTime(t *testing.T) {
+	serverModTime := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		lastModified string
+		want         time.Time // ゼロの場合は保存した日時のまま
+	}{
+		{"RFC 1123", serverModTime.Format(http.TimeFormat), serverModTime},
+		{"RFC 850", serverModTime.Format(time.RFC850), serverModTime},
+		{"ANSI C", serverModTime.Format(time.ANSIC), serverModTime},
+		{"数値のタイムゾーン", serverModTime.In(time.FixedZone("JST", 9*60*60)).Format(time.RFC1123Z), serverModTime},
+		{"なし", "", time.Time{}},
+		{"不正な日時", "yesterday", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.lastModified != "" {
+					w.Header().Set("Last-Modified", tt.lastModified)
+				}
+				w.Write([]byte("png"))
+			}))
+			defer srv.Close()
+			opts := DownloadOptions{OutDir: t.TempDir()}
+			start := time.Now().Add(-time.Minute)
+			if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filepath.Join(opts.OutDir, "a.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case !tt.want.IsZero() && !info.ModTime().Equal(tt.want):
+				t.Errorf("更新日時 = %v, want %v", info.ModTime().UTC(), tt.want)
+			case tt.want.IsZero() && info.ModTime().Before(start):
+				t.Errorf("更新日時 = %v, want 保存した日時", info.ModTime())
+			}
+		})
+	}
+}
+
+// TestDownloadFileRerunは再実行時に既存のファイルをスキップすることを確認します。
+// URLに拡張子がある場合は条件付きリクエストの304で、ない場合は保存したファイル名がレスポンスで決まるため、
+// レスポンスのサイズを既存のファイルと比べてスキップします。
+func TestDownloadFileRerun(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		requests int32
+	}{
+		{"拡張子あり", "/a.png", 2},
+		{"拡張子なし", "/attachment/649abc", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := countingServer(t, "", func(string) string { return "png" })
+			dir := t.TempDir()
+			for run := 0; run < 2; run++ {
+				// 実行ごとに新しい記録を使う
+				opts := DownloadOptions{OutDir: dir, Names: NewNameRegistry()}
+				result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+tt.path, filepath.Base(tt.path), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Skipped != (run == 1) {
+					t.Errorf("%d回目: Skipped = %v", run+1, result.Skipped)
+				}
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("リクエスト数 = %d, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+// errReaderは途中まで読んだ後にエラーを返すio.Readerです。
+type errReader struct{ n int }
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, errors.New("接続が切れました")
+	}
+	n := min(len(p), r.n)
+	r.n -= n
+	return n, nil
+}
+
+// TestWriteFileAtomicCopyErrorは書き込み中にエラーが起きた場合、保存先のファイルも一時ファイルも残らないことを確認します。
+func TestWriteFileAtomicCopyError(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := writeFileAtomic(filepath.Join(dir, "a.png"), &errReader{n: 1024}); err == nil {
+		t.Fatal("エラーになりませんでした")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("ファイルが残っています: %s", e.Name())
+	}
+}
+
+// TestWriteFileAtomicConcurrentは同じ保存先に同時に書き込んでも、内容が混ざらずどちらか一方の内容になることを確認します。
+func TestWriteFileAtomicConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "image.png")
+	payloads := []string{strings.Repeat("a", 1<<20), strings.Repeat("b", 1<<20)}
+	var wg sync.WaitGroup
+	errs := make([]error, len(payloads))
+	for i, p := range payloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = writeFileAtomic(filePath, iotest.HalfReader(strings.NewReader(p)))
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("writeFileAtomic(%d): %v", i, err)
+		}
+	}
+	if got := readFile(t, filePath); got != payloads[0] && got != payloads[1] {
+		t.Error("内容が混ざっています")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("一時ファイルが残っています: %d個のファイル", len(entries))
+	}
+}
@@ -0,0 +1,993 @@
+// Package downloaderはGROWIなどのWebページから画像を抽出し、ログインセッションを引き継いでダウンロードします。
+//
+// ページの読み込みにはChrome（chromedp）を使い、ブラウザのCookieを画像のダウンロードでも使います。
+// Options.NoBrowserを指定するとChromeを使わずにページのHTMLを直接解析します。
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// OptionsはDownloaderの設定です。
+type Options struct {
+	OutDir        string        // 画像保存先ディレクトリ
+	Concurrency   int           // 同時にダウンロードする画像の数
+	PerHostLimit  int           // 同じホストから同時にダウンロードする画像の数の上限（0の場合は制限しない）
+	MaxRate       int64         // 全てのダウンロードの合計の転送速度の上限（バイト/秒。0の場合は制限しない）
+	Timeout       time.Duration // ページごとの読み込みと画像の抽出にかける時間の上限
+	PageDelay     time.Duration // 前のページを処理し終えてから次のページを読み込むまでに待つ時間（最大50%のジッターを加える。ページ内の画像のダウンロードは待たない）
+	PreferSrcset  bool          // srcset属性がある場合は最も高解像度の候補をダウンロードする
+	PreferLinked  bool          // imgタグが画像へのリンクで囲まれている場合はリンク先（元の大きさの画像）をダウンロードする
+	DryRun        bool          // ダウンロードせずに対象の一覧だけを返す
+	List          bool          // ダウンロードせずにHEADリクエストで種類とサイズを調べ、Outputに表で出力する（OutDirは不要）
+	PerPageDir    bool          // ページごとにOutDir配下のサブディレクトリへ保存する
+	PreservePath  bool          // 画像のURLのディレクトリ構造をOutDir配下に再現して保存する（/attachment/page/a.png → OutDir/attachment/page/a.png）
+	TitlePrefix   bool          // ファイル名の先頭にページのタイトルを付ける（MyPage_image_1.png）
+	Mirror        bool          // ページのHTMLの画像の参照を保存したファイルに書き換え、OutDirに"<ページのパス>.html"として保存する（オフラインで表示するため）
+	Depth         int           // DownloadPageでページ内のリンクを返す（Crawlerでたどる）深さ。0の場合はリンクを取得しない
+	RespectRobots bool          // ホストのrobots.txtで禁止されているページを読み込まず、画像をダウンロードしない
+	FailFast      bool          // 画像のダウンロードに1件でも失敗したら残りのダウンロードを中止する
+	SizeFilter    SizeFilter    // 画像の大きさによる絞り込み（Chromeで抽出した場合のみ大きさが分かる）
+	Thumbnail     ThumbnailSize // 保存した画像のサムネイルをOutDir/thumbsに作成する場合の大きさの上限（0の場合は作成しない）
+	URLFilter     URLFilter     // 画像の絶対URLによる絞り込み（data: URIは"data:image/png;base64"などのヘッダ部分で判断）
+	NoBrowser     bool          // Chromeを使わずにページのHTMLを直接取得して画像を抽出する
+	API           bool          // ページのDOMではなくGROWIのAPIで添付ファイルの一覧を取得してダウンロードする（Chromeは使わない）
+	APIToken      string        // GROWIのAPIトークン（APIの場合のみ使用）
+	BasicUser     string        // ページのホストのBasic認証のユーザー名（リバースプロキシでBasic認証を掛けている場合。空の場合は送らない）
+	BasicPass     string        // ページのホストのBasic認証のパスワード
+	Headless      bool          // Chromeをヘッドレスモードで起動する
+	ChromeProfile string        // 使用するChromeのプロファイル名（UserDataDir内のディレクトリ名。"Default"や"Profile 1"など）
+	UserDataDir   string        // Chromeのユーザーデータディレクトリ（プロファイルの親ディレクトリ）のパス（空の場合はOSごとの既定の場所）
+	RemoteURL     string        // 起動済みのChromeのDevToolsエンドポイント（指定するとChromeを起動しない）
+	ChromePath    string        // Chrome/Chromiumの実行ファイルのパス（空の場合は自動検出）
+	UserAgent     string        // ページの表示と画像のダウンロードで使うUser-Agent
+	Device        string        // Chromeでエミュレートするデバイスの名前（LookupDeviceで探す。空の場合はエミュレートしない。User-Agentはデバイスのものを使う）
+	Headers       http.Header   // ページの表示と画像のダウンロードの全てのリクエストに追加するヘッダ
+	NoFollow      bool          // HTTPクライアントでのページの取得と画像のダウンロードでリダイレクトをたどらない（3xxのレスポンスは失敗にする）
+	MaxRedirects  int           // リダイレクトをたどる回数の上限（0の場合はGoの既定の動作）
+	SendReferer   bool          // 画像のダウンロードでRefererヘッダにページのURLを送る（直リンク防止の設定があるサーバー向け）
+	Referer       string        // Refererヘッダに送るURL（空の場合はページのURL。SendRefererの場合のみ使用）
+	Proxy         string        // ページの表示と画像のダウンロードに使うプロキシのURL
+	Insecure      bool          // TLS証明書の検証を無効にする
+	CACert        string        // 追加で信頼するCA証明書（PEM形式）のファイルのパス
+	Screenshot    ScreenshotOptions
+	PDF           PDFOptions
+	Transport     TransportOptions
+	Extract       ExtractOptions
+	Download      DownloadOptions
+	// HTTPClientはページの取得と画像のダウンロードに使うHTTPクライアントです（nilの場合はTLS・プロキシ設定を反映したものを作成）。
+	// 指定した場合、Insecure・CACert・Proxy・Transportはダウンロードには適用されません。
+	// ページのCookieを引き継ぐため、Jarはページごとに新しいものに置き換えて使います。
+	HTTPClient *http.Client
+	// NameTemplateは保存するファイル名のテンプレートです（NameTemplateDataを渡します。nilの場合はURLから決めた名前を使います）。
+	NameTemplate *template.Template
+	// Outputには抽出した画像のURLとドライランの対象の一覧を出力します（nilの場合は出力しない）。
+	Output io.Writer
+}
+
+// DefaultOptionsはコマンドラインツールの既定値と同じ設定を返します。
+// OutDirは呼び出し側で設定してください。
+func DefaultOptions() Options {
+	return Options{
+		Concurrency:   4,
+		PerHostLimit:  DefaultPerHostLimit,
+		Timeout:       60 * time.Second,
+		PreferSrcset:  true,
+		SendReferer:   true,
+		RespectRobots: true,
+		Headless:      true,
+		ChromeProfile: "Default",
+		UserAgent:     DefaultUserAgent,
+		Screenshot:    ScreenshotOptions{Quality: DefaultScreenshotQuality},
+		PDF:           PDFOptions{Paper: DefaultPDFPaper, Margin: DefaultPDFMargin},
+		Transport:     TransportOptions{MaxIdleConns: DefaultMaxIdleConns, IdleTimeout: DefaultIdleConnTimeout},
+		Extract: ExtractOptions{
+			WaitSelector: "img",
+			MaxWait:      10 * time.Second,
+			WindowWidth:  1920,
+			WindowHeight: 1080,
+		},
+		Download: DownloadOptions{
+			JPEGQuality: DefaultJPEGQuality,
+			Retry: RetryPolicy{
+				Retries: 3,
+				Wait:    time.Second,
+				MaxWait: 30 * time.Second,
+			},
+		},
+	}
+}
+
+// Downloaderはページの画像をダウンロードします。
+// Chromeを使う場合は1つのブラウザを全ページで共有し、ページごとに新しいタブを開きます。
+// 使い終わったらCloseを呼んでください。
+type Downloader struct {
+	opts       Options
+	client     *http.Client    // ページごとのセッションクライアントの元になるHTTPクライアント
+	browserCtx context.Context // opts.NoBrowserの場合はnil
+	robots     *robotsCache    // opts.RespectRobotsでない場合はnil
+	mu         sync.Mutex
+	lastPage   time.Time // 前のページを処理し終えた時刻（PageDelayで使う）
+	snapshots  int       // スクリーンショットかPDFを保存したページの数（保存先のパスに付ける番号）
+	cancels    []context.CancelFunc
+}
+
+// Newはoptsの設定でDownloaderを作成します。
+// opts.NoBrowserでなければChromeを起動（またはopts.RemoteURLに接続）します。
+func New(opts Options) (*Downloader, error) {
+	d, err := newDownloader(opts)
+	if err != nil || d.opts.NoBrowser || d.opts.API {
+		return d, err
+	}
+
+	// Chromeを起動し、全ページで同じブラウザを使う（ページごとに新しいタブを開く）
+	allocCtx, cancelAlloc := newAllocator(context.Background(), &d.opts)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	d.cancels = []context.CancelFunc{cancelBrowser, cancelAlloc}
+	if err := chromedp.Run(browserCtx); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("Chromeの起動に失敗: %w", err)
+	}
+	d.browserCtx = browserCtx
+	return d, nil
+}
+
+// WithOptionsはdと同じブラウザとHTTPクライアントを使い、optsの設定でページを処理するDownloaderを作成します。
+// Chromeを起動し直さないため、Chromeの起動とHTTPクライアントに関わる設定（Headless、ChromePath、Proxy、Insecureなど）は
+// dのものを使います。転送速度の制限（MaxRate）とrobots.txtの記録もdと共有し、dと作成した全てのDownloaderの合計に適用します。
+// 作成したDownloaderも使い終わったらCloseを呼んでください（dのブラウザは閉じません）。
+func (d *Downloader) WithOptions(opts Options) (*Downloader, error) {
+	if d.browserCtx == nil && !opts.NoBrowser && !opts.API {
+		return nil, errors.New("Chromeを起動していないDownloaderからはChromeを使うDownloaderを作成できません")
+	}
+	opts.HTTPClient = d.client
+	opts.MaxRate, opts.Download.Limiter = d.opts.MaxRate, d.opts.Download.Limiter
+	nd, err := newDownloader(opts)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.NoBrowser && !opts.API {
+		nd.browserCtx = d.browserCtx
+	}
+	if nd.robots != nil && d.robots != nil {
+		nd.robots = d.robots
+	}
+	return nd, nil
+}
+
+// newDownloaderはoptsを確認し、Chromeを起動する前までの準備をしたDownloaderを作成します。
+func newDownloader(opts Options) (*Downloader, error) {
+	if opts.OutDir == "" && !opts.List && opts.Download.Archive == nil {
+		return nil, errors.New("画像保存先ディレクトリが指定されていません")
+	}
+	if opts.Download.Archive != nil && (opts.DryRun || opts.List) {
+		return nil, errors.New("アーカイブへの書き込みはDryRunやListと同時に指定できません")
+	}
+	if opts.Thumbnail != (ThumbnailSize{}) && (opts.Thumbnail.Width < 1 || opts.Thumbnail.Height < 1) {
+		return nil, fmt.Errorf("サムネイルの幅と高さは1以上にしてください: %dx%d", opts.Thumbnail.Width, opts.Thumbnail.Height)
+	}
+	if opts.Thumbnail != (ThumbnailSize{}) && opts.Download.Archive != nil {
+		return nil, errors.New("アーカイブに書き込む場合はサムネイルを作成できません")
+	}
+	if opts.Download.ConvertTo != "" && convertExts[opts.Download.ConvertTo] == "" {
+		return nil, fmt.Errorf("変換先の形式が正しくありません: %q", opts.Download.ConvertTo)
+	}
+	if opts.Download.JPEGQuality < 0 || opts.Download.JPEGQuality > 100 {
+		return nil, fmt.Errorf("JPEGの品質は1～100にしてください: %d", opts.Download.JPEGQuality)
+	}
+	if (opts.Download.ConvertTo != "" || opts.Download.StripMetadata || opts.Download.SidecarAlt || opts.Mirror) && opts.Download.Archive != nil {
+		return nil, errors.New("アーカイブに書き込む場合は画像の変換・メタデータの削除・alt属性やページのHTMLの書き込みはできません")
+	}
+	if opts.Mirror && opts.API {
+		return nil, errors.New("APIの場合はページのHTMLを保存できません")
+	}
+	if opts.List && opts.DryRun {
+		return nil, errors.New("ListとDryRunは同時に指定できません")
+	}
+	if opts.Concurrency < 1 {
+		return nil, fmt.Errorf("同時ダウンロード数は1以上にしてください: %d", opts.Concurrency)
+	}
+	if opts.PerHostLimit < 0 {
+		return nil, fmt.Errorf("ホストごとの同時ダウンロード数は0以上にしてください: %d", opts.PerHostLimit)
+	}
+	if opts.Download.Retry.Retries < 0 {
+		return nil, fmt.Errorf("再試行回数は0以上にしてください: %d", opts.Download.Retry.Retries)
+	}
+	if opts.BasicUser != "" && opts.API && opts.APIToken != "" {
+		return nil, errors.New("Basic認証とAPIトークンはどちらもAuthorizationヘッダを使うため、同時に指定できません")
+	}
+	if opts.Depth > 0 && opts.API {
+		return nil, errors.New("APIの場合はページのリンクをたどれません")
+	}
+	if opts.NoBrowser && opts.API {
+		return nil, errors.New("NoBrowserとAPIは同時に指定できません")
+	}
+	if (opts.NoBrowser || opts.API) && !opts.Extract.isDefaultTarget() {
+		return nil, errors.New("NoBrowserかAPIの場合は抽出する要素のセレクタと属性を変更できません")
+	}
+	if (opts.NoBrowser || opts.API) && opts.Extract.Backgrounds {
+		return nil, errors.New("NoBrowserかAPIの場合はCSSのbackground-imageの画像を抽出できません")
+	}
+	if (opts.NoBrowser || opts.API) && opts.Device != "" {
+		return nil, errors.New("NoBrowserかAPIの場合はデバイスをエミュレートできません")
+	}
+	if opts.API && (opts.Extract.Media || opts.Extract.InlineData) {
+		return nil, errors.New("APIの場合は動画と音声の要素や埋め込まれた画像を抽出できません（添付ファイルは全てダウンロードします）")
+	}
+	if (opts.NoBrowser || opts.API) && opts.Extract.Iframes {
+		return nil, errors.New("NoBrowserかAPIの場合はiframe内の画像を抽出できません")
+	}
+	if (opts.NoBrowser || opts.API) && opts.Extract.PierceShadow {
+		return nil, errors.New("NoBrowserかAPIの場合はshadow DOM内の画像を抽出できません")
+	}
+	if (opts.NoBrowser || opts.API) && (opts.Screenshot.Path != "" || opts.PDF.Path != "") {
+		return nil, errors.New("NoBrowserかAPIの場合はスクリーンショットとPDFを保存できません")
+	}
+	if err := opts.Screenshot.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.PDF.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Transport.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+	opts.Download.OutDir = opts.OutDir
+	// 別のページの同じ名前の画像も区別するため、全ページで同じ記録を使う
+	if opts.Download.Names == nil {
+		opts.Download.Names = NewNameRegistry()
+	}
+	if opts.Download.Downloaded == nil {
+		opts.Download.Downloaded = new(atomic.Int64)
+	}
+	if opts.Download.Limiter == nil {
+		opts.Download.Limiter = newRateLimiter(opts.MaxRate)
+	}
+	if opts.Download.HostLimit == nil {
+		opts.Download.HostLimit = NewHostLimiter(opts.PerHostLimit)
+	}
+	if proxyURL, err := ParseProxyURL(opts.Proxy); err == nil && !isSOCKSProxy(proxyURL) {
+		opts.Extract.ProxyAuth = proxyURL.User
+	}
+	if opts.BasicUser != "" {
+		opts.Extract.BasicAuth = url.UserPassword(opts.BasicUser, opts.BasicPass)
+	}
+	if opts.Device != "" {
+		info, err := LookupDevice(opts.Device)
+		if err != nil {
+			return nil, err
+		}
+		// 解像度を指定した場合はデバイスの解像度の代わりに使う
+		if opts.Extract.ScaleFactor > 0 {
+			info.Scale = opts.Extract.ScaleFactor
+		}
+		opts.Extract.Device = &info
+		opts.UserAgent = info.UserAgent
+	}
+	// サーバーがセッションとUser-Agentを紐付けている場合に備え、ブラウザとダウンロードで同じ値を使う
+	opts.Extract.UserAgent = opts.UserAgent
+	opts.Download.UserAgent = opts.UserAgent
+	opts.Extract.Headers = opts.Headers
+	opts.Download.Header = opts.Headers
+
+	// ダウンロード用のHTTPクライアントを作成（接続を使い回すため全ページで共有する）
+	client := opts.HTTPClient
+	if client == nil {
+		transport, err := newTransport(&opts)
+		if err != nil {
+			return nil, fmt.Errorf("HTTPクライアントの設定に失敗: %w", err)
+		}
+		client = &http.Client{Transport: transport}
+	}
+	client = withRedirectPolicy(client, opts.NoFollow, opts.MaxRedirects)
+
+	// chromedpの起動時エラーは分かりにくいため、実行ファイルの有無は先に確認する
+	if opts.ChromePath != "" {
+		if _, err := os.Stat(opts.ChromePath); err != nil {
+			return nil, fmt.Errorf("Chromeが見つかりません: %w", err)
+		}
+	}
+
+	// 画像保存先ディレクトリを作成（存在しない場合）
+	if !opts.DryRun && !opts.List && opts.Download.Archive == nil {
+		if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+			return nil, fmt.Errorf("画像保存先ディレクトリの作成に失敗: %w", err)
+		}
+	}
+
+	// サムネイルはダウンロードを待たせないよう、全ページで共有する1つのゴルーチンで作成する
+	if opts.Thumbnail != (ThumbnailSize{}) && opts.Download.Thumbnails == nil && !opts.DryRun && !opts.List {
+		opts.Download.Thumbnails = NewThumbnailer(opts.OutDir, opts.Thumbnail)
+	}
+
+	d := &Downloader{opts: opts, client: client}
+	if opts.RespectRobots {
+		d.robots = newRobotsCache()
+	}
+	return d, nil
+}
+
+// BytesDownloadedはこれまでにダウンロードして保存したファイルの合計バイト数を返します（スキップしたファイルは含みません）。
+func (d *Downloader) BytesDownloaded() int64 {
+	return d.opts.Download.Downloaded.Load()
+}
+
+// WaitThumbnailsは予約済みのサムネイルを全て作成するまで待ち、作成したサムネイルの数を返します。
+// 以降に保存した画像のサムネイルは作成しません。Thumbnailを指定していない場合は0を返します。
+func (d *Downloader) WaitThumbnails() int {
+	return d.opts.Download.Thumbnails.Close()
+}
+
+// CloseはDownloaderが起動したChromeを終了します（接続先のChromeの場合は切断のみ）。
+// サムネイルを作成している場合は、作成が終わるまで待ちます。
+func (d *Downloader) Close() {
+	d.WaitThumbnails()
+	for _, cancel := range d.cancels {
+		cancel()
+	}
+	d.cancels = nil
+}
+
+// ResultはDownloadで処理した画像1件の結果です。
+type Result struct {
+	Page        string `json:"page"`
+	Source      string `json:"source"`
+	URL         string `json:"url"`
+	File        string `json:"file,omitempty"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	FinalURL    string `json:"final_url,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	Alt         string `json:"alt,omitempty"`
+	Title       string `json:"title,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"` // DedupContentで置き換えた場合の、内容が同じ先のファイルのOutDirからの相対パス
+	SimilarTo   string `json:"similar_to,omitempty"`   // FindSimilarで見つけた、見た目が似ている先の画像のOutDirからの相対パス
+	Success     bool   `json:"success"`
+	Skipped     bool   `json:"skipped,omitempty"`
+	Filtered    bool   `json:"filtered,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ErrRobotsDisallowedはRespectRobotsの場合に、ページがrobots.txtで禁止されているため読み込まなかったことを表します。
+var ErrRobotsDisallowed = errors.New("robots.txtで禁止されているため、ページを読み込みませんでした")
+
+// ErrFailFastはFailFastの指定により、画像のダウンロードの失敗で残りのダウンロードを中止したことを表します。
+var ErrFailFast = errors.New("ダウンロードに失敗したため残りのダウンロードを中止しました")
+
+// DownloadはpageURLの画像を抽出してダウンロードし、画像ごとの結果を返します。
+// 個々の画像のダウンロードの失敗はResultに記録し、ページの読み込みや画像の抽出に失敗した場合のみエラーを返します。
+// ただしFailFastの場合は、最初の失敗で残りを中止し、それまでの結果とErrFailFastをラップしたエラーを返します。
+// RespectRobotsの場合に、ページがrobots.txtで禁止されていればErrRobotsDisallowedを返します。
+// ドライランの場合はダウンロードせず、対象の一覧を返します。
+// Listの場合はダウンロードせず、HEADリクエストで調べた種類とサイズ（不明な場合は-1）を結果に記録します。
+// ctxがキャンセルされた場合は実行中のダウンロードを中断し、それまでの結果とctx.Err()を返します。
+func (d *Downloader) Download(ctx context.Context, pageURL string) ([]Result, error) {
+	results, _, err := d.DownloadPage(ctx, pageURL)
+	return results, err
+}
+
+// DownloadPageはDownloadと同様にpageURLの画像をダウンロードし、Depthが1以上の場合はページ内の
+// 同じホストのページへのリンク（pageLinksで絞り込んだ絶対URL）も返します。リンクはCrawlerに渡してたどります。
+func (d *Downloader) DownloadPage(ctx context.Context, pageURL string) ([]Result, []string, error) {
+	opts := &d.opts
+	// ベースとなるURLをパースしておく（相対パス解決用）
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		slog.Error("ページURLのパースに失敗しました", "page", pageURL, "error", err)
+		return nil, nil, err
+	}
+	if err := d.waitPageDelay(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer d.finishPage()
+	if !d.robotsAllowed(ctx, pageURL) {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		slog.Warn("robots.txtで禁止されているため、ページを読み込みません", "page", pageURL)
+		return nil, nil, ErrRobotsDisallowed
+	}
+
+	var jobs []downloadJob
+	var client *http.Client
+	var links []string
+	var pageHTML []byte
+	if opts.API {
+		if client, jobs, err = d.loadAPIJobs(ctx, base); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		page, err := d.loadPage(ctx, base)
+		if err != nil {
+			return nil, nil, err
+		}
+		client, links, pageHTML = page.client, pageLinks(base, page.links), page.html
+		jobs = d.buildJobs(ctx, client, base, page.title, page.images)
+	}
+	jobs = d.filterRobots(ctx, jobs)
+
+	dlOpts := opts.Download
+	if opts.SendReferer {
+		// ブラウザで表示した場合と同じく、画像のリクエストにはページのURLをRefererとして送る
+		dlOpts.Referer = opts.Referer
+		if dlOpts.Referer == "" {
+			dlOpts.Referer = pageURL
+		}
+	}
+
+	// 一覧の表示ではファイルを保存しないため、HEADリクエストで調べた結果だけを返す
+	if opts.List {
+		results := listJobs(ctx, client, pageURL, jobs, dlOpts)
+		printList(opts.Output, results)
+		return results, links, ctx.Err()
+	}
+
+	// ページごとのサブディレクトリに保存する場合は保存先を切り替える
+	if opts.PerPageDir {
+		dlOpts.OutDir = filepath.Join(opts.OutDir, pageDirName(base))
+		if !opts.DryRun && dlOpts.Archive == nil {
+			if err := os.MkdirAll(dlOpts.OutDir, 0755); err != nil {
+				slog.Error("ページの保存先ディレクトリの作成に失敗しました", "dir", dlOpts.OutDir, "error", err)
+				return nil, links, err
+			}
+		}
+	}
+
+	// ドライランではダウンロードせずに対象だけを返す
+	// （拡張子とContent-Dispositionのファイル名はレスポンスで決まるため、表示は予定の名前）
+	if opts.DryRun {
+		results := make([]Result, len(jobs))
+		for i, job := range jobs {
+			fmt.Fprintf(opts.Output, "%s -> %s\n", displayURL(job.url), filepath.Join(dlOpts.OutDir, job.fileName))
+			results[i] = newResult(pageURL, job, DownloadResult{FileName: job.fileName}, nil)
+		}
+		return results, links, nil
+	}
+
+	// 固定数のワーカーでダウンロードを実施
+	results, err := runDownloads(ctx, client, pageURL, jobs, opts.Concurrency, opts.FailFast, dlOpts)
+	if ctx.Err() != nil {
+		return results, links, ctx.Err()
+	}
+	// ダウンロードに失敗した画像は元のURLのまま残すため、全ての結果が揃ってから書き換える
+	if pageHTML != nil {
+		if err := d.writeMirror(base, pageHTML, results); err != nil {
+			slog.Error("ページのHTMLの保存に失敗しました", "page", pageURL, "error", err)
+		}
+	}
+	return results, links, err
+}
+
+// waitPageDelayはPageDelayの場合に、前のページを処理し終えてからPageDelay（と最大50%のジッター）が経つまで待ちます。
+// 最初のページでは待ちません。ctxがキャンセルされた場合はctx.Err()を返します。
+func (d *Downloader) waitPageDelay(ctx context.Context) error {
+	d.mu.Lock()
+	last := d.lastPage
+	d.mu.Unlock()
+	if d.opts.PageDelay <= 0 || last.IsZero() {
+		return nil
+	}
+	wait := time.Until(last.Add(backoff(d.opts.PageDelay, 0)))
+	if wait <= 0 {
+		return nil
+	}
+	slog.Debug("次のページの読み込みまで待ちます", "wait", wait)
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finishPageはページを処理し終えた時刻を記録します。
+func (d *Downloader) finishPage() {
+	d.mu.Lock()
+	d.lastPage = time.Now()
+	d.mu.Unlock()
+}
+
+// robotsAllowedはRespectRobotsの場合に、urlStrのホストのrobots.txtでurlStrへのアクセスが許可されているかどうかを返します。
+// RespectRobotsでない場合は常にtrueを返します。
+func (d *Downloader) robotsAllowed(ctx context.Context, urlStr string) bool {
+	if d.robots == nil {
+		return true
+	}
+	return d.robots.allowed(ctx, d.client, urlStr, d.opts.Download)
+}
+
+// filterRobotsはjobsからrobots.txtで禁止されているURLのジョブを除きます。
+func (d *Downloader) filterRobots(ctx context.Context, jobs []downloadJob) []downloadJob {
+	if d.robots == nil {
+		return jobs
+	}
+	allowed := jobs[:0]
+	for _, job := range jobs {
+		if d.robotsAllowed(ctx, job.url) {
+			allowed = append(allowed, job)
+		}
+	}
+	if n := len(jobs) - len(allowed); n > 0 {
+		slog.Info("robots.txtで禁止されているファイルを除外しました", "count", n)
+	}
+	return allowed
+}
+
+// loadedPageはloadPageで読み込んだページの内容です。
+type loadedPage struct {
+	images []ImageSource
+	client *http.Client // 画像のダウンロードに使う、ページのCookieを持つHTTPクライアント
+	title  string       // ページのタイトル（NoBrowserの場合は空）
+	links  []string     // ページ内のaタグのhref属性（Depthが1以上の場合のみ）
+	html   []byte       // ページのHTML（Mirrorの場合のみ。Chromeの場合はスクリプトを実行した後のDOM）
+}
+
+// loadPageはページの画像の属性を抽出し、画像のダウンロードに使うHTTPクライアントとページのタイトルと合わせて返します。
+// NoBrowserの場合はタイトルを取得しません。Depthが1以上の場合はページ内のリンクも取得します。
+// NoBrowserの場合はページのHTMLを直接取得して解析し、それ以外はブラウザに新しいタブを開いて抽出します。
+func (d *Downloader) loadPage(ctx context.Context, base *url.URL) (*loadedPage, error) {
+	opts := &d.opts
+	pageURL := base.String()
+	if opts.NoBrowser {
+		// ページの取得で設定されたCookieも画像のダウンロードで使う
+		client, err := d.pageClient(base, nil)
+		if err != nil {
+			slog.Error("HTTPクライアントの作成に失敗しました", "page", pageURL, "error", err)
+			return nil, err
+		}
+		body, err := fetchHTML(ctx, client, pageURL, opts.Download)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			slog.Error("ページの取得に失敗しました", "page", pageURL, "error", err)
+			return nil, err
+		}
+		page := &loadedPage{client: client}
+		if page.images, err = ParseImagesHTML(bytes.NewReader(body)); err != nil {
+			slog.Error("ページのHTMLの解析に失敗しました", "page", pageURL, "error", err)
+			return nil, err
+		}
+		if opts.Extract.Media {
+			media, err := ParseMediaHTML(bytes.NewReader(body))
+			if err != nil {
+				slog.Error("ページのHTMLの解析に失敗しました", "page", pageURL, "error", err)
+				return nil, err
+			}
+			page.images = append(page.images, media...)
+		}
+		if opts.Extract.InlineData {
+			page.images = append(page.images, findInlineImages(string(body))...)
+		}
+		if opts.Depth > 0 {
+			page.links, _ = ParseLinksHTML(bytes.NewReader(body))
+		}
+		if opts.Mirror {
+			page.html = body
+		}
+		return page, nil
+	}
+
+	// chromedpのコンテキスト（タブ）を作成し、ページ遷移から画像の抽出までをTimeoutで打ち切る
+	// （タブはブラウザのコンテキストから作る必要があるため、ctxのキャンセルはAfterFuncで伝える）
+	tabCtx, cancel := chromedp.NewContext(d.browserCtx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	tabCtx, cancel = context.WithTimeout(tabCtx, opts.Timeout)
+	defer cancel()
+
+	// ページに遷移し、imgタグのsrc属性とCookieを取得
+	images, cookies, err := ExtractImages(tabCtx, pageURL, opts.Extract)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			slog.Error("ページの読み込みがタイムアウトしました", "page", pageURL, "timeout", opts.Timeout)
+		} else {
+			slog.Error("chromedp実行エラー", "page", pageURL, "error", err)
+		}
+		return nil, err
+	}
+	page := &loadedPage{images: images}
+	// タイトルはファイル名に使うだけのため、取得できなくてもURLのパスで代用する
+	if err := chromedp.Run(tabCtx, chromedp.Title(&page.title)); err != nil {
+		slog.Debug("ページのタイトルを取得できませんでした", "page", pageURL, "error", err)
+	}
+	if opts.Depth > 0 {
+		if err := chromedp.Run(tabCtx, chromedp.Evaluate(extractLinksJS, &page.links)); err != nil {
+			slog.Warn("ページのリンクを取得できませんでした", "page", pageURL, "error", err)
+		}
+	}
+	if opts.Mirror {
+		var outerHTML string
+		if err := chromedp.Run(tabCtx, chromedp.Evaluate(`document.documentElement.outerHTML`, &outerHTML)); err != nil {
+			slog.Error("ページのHTMLを取得できませんでした", "page", pageURL, "error", err)
+		} else {
+			// outerHTMLには文書型宣言が含まれないため、標準モードで表示されるよう付け直す
+			page.html = []byte("<!DOCTYPE html>" + outerHTML)
+		}
+	}
+	// 抽出と同じく、ExtractImagesでページの表示を待った後に保存する
+	d.saveSnapshots(tabCtx, pageURL)
+
+	// 取得したCookieを持つHTTPクライアントを作成（全画像で同じセッションを使う）
+	if page.client, err = d.pageClient(base, cookies); err != nil {
+		slog.Error("HTTPクライアントの作成に失敗しました", "page", pageURL, "error", err)
+		return nil, err
+	}
+	return page, nil
+}
+
+// pageClientはbaseのページとその画像の取得に使う、cookiesを設定したHTTPクライアントを返します。
+// BasicUserを指定した場合は、ページと同じホストへのリクエストにBasic認証の認証情報を付けます。
+func (d *Downloader) pageClient(base *url.URL, cookies []*network.Cookie) (*http.Client, error) {
+	client, err := newSessionClient(base, cookies, d.client)
+	if err != nil {
+		return nil, err
+	}
+	return withBasicAuth(client, base.Host, d.opts.Extract.BasicAuth), nil
+}
+
+// loadAPIJobsはGROWIのAPIでページの添付ファイルの一覧を取得し、添付ファイルのダウンロードに使うHTTPクライアントと
+// ダウンロードジョブを返します。ファイル名にはアップロード時のファイル名を使います。
+func (d *Downloader) loadAPIJobs(ctx context.Context, base *url.URL) (*http.Client, []downloadJob, error) {
+	opts := &d.opts
+	pageURL := base.String()
+	client, err := d.pageClient(base, nil)
+	if err != nil {
+		slog.Error("HTTPクライアントの作成に失敗しました", "page", pageURL, "error", err)
+		return nil, nil, err
+	}
+	// 添付ファイルのダウンロードにもAPIトークンが必要
+	client = withGROWIToken(client, base.Host, opts.APIToken)
+	attachments, err := ListGROWIAttachments(ctx, client, base, opts.APIToken, opts.Download)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		slog.Error("添付ファイルの一覧の取得に失敗しました", "page", pageURL, "error", err)
+		return nil, nil, err
+	}
+
+	// 貼り付けた画像はほとんどが"image.png"のため、同じ名前の添付ファイルは名前に添付ファイルのIDを付けて区別する
+	// （実行のたびに同じ名前になるよう、保存時の番号付けには頼らない）
+	names := make([]string, len(attachments))
+	count := make(map[string]int)
+	for i, a := range attachments {
+		names[i] = sanitizeFilename(a.OriginalName)
+		count[names[i]]++
+	}
+	var jobs []downloadJob
+	filtered := 0
+	pageTitle := pageTitleOrPath("", base)
+	for i, a := range attachments {
+		attachmentURL := a.attachmentURL(base)
+		fileName := names[i]
+		switch {
+		case fileName == "":
+			fileName = sanitizeFilename(a.ID)
+		case count[fileName] > 1:
+			ext := filepath.Ext(fileName)
+			fileName = truncateFilename(strings.TrimSuffix(fileName, ext)+"_"+sanitizeFilename(a.ID)+ext, maxFilenameBytes)
+		}
+		fileName = d.jobFileName(i+1, fileName, attachmentURL, pageTitle)
+		if !opts.URLFilter.Allow(attachmentURL) || !opts.Download.ExtFilter.Allow(filepath.Ext(fileName)) {
+			filtered++
+			continue
+		}
+		fmt.Fprintf(opts.Output, "Attachment %d: %s (%s)\n", i+1, fileName, attachmentURL)
+		jobs = append(jobs, downloadJob{source: a.ID, url: attachmentURL, fileName: fileName, keepName: true})
+	}
+	if filtered > 0 {
+		slog.Info("絞り込みの条件により添付ファイルを除外しました", "count", filtered)
+	}
+	return client, jobs, nil
+}
+
+// savedPathはrのファイルを保存したOutDirからの相対パスを返します（PerPageDirの場合はページごとのサブディレクトリを含む）。
+func (d *Downloader) savedPath(r Result) string {
+	if d.opts.PerPageDir {
+		if pageURL, err := url.Parse(r.Page); err == nil {
+			return filepath.Join(pageDirName(pageURL), r.File)
+		}
+	}
+	return r.File
+}
+
+// preservedPathはPreservePathで使う、URLのエスケープされたパスに対応するOutDirからの相対ディレクトリとファイル名を返します。
+// パスの各要素はsanitizeFilenameで整え、空になる要素（"."や".."、"%2e%2e"など）は取り除くため、OutDirの外を指すことはありません。
+func preservedPath(escapedPath string) (dir, name string) {
+	var segments []string
+	for _, segment := range strings.Split(escapedPath, "/") {
+		if s := sanitizeFilename(segment); s != "" {
+			segments = append(segments, s)
+		}
+	}
+	// パスが"/"で終わる場合は最後の要素もディレクトリとみなし、ファイル名は呼び出し側で決める
+	if len(segments) == 0 || strings.HasSuffix(escapedPath, "/") {
+		return filepath.Join(segments...), ""
+	}
+	return filepath.Join(segments[:len(segments)-1]...), segments[len(segments)-1]
+}
+
+// pageDirNameはPerPageDirで使うページごとのサブディレクトリ名をページURLから作成します。
+func pageDirName(pageURL *url.URL) string {
+	p := strings.Trim(pageURL.EscapedPath(), "/")
+	name := sanitizeFilename(strings.ReplaceAll(p, "/", "_"))
+	if name == "" {
+		return "index"
+	}
+	return name
+}
+
+// downloadJobは1つの画像のダウンロード内容を表します。
+type downloadJob struct {
+	source   string // imgタグから取得した元のsrc
+	url      string // 絶対URL（data: URIの場合はそのまま）
+	fileName string
+	keepName bool   // Content-Dispositionのファイル名を使わずにfileNameで保存する
+	alt      string // imgタグのalt属性
+	title    string // imgタグのtitle属性
+}
+
+// buildJobsは抽出した画像の属性から絶対URLと保存先のファイル名を決め、ダウンロードジョブを作成します。
+// PreferLinkedの場合、リンク先が画像かどうかの確認にclientを使います。
+func (d *Downloader) buildJobs(ctx context.Context, client *http.Client, base *url.URL, title string, images []ImageSource) []downloadJob {
+	out := d.opts.Output
+	var jobs []downloadJob
+	pageTitle := pageTitleOrPath(title, base)
+	// 同じ画像が複数のimgタグで参照されている場合は最初の1つだけをダウンロードする
+	seen := make(map[string]bool)
+	duplicates, filtered, tooSmall, unmatched := 0, 0, 0, 0
+	for i, img := range images {
+		// iframe内の画像はフレームのURLを基準に相対URLを解決する
+		imgBase := base
+		if img.Base != "" {
+			if b, err := base.Parse(img.Base); err == nil {
+				imgBase = b
+			}
+		}
+		src := img.Src
+		// srcsetがあれば最も高解像度の候補（画面の解像度を指定した場合はそれに合う候補）を使い、なければsrcにフォールバック
+		if d.opts.PreferSrcset {
+			best := bestSrcsetCandidate(img.Srcset)
+			if density := d.opts.Extract.density(); density > 0 {
+				best = srcsetCandidateFor(img.Srcset, density)
+			}
+			if best != "" {
+				src = best
+			}
+		}
+		// サムネイルが元の画像へのリンクで囲まれている場合はリンク先を使う
+		linked := false
+		if d.opts.PreferLinked && img.Link != "" {
+			if linkURL, err := imgBase.Parse(img.Link); err == nil && isImageURL(ctx, client, linkURL.String(), d.opts.Download) {
+				src, linked = linkURL.String(), true
+			}
+		}
+		if src == "" {
+			continue
+		}
+		// リンク先の画像はサムネイルより大きいため、サムネイルの大きさでは除外しない
+		if !linked && !d.opts.SizeFilter.Allow(img.Width, img.Height) {
+			tooSmall++
+			continue
+		}
+
+		// data: URIはURLを持たないため、デコードして連番のファイル名で保存する
+		if isDataURI(src) {
+			slog.Debug("画像を抽出しました", "index", i+1, "src", displayURL(src))
+			// 同じ画像を埋め込んだ要素が複数あっても1つだけ保存する
+			key := dataURIKey(src)
+			if seen[key] {
+				duplicates++
+				continue
+			}
+			seen[key] = true
+			if !d.opts.URLFilter.Allow(displayURL(src)) {
+				unmatched++
+				continue
+			}
+			fmt.Fprintf(out, "Image %d: (data URI)\n", i+1)
+			fileName := d.jobFileName(i+1, fmt.Sprintf("image_%d", i+1), src, pageTitle)
+			jobs = append(jobs, downloadJob{source: src, url: src, fileName: fileName, alt: img.Alt, title: img.Title})
+			continue
+		}
+
+		// ベースURLとsrcを結合して絶対URLを生成
+		imgURL, err := imgBase.Parse(src)
+		if err != nil {
+			slog.Warn("srcのパースに失敗しました", "src", src, "error", err)
+			continue
+		}
+
+		slog.Debug("画像を抽出しました", "index", i+1, "src", src, "url", imgURL.String())
+
+		if seen[imgURL.String()] {
+			duplicates++
+			continue
+		}
+		seen[imgURL.String()] = true
+
+		if !d.opts.URLFilter.Allow(imgURL.String()) {
+			unmatched++
+			continue
+		}
+
+		// URLから拡張子が分かる場合はダウンロード前に絞り込む（分からない場合はContent-Typeで判断する）
+		if ext := path.Ext(imgURL.Path); ext != "" && !d.opts.Download.ExtFilter.Allow(ext) {
+			filtered++
+			continue
+		}
+
+		// URLを出力
+		fmt.Fprintf(out, "Image %d: %s\n", i+1, imgURL.String())
+
+		// ダウンロードするファイル名はURLの最後の名前（パスのベース名）を使用する
+		dir, fileName := "", ""
+		if d.opts.PreservePath {
+			dir, fileName = preservedPath(imgURL.EscapedPath())
+		} else if base := filepath.Base(imgURL.Path); base != "/" && base != "." {
+			fileName = sanitizeFilename(base)
+		}
+		// ファイル名が取得できない場合は、連番でファイル名を生成する（拡張子はダウンロード時に決める）
+		if fileName == "" {
+			fileName = fmt.Sprintf("image_%d", i+1)
+		}
+		fileName = filepath.Join(dir, d.jobFileName(i+1, fileName, imgURL.String(), pageTitle))
+
+		jobs = append(jobs, downloadJob{source: src, url: imgURL.String(), fileName: fileName, alt: img.Alt, title: img.Title})
+	}
+	if duplicates > 0 {
+		slog.Info("重複した画像URLをまとめました", "count", duplicates)
+	}
+	if unmatched > 0 {
+		slog.Info("URLの正規表現で画像を除外しました", "count", unmatched)
+	}
+	if tooSmall > 0 {
+		slog.Info("小さい画像を除外しました", "count", tooSmall, "min_width", d.opts.SizeFilter.MinWidth, "min_height", d.opts.SizeFilter.MinHeight)
+	}
+	if filtered > 0 {
+		slog.Info("拡張子が対象外の画像を除外しました", "count", filtered)
+	}
+	return jobs
+}
+
+// runDownloadsはconcurrency個のワーカーでjobsをダウンロードし、全て終わるまで待ちます。
+// 結果はjobsと同じ順序で返します。ctxがキャンセルされた後の未着手のジョブは、キャンセルの理由を結果に記録します。
+// failFastの場合は最初の失敗で残りのジョブを中止し、ErrFailFastをラップしたエラーを返します。
+func runDownloads(ctx context.Context, client *http.Client, pageURL string, jobs []downloadJob, concurrency int, failFast bool, opts DownloadOptions) ([]Result, error) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]Result, len(jobs))
+	opts.Progress.add(len(jobs))
+	indexCh := make(chan int, len(jobs))
+	for i := range jobs {
+		indexCh <- i
+	}
+	close(indexCh)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexCh {
+				job := jobs[i]
+				if jobCtx.Err() != nil {
+					results[i] = newResult(pageURL, job, DownloadResult{FileName: job.fileName}, context.Cause(jobCtx))
+					opts.JSONL.write(results[i], opts)
+					opts.Progress.finish()
+					continue
+				}
+				start := time.Now()
+				result, err := runJob(jobCtx, client, job, opts)
+				switch {
+				case err != nil && jobCtx.Err() != nil:
+					// 他のジョブの失敗やシグナルで中断された
+					err = context.Cause(jobCtx)
+				case err != nil:
+					slog.Error("画像のダウンロードに失敗しました", "url", displayURL(job.url), "error", err)
+					if failFast {
+						cancel(fmt.Errorf("%w: %s: %v", ErrFailFast, displayURL(job.url), err))
+					}
+				case !result.Skipped:
+					slog.Info("画像をダウンロードしました", "url", displayURL(job.url), "file", result.FileName, "size", result.Size)
+					if opts.Downloaded != nil {
+						opts.Downloaded.Add(result.Size)
+					}
+				}
+				if err == nil && !result.Filtered {
+					opts.Thumbnails.Add(filepath.Join(opts.OutDir, result.FileName))
+				}
+				slog.Debug("ダウンロードの所要時間", "url", displayURL(job.url), "elapsed", time.Since(start))
+				results[i] = newResult(pageURL, job, result, err)
+				opts.JSONL.write(results[i], opts)
+				opts.Progress.finish()
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() == nil && jobCtx.Err() != nil {
+		return results, context.Cause(jobCtx)
+	}
+	return results, nil
+}
+
+// runJobはjobのURLの種類に応じて、data: URIのデコードかHTTPでのダウンロードを行います。
+// opts.SidecarAltの場合は、保存した（または既に存在した）ファイルの隣にalt属性を書き込みます。
+func runJob(ctx context.Context, client *http.Client, job downloadJob, opts DownloadOptions) (DownloadResult, error) {
+	var result DownloadResult
+	var err error
+	if isDataURI(job.url) {
+		result, err = saveDataURI(job.url, job.fileName, opts)
+	} else {
+		if job.keepName {
+			opts.IgnoreContentDisposition = true
+		}
+		result, err = DownloadFile(ctx, client, job.url, job.fileName, opts)
+	}
+	if err == nil && !result.Filtered && opts.SidecarAlt {
+		err = writeAltSidecar(filepath.Join(opts.OutDir, result.FileName), job.alt)
+	}
+	return result, err
+}
+
+// newResultはpageURLのジョブとその結果からResultを作成します。
+func newResult(pageURL string, job downloadJob, result DownloadResult, err error) Result {
+	r := Result{
+		Page:        pageURL,
+		Source:      displayURL(job.source),
+		URL:         displayURL(job.url),
+		File:        result.FileName,
+		Size:        result.Size,
+		ContentType: result.ContentType,
+		Status:      result.StatusCode,
+		FinalURL:    result.FinalURL,
+		SHA256:      result.SHA256,
+		Alt:         job.alt,
+		Title:       job.title,
+		Success:     err == nil,
+		Skipped:     result.Skipped,
+		Filtered:    result.Filtered,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// displayURLはログやResultに出力するURLを返します。
+// data: URIはデータ部分が長いため、ヘッダ部分（"data:image/png;base64"など）だけにします。
+func displayURL(u string) string {
+	if isDataURI(u) {
+		header, _, _ := strings.Cut(u, ",")
+		return header
+	}
+	return u
+}
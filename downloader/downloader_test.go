@@ -0,0 +1,281 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDownloaderはbuildJobsなどを試すための、Chromeを起動しないDownloaderを作成します。
+func newTestDownloader(opts Options) *Downloader {
+	opts.Output = io.Discard
+	return &Downloader{opts: opts, client: http.DefaultClient}
+}
+
+// jobURLsはjobsのURLを順に返します。
+func jobURLs(jobs []downloadJob) []string {
+	var urls []string
+	for _, job := range jobs {
+		urls = append(urls, job.url)
+	}
+	return urls
+}
+
+// TestBuildJobsDeduplicateは同じURLの画像を最初の1つだけにまとめ、元の順序を保つことを確認します。
+func TestBuildJobsDeduplicate(t *testing.T) {
+	base, _ := url.Parse("https://growi.example.com/Docs/page")
+	images := []ImageSource{
+		{Src: "/attachment/1"},
+		{Src: "https://growi.example.com/attachment/2"},
+		{Src: "https://growi.example.com/attachment/1"},
+		{Src: "../attachment/2"},
+		{Src: "/attachment/3"},
+		{Src: "/attachment/1"},
+	}
+	jobs := newTestDownloader(Options{}).buildJobs(context.Background(), http.DefaultClient, base, "", images)
+	want := []string{
+		"https://growi.example.com/attachment/1",
+		"https://growi.example.com/attachment/2",
+		"https://growi.example.com/attachment/3",
+	}
+	if got := jobURLs(jobs); !reflect.DeepEqual(got, want) {
+		t.Errorf("URL = %v, want %v", got, want)
+	}
+}
+
+// TestBuildJobsPreferLinkedはリンクで囲まれたサムネイルはリンク先の画像を、囲まれていない画像や
+// リンク先が画像でない場合はサムネイルを使うことを確認します。
+func TestBuildJobsPreferLinked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/attachment/9":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+		}
+	}))
+	defer srv.Close()
+	base, _ := url.Parse(srv.URL + "/page")
+
+	images := []ImageSource{
+		{Src: "/thumb1.png", Link: "/full1.png"},         // 拡張子で画像と分かる
+		{Src: "/thumb2.png", Link: "/attachment/9"},      // Content-Typeで画像と分かる
+		{Src: "/thumb3.png", Link: "/Docs/another-page"}, // リンク先がページ
+		{Src: "/thumb4.png", Link: "/readme.html"},       // リンク先が画像でない拡張子
+		{Src: "/plain.png"},                              // リンクで囲まれていない
+	}
+	d := newTestDownloader(Options{PreferLinked: true, SizeFilter: SizeFilter{MinWidth: 100, MinHeight: 100, Strict: true}})
+	got := jobURLs(d.buildJobs(context.Background(), srv.Client(), base, "", images))
+	// Strictの大きさの絞り込みはサムネイルにのみ適用される
+	want := []string{srv.URL + "/full1.png", srv.URL + "/attachment/9"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Strict: URL = %v, want %v", got, want)
+	}
+
+	d = newTestDownloader(Options{PreferLinked: true})
+	got = jobURLs(d.buildJobs(context.Background(), srv.Client(), base, "", images))
+	want = []string{srv.URL + "/full1.png", srv.URL + "/attachment/9", srv.URL + "/thumb3.png", srv.URL + "/thumb4.png", srv.URL + "/plain.png"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("URL = %v, want %v", got, want)
+	}
+
+	// PreferLinkedでなければ常にサムネイルを使う
+	d = newTestDownloader(Options{})
+	got = jobURLs(d.buildJobs(context.Background(), srv.Client(), base, "", images[:1]))
+	if want := []string{srv.URL + "/thumb1.png"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("URL = %v, want %v", got, want)
+	}
+}
+
+// TestNewNoBrowserOptionsはNoBrowserの場合にChromeが必要な抽出の設定を指定するとエラーになることを確認します。
+func TestNewNoBrowserOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*Options)
+	}{
+		{"Selector", func(o *Options) { o.Extract.Selector = "video source" }},
+		{"Attr", func(o *Options) { o.Extract.Attr = "href" }},
+		{"Backgrounds", func(o *Options) { o.Extract.Backgrounds = true }},
+		{"Iframes", func(o *Options) { o.Extract.Iframes = true }},
+		{"PierceShadow", func(o *Options) { o.Extract.PierceShadow = true }},
+		{"Device", func(o *Options) { o.Device = DefaultMobileDevice }},
+		{"Screenshot", func(o *Options) { o.Screenshot.Path = "page.png" }},
+		{"PDF", func(o *Options) { o.PDF.Path = "page.pdf" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.OutDir = t.TempDir()
+			opts.NoBrowser = true
+			tt.set(&opts)
+			if d, err := New(opts); err == nil {
+				d.Close()
+				t.Error("エラーになりませんでした")
+			}
+		})
+	}
+}
+
+// TestBuildJobsPreservePathはURLのディレクトリ構造をファイル名に残し、".."などでOutDirの外を指せないことを確認します。
+func TestBuildJobsPreservePath(t *testing.T) {
+	base, _ := url.Parse("https://growi.example.com/Docs/page")
+	images := []ImageSource{
+		{Src: "/attachment/page/a.png"},
+		{Src: "/attachment/%2e%2e/%2E%2E/etc/passwd.png"},
+		{Src: "/attachment/..%2f..%2fb.png"},
+		{Src: "../../../../c.png"},
+		{Src: "/dir/sub/"},
+		{Src: "/files/設計%20書/d.png"},
+	}
+	jobs := newTestDownloader(Options{PreservePath: true}).buildJobs(context.Background(), http.DefaultClient, base, "", images)
+	want := []string{
+		filepath.Join("attachment", "page", "a.png"),
+		filepath.Join("attachment", "etc", "passwd.png"),
+		filepath.Join("attachment", ".._.._b.png"),
+		"c.png",
+		filepath.Join("dir", "sub", "image_5"),
+		filepath.Join("files", "設計 書", "d.png"),
+	}
+	var got []string
+	for _, job := range jobs {
+		got = append(got, job.fileName)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ファイル名 = %q, want %q", got, want)
+	}
+}
+
+// TestBuildJobsScaleFactorは画面の解像度を指定した場合に、srcsetから最大密度ではなく解像度に合う候補を選ぶことを確認します。
+func TestBuildJobsScaleFactor(t *testing.T) {
+	base, _ := url.Parse("https://growi.example.com/page")
+	images := []ImageSource{{Src: "/a.png", Srcset: "/a.png 1x, /b.png 2x, /c.png 3x"}}
+	for _, tt := range []struct {
+		scale float64
+		want  string
+	}{
+		{0, "c.png"},
+		{2, "b.png"},
+	} {
+		d := newTestDownloader(Options{PreferSrcset: true, Extract: ExtractOptions{ScaleFactor: tt.scale}})
+		jobs := d.buildJobs(context.Background(), http.DefaultClient, base, "", images)
+		if len(jobs) != 1 || jobs[0].fileName != tt.want {
+			t.Errorf("ScaleFactor %g: jobs = %+v, want %s", tt.scale, jobs, tt.want)
+		}
+	}
+}
+
+// TestDownloadFilePreservePathはディレクトリを含むファイル名の場合に途中のディレクトリを作成し、
+// Content-Dispositionのファイル名はディレクトリを残したまま使うことを確認します。
+func TestDownloadFilePreservePath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/attachment/649abc" {
+			w.Header().Set("Content-Disposition", `attachment; filename="../report.pdf"`)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	opts := DownloadOptions{OutDir: t.TempDir()}
+	for _, tt := range []struct{ path, fileName, want string }{
+		{"/attachment/page/a.png", filepath.Join("attachment", "page", "a.png"), filepath.Join("attachment", "page", "a.png")},
+		{"/attachment/649abc", filepath.Join("attachment", "649abc"), filepath.Join("attachment", "report.pdf")},
+	} {
+		result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+tt.path, tt.fileName, opts)
+		if err != nil {
+			t.Fatalf("%s: DownloadFile: %v", tt.path, err)
+		}
+		if result.FileName != tt.want {
+			t.Errorf("%s: FileName = %q, want %q", tt.path, result.FileName, tt.want)
+		}
+		if got := readFile(t, filepath.Join(opts.OutDir, tt.want)); got != tt.path {
+			t.Errorf("%s: 内容 = %q", tt.path, got)
+		}
+	}
+}
+
+// TestDownloadPageDelayは続けてページを処理する場合に、前のページの処理を終えてからPageDelay以上待って次のページを読み込み、
+// ページ内の画像のダウンロードは待たないことを確認します。
+func TestDownloadPageDelay(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	var mu sync.Mutex
+	requested := make(map[string]time.Time)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = time.Now()
+		mu.Unlock()
+		if n, ok := strings.CutPrefix(r.URL.Path, "/page"); ok {
+			fmt.Fprintf(w, `<img src="/a%s.png"><img src="/b%s.png">`, n, n)
+			return
+		}
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.RespectRobots = false
+	opts.Concurrency = 1
+	opts.PageDelay = delay
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	for _, page := range []string{"/page1", "/page2"} {
+		if _, err := d.Download(context.Background(), srv.URL+page); err != nil {
+			t.Fatalf("Download(%s): %v", page, err)
+		}
+	}
+	if gap := requested["/page2"].Sub(requested["/b1.png"]); gap < delay {
+		t.Errorf("前のページの最後の画像から次のページまでの間隔 = %v, want %v以上", gap, delay)
+	}
+	if gap := requested["/b1.png"].Sub(requested["/page1"]); gap >= delay {
+		t.Errorf("ページ内の画像のダウンロードが待たされました: %v", gap)
+	}
+}
+
+// TestWithOptionsはChromeを起動していないDownloaderから、Chromeを使わない設定のDownloaderだけを作成でき、
+// HTTPクライアントと転送速度の制限とrobots.txtの記録を共有することを確認します。
+func TestWithOptions(t *testing.T) {
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.MaxRate = 1024 * 1024
+	opts.RespectRobots = true
+	d, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	opts.DryRun = true
+	nd, err := d.WithOptions(opts)
+	if err != nil {
+		t.Fatalf("WithOptions: %v", err)
+	}
+	defer nd.Close()
+	if !nd.opts.DryRun || nd.client != d.client {
+		t.Error("設定かHTTPクライアントが引き継がれていません")
+	}
+	// -serveのリクエストごとに作り直すと、同時に処理するリクエストの数だけ転送速度の上限が増えてしまう
+	if nd.opts.Download.Limiter == nil || nd.opts.Download.Limiter != d.opts.Download.Limiter {
+		t.Error("転送速度の制限が共有されていません")
+	}
+	if nd.robots == nil || nd.robots != d.robots {
+		t.Error("robots.txtの記録が共有されていません")
+	}
+
+	opts.NoBrowser = false
+	if _, err := d.WithOptions(opts); err == nil {
+		t.Error("Chromeを使う設定でエラーになりませんでした")
+	}
+}
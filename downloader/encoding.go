@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// contentEncodingsはレスポンスのContent-Encodingを、適用された順に小文字で返します（identityは除きます）。
+// net/httpのTransportが展開済みの場合（Accept-Encodingを自動で付けた場合）は何も返しません。
+func contentEncodings(resp *http.Response) []string {
+	if resp.Uncompressed {
+		return nil
+	}
+	var encodings []string
+	for _, value := range resp.Header.Values("Content-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	return encodings
+}
+
+// decodeBodyはContent-Encodingのencodingsで圧縮されたrを展開するio.Readerを返します。
+// -headerでAccept-Encodingを指定した場合や、プロキシが圧縮して返した場合に、圧縮されたままのデータを保存しないようにします。
+func decodeBody(r io.Reader, encodings []string) (io.Reader, error) {
+	// 最後に適用された圧縮から展開する
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = deflateReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		default:
+			return nil, fmt.Errorf("未対応のContent-Encodingです: %s", encodings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Content-Encoding（%s）のデータを展開できません: %w", encodings[i], err)
+		}
+	}
+	return r, nil
+}
+
+// deflateReaderはContent-Encoding: deflateのrを展開するio.Readerを返します。
+// 仕様ではzlib形式ですが、ヘッダのないdeflate形式で返すサーバーもあるため、先頭の2バイトで判別します。
+func deflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// zlibのヘッダは圧縮方式が8（deflate）で、2バイトを16ビットの数とみなすと31の倍数になる
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
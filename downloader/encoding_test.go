@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encodeBodyはdataをContent-Encodingのencodingで圧縮したものを返します。
+func encodeBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("未知のencoding: %s", encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestDownloadFileContentEncodingはContent-Encodingで圧縮された画像を展開して保存することを確認します。
+func TestDownloadFileContentEncoding(t *testing.T) {
+	image := []byte("\x89PNG\r\n\x1a\n" + string(bytes.Repeat([]byte("image data "), 100)))
+	tests := []struct {
+		name    string
+		header  string // Content-Encodingの値
+		encoded []byte
+	}{
+		{"gzip", "gzip", encodeBody(t, "gzip", image)},
+		{"brotli", "br", encodeBody(t, "br", image)},
+		{"deflate（zlib形式）", "deflate", encodeBody(t, "deflate", image)},
+		{"deflate（ヘッダなし）", "deflate", encodeBody(t, "raw-deflate", image)},
+		{"gzipとbrotli", "gzip, br", encodeBody(t, "br", encodeBody(t, "gzip", image))},
+		{"identity", "identity", image},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Header().Set("Content-Encoding", tt.header)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.encoded)))
+				w.Write(tt.encoded)
+			}))
+			defer srv.Close()
+			// Accept-Encodingを指定すると、net/httpはgzipも展開しない
+			opts := DownloadOptions{OutDir: t.TempDir(), Header: http.Header{"Accept-Encoding": {"gzip, deflate, br"}}}
+			result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Size != int64(len(image)) {
+				t.Errorf("Size = %d, want %d", result.Size, len(image))
+			}
+			if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != string(image) {
+				t.Errorf("保存した内容が展開されていません（%dバイト）", len(got))
+			}
+		})
+	}
+}
+
+// TestDownloadFileUnknownEncodingは未対応のContent-Encodingの場合に、圧縮されたままのファイルを保存しないことを確認します。
+func TestDownloadFileUnknownEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Write([]byte("compressed"))
+	}))
+	defer srv.Close()
+	opts := DownloadOptions{OutDir: t.TempDir()}
+	if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts); err == nil {
+		t.Fatal("未対応のContent-Encodingでエラーになりません")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(opts.OutDir, "*")); len(matches) > 0 {
+		t.Errorf("ファイルが残っています: %v", matches)
+	}
+}
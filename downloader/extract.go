@@ -0,0 +1,565 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/device"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ExtractOptionsはページからの画像抽出の設定です。
+type ExtractOptions struct {
+	Selector     string        // 抽出する要素のCSSセレクタ（空の場合はDefaultSelector）
+	Attr         string        // URLを取得する属性（空の場合はDefaultAttr）
+	WaitSelector string        // 抽出前に表示を待つ要素のCSSセレクタ
+	MaxWait      time.Duration // WaitSelectorの要素を待つ時間の上限（NetworkIdleとWaitJSで待つ時間の上限にも使う）
+	NetworkIdle  time.Duration // 抽出前に実行中のリクエストがない状態がこの時間続くのを待つ（0の場合は待たない）
+	WaitJS       string        // 抽出前に真になるのを待つJavaScriptの式（MaxWaitまで繰り返し評価する。空の場合は待たない）
+	Scroll       bool          // 抽出前にページ末尾までスクロールするかどうか
+	Backgrounds  bool          // CSSのbackground-imageに指定された画像も抽出するかどうか
+	Iframes      bool          // 同一オリジンのiframe内の画像も抽出するかどうか
+	PierceShadow bool          // openのshadow root内（Web Componentsが描画した要素）の画像も抽出するかどうか
+	InlineData   bool          // style属性やdata-*属性、CSSなどに埋め込まれたbase64の画像（data: URI）も抽出するかどうか
+	Media        bool          // videoタグ・audioタグとその中のsourceタグ、pictureタグのsourceタグのURLも抽出するかどうか
+	UserAgent    string        // ブラウザのUser-Agent（空の場合はChromeの既定値）
+	Device       *device.Info  // エミュレートするデバイス（画面の大きさ・解像度・User-Agent。nilの場合はエミュレートしない）
+	ScaleFactor  float64       // 画面の解像度（devicePixelRatio。0の場合は変更しない。Deviceを指定した場合はデバイスの解像度を使う）
+	WindowWidth  int           // ページを表示する画面の幅（ピクセル。0の場合は変更しない。Deviceを指定した場合はデバイスの幅を使う）
+	WindowHeight int           // ページを表示する画面の高さ（ピクセル。0の場合は変更しない。Deviceを指定した場合はデバイスの高さを使う）
+	Headers      http.Header   // ブラウザの全てのリクエストに追加するヘッダ
+	ProxyAuth    *url.Userinfo // プロキシ認証の認証情報（不要な場合はnil）
+	BasicAuth    *url.Userinfo // ページのホストのBasic認証の認証情報（不要な場合はnil）
+}
+
+// selectorは抽出する要素のCSSセレクタを返します。
+func (o ExtractOptions) selector() string {
+	if o.Selector == "" {
+		return DefaultSelector
+	}
+	return o.Selector
+}
+
+// attrはURLを取得する属性を返します。
+func (o ExtractOptions) attr() string {
+	if o.Attr == "" {
+		return DefaultAttr
+	}
+	return o.Attr
+}
+
+// densityは画像を表示する画面の解像度（srcsetのxディスクリプタと比べる値）を返します。指定がなければ0を返します。
+func (o ExtractOptions) density() float64 {
+	if o.Device != nil {
+		return o.Device.Scale
+	}
+	return o.ScaleFactor
+}
+
+// isDefaultTargetは抽出の対象が既定（imgタグのsrc属性）かどうかを返します。
+func (o ExtractOptions) isDefaultTarget() bool {
+	return o.selector() == DefaultSelector && o.attr() == DefaultAttr
+}
+
+// ImageSourceはページから抽出した1つのimgタグの属性です。
+type ImageSource struct {
+	Src    string `json:"src"`
+	Srcset string `json:"srcset"`
+	Link   string `json:"link"`   // imgタグを囲むaタグのhref属性（ない場合は空）
+	Alt    string `json:"alt"`    // alt属性（画像の説明。ない場合は空）
+	Title  string `json:"title"`  // title属性（ない場合は空）
+	Base   string `json:"base"`   // Src・Srcset・Linkの相対URLの基準（baseタグのhref属性かiframeのURL。空の場合はページのURL）
+	Width  int    `json:"width"`  // 画像の実際の幅（naturalWidth）。読み込まれていない場合は0
+	Height int    `json:"height"` // 画像の実際の高さ（naturalHeight）。読み込まれていない場合は0
+}
+
+// DefaultSelectorとDefaultAttrはExtractOptions.SelectorとAttrの既定値です。
+const (
+	DefaultSelector = "img"
+	DefaultAttr     = "src"
+)
+
+// baseHrefJSはページのbaseタグのhref属性の絶対URL（baseタグがない場合は空文字列）を取得するJavaScriptです。
+const baseHrefJS = `document.querySelector("base[href]")?.href || ""`
+
+// extractImagesJSはselectorに一致する全要素のattr属性、囲んでいるリンク、alt・title属性、画像の実際の大きさを取得するJavaScriptを返します。
+func extractImagesJS(selector, attr string) string {
+	return `Array.from(document.querySelectorAll(` + jsString(selector) + `)).map(` + imageSourceJS(attr) + `)`
+}
+
+// imageSourceJSは要素からImageSourceの値を作るJavaScriptの関数を返します。
+// attrが"src"の場合、遅延読み込みの画像はdata-src/data-original属性に実際のURLを持つため、src属性より優先し、srcset属性も取得します。
+// 読み込みが終わっていない画像（とimg以外の要素）の大きさは0（不明）にします。
+func imageSourceJS(attr string) string {
+	src := `el.getAttribute(` + jsString(attr) + `) || ""`
+	srcset := `""`
+	if attr == DefaultAttr {
+		src = `el.getAttribute("data-src") || el.getAttribute("data-original") || el.getAttribute("src") || ""`
+		srcset = `el.getAttribute("data-srcset") || el.getAttribute("srcset") || ""`
+	}
+	return `el => ({
+	src: ` + src + `,
+	srcset: ` + srcset + `,
+	link: el.closest("a[href]")?.getAttribute("href") || "",
+	alt: el.getAttribute("alt") || "",
+	title: el.getAttribute("title") || "",
+	width: el.complete ? el.naturalWidth || 0 : 0,
+	height: el.complete ? el.naturalHeight || 0 : 0
+})`
+}
+
+// jsStringはsをJavaScriptの文字列リテラルにします。
+// JSONの文字列はJavaScriptの文字列リテラルとしても正しいため、セレクタなどに引用符が含まれていても安全に埋め込めます。
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// extractFrameImagesJSはiframe（入れ子も含む）内のselectorに一致する全要素の属性を取得するJavaScriptを返します。
+// 相対URLはフレームのURLを基準に解決するため、baseにフレームのbaseURIを設定します。
+// 別オリジンのフレームは中身を読めないため、skippedにフレームのURLを返します。
+func extractFrameImagesJS(selector, attr string) string {
+	return `(() => {
+	const toSource = ` + imageSourceJS(attr) + `;
+	const images = [], skipped = [];
+	const walk = doc => {
+		for (const frame of doc.querySelectorAll("iframe, frame")) {
+			let child = null;
+			try { child = frame.contentDocument; } catch (e) {}
+			if (!child) {
+				skipped.push(frame.src || "");
+				continue;
+			}
+			for (const el of child.querySelectorAll(` + jsString(selector) + `)) {
+				images.push({...toSource(el), base: child.baseURI});
+			}
+			walk(child);
+		}
+	};
+	walk(document);
+	return {images, skipped};
+})()`
+}
+
+// extractBackgroundsJSは全要素の計算済みスタイルのbackground-imageの値（"none"以外）を取得するJavaScriptです。
+// url()の解析はGo側（parseCSSURLs）で行います。
+const extractBackgroundsJS = `Array.from(document.querySelectorAll("*"))
+	.map(el => getComputedStyle(el).backgroundImage)
+	.filter(v => v && v !== "none")`
+
+// mediaSelectorはExtractOptions.Mediaで抽出する要素のCSSセレクタです。
+// pictureタグのsourceタグは画面の大きさなどで切り替える別の画像を持つため、imgタグとは別に抽出します。
+const mediaSelector = "video, audio, video > source, audio > source, picture > source"
+
+// extractMediaJSはmediaSelectorに一致する全要素のsrc・srcset属性などを取得するJavaScriptです。
+// sourceタグにURLを書いたvideoタグなど、URLを持たない要素は除きます。
+var extractMediaJS = `Array.from(document.querySelectorAll(` + jsString(mediaSelector) + `))
+	.map(` + imageSourceJS(DefaultAttr) + `)
+	.filter(s => s.src || s.srcset)`
+
+const (
+	scrollPause    = 300 * time.Millisecond // スクロールごとの待ち時間
+	waitJSInterval = 100 * time.Millisecond // WaitJSの式を評価する間隔
+	maxScrollSteps = 200                    // 無限スクロールのページで止まらなくなるのを防ぐ上限
+)
+
+// ExtractImagesはchromedpのコンテキスト（タブ）ctxでpageURLに遷移し、opts.WaitSelectorの要素が表示されるのを待ってから
+// 全imgタグの属性とページのCookieを取得します。
+// opts.MaxWait以内に要素が表示されない場合は、その時点のDOMから抽出します。
+func ExtractImages(ctx context.Context, pageURL string, opts ExtractOptions) ([]ImageSource, []*network.Cookie, error) {
+	var actions []chromedp.Action
+	// 接続先のChromeでも有効になるよう、起動オプションではなくタブごとにUser-Agentを設定する
+	if opts.UserAgent != "" {
+		actions = append(actions, emulation.SetUserAgentOverride(opts.UserAgent))
+	}
+	// レスポンシブな画像はページの読み込み時の画面の大きさで決まるため、遷移する前にエミュレートする
+	if opts.Device != nil {
+		actions = append(actions, chromedp.Emulate(opts.Device))
+	} else if opts.WindowWidth > 0 || opts.WindowHeight > 0 || opts.ScaleFactor > 0 {
+		// 0の値は変更しないことを表す
+		actions = append(actions, emulation.SetDeviceMetricsOverride(int64(opts.WindowWidth), int64(opts.WindowHeight), opts.ScaleFactor, false))
+	}
+	if len(opts.Headers) > 0 {
+		actions = append(actions, network.Enable(), network.SetExtraHTTPHeaders(extraHTTPHeaders(opts.Headers)))
+	}
+	// 読み込み中のリクエストを数えるため、遷移する前に記録を始める
+	var idle *networkIdle
+	if opts.NetworkIdle > 0 {
+		idle = listenNetworkIdle(ctx)
+		actions = append(actions, network.Enable())
+	}
+	// fetchドメインを有効にすると対象のリクエストは全て一時停止し、再開の往復を待つため、認証情報がある場合のみ有効にする
+	if opts.ProxyAuth != nil || opts.BasicAuth != nil {
+		listenAuthRequired(ctx, pageURL, opts.ProxyAuth, opts.BasicAuth)
+		actions = append(actions, fetch.Enable().WithHandleAuthRequests(true).WithPatterns(authRequestPatterns(pageURL, opts.ProxyAuth)))
+	}
+	actions = append(actions, chromedp.Navigate(pageURL))
+	if err := chromedp.Run(ctx, actions...); err != nil {
+		return nil, nil, err
+	}
+
+	// ページのレンダリング待ち
+	waitCtx, cancel := context.WithTimeout(ctx, opts.MaxWait)
+	err := chromedp.Run(waitCtx, chromedp.WaitVisible(opts.WaitSelector, chromedp.ByQuery))
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		slog.Warn("要素が表示されなかったため、現在のDOMから画像を抽出します", "selector", opts.WaitSelector, "max_wait", opts.MaxWait)
+	}
+
+	// GROWIのプラグインなどがページの準備ができたことを示す条件を待つ
+	if opts.WaitJS != "" {
+		err := chromedp.Run(ctx, chromedp.Poll(opts.WaitJS, nil, chromedp.WithPollingInterval(waitJSInterval), chromedp.WithPollingTimeout(opts.MaxWait)))
+		switch {
+		case ctx.Err() != nil:
+			return nil, nil, ctx.Err()
+		case errors.Is(err, chromedp.ErrPollingTimeout):
+			slog.Warn("JavaScriptの式が真にならなかったため、現在のDOMから画像を抽出します", "wait_js", opts.WaitJS, "max_wait", opts.MaxWait)
+		case err != nil:
+			return nil, nil, fmt.Errorf("JavaScriptの式の評価に失敗: %w", err)
+		}
+	}
+
+	if opts.Scroll {
+		if err := scrollToBottom(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// XHRで読み込まれる画像（スクロールで読み込み始めたものを含む）が揃うまで待つ
+	if idle != nil {
+		waitCtx, cancel := context.WithTimeout(ctx, opts.MaxWait)
+		err := idle.wait(waitCtx, opts.NetworkIdle)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			slog.Warn("読み込み中のリクエストがなくならなかったため、現在のDOMから画像を抽出します", "network_idle", opts.NetworkIdle, "max_wait", opts.MaxWait)
+		}
+	}
+
+	var images []ImageSource
+	var cookies []*network.Cookie
+	if err := chromedp.Run(ctx,
+		// document.querySelectorAllで全imgタグのsrc/srcsetを取得
+		chromedp.Evaluate(extractImagesJS(opts.selector(), opts.attr()), &images),
+		// ログインセッションを画像のダウンロードでも使うため、ページのCookieを取得
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			cookies, err = network.GetCookies().WithUrls([]string{pageURL}).Do(ctx)
+			return err
+		}),
+	); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.PierceShadow {
+		shadow, err := extractShadowImages(ctx, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, shadow...)
+	}
+
+	if opts.Iframes {
+		var frames struct {
+			Images  []ImageSource `json:"images"`
+			Skipped []string      `json:"skipped"`
+		}
+		if err := chromedp.Run(ctx, chromedp.Evaluate(extractFrameImagesJS(opts.selector(), opts.attr()), &frames)); err != nil {
+			return nil, nil, err
+		}
+		for _, src := range frames.Skipped {
+			slog.Warn("別オリジンのiframeのため画像を抽出できません", "frame", src)
+		}
+		images = append(images, frames.Images...)
+	}
+
+	if opts.Media {
+		var media []ImageSource
+		if err := chromedp.Run(ctx, chromedp.Evaluate(extractMediaJS, &media)); err != nil {
+			return nil, nil, err
+		}
+		images = append(images, media...)
+	}
+
+	if opts.Backgrounds {
+		var backgrounds []string
+		if err := chromedp.Run(ctx, chromedp.Evaluate(extractBackgroundsJS, &backgrounds)); err != nil {
+			return nil, nil, err
+		}
+		for _, bg := range backgrounds {
+			for _, u := range parseCSSURLs(bg) {
+				images = append(images, ImageSource{Src: u})
+			}
+		}
+	}
+	if opts.InlineData {
+		// スクリプトが設定した属性も含めるため、HTMLのソースではなく現在のDOMをシリアライズして探す
+		var doc string
+		if err := chromedp.Run(ctx, chromedp.Evaluate(`document.documentElement.outerHTML`, &doc)); err != nil {
+			return nil, nil, err
+		}
+		images = append(images, findInlineImages(doc)...)
+	}
+
+	// ブラウザと同じく、相対URLはbaseタグがあればそのhref属性を基準に解決する
+	var baseHref string
+	if err := chromedp.Run(ctx, chromedp.Evaluate(baseHrefJS, &baseHref)); err != nil {
+		return nil, nil, err
+	}
+	setImageBase(images, baseHref)
+	return images, cookies, nil
+}
+
+// setImageBaseはimagesのうちBaseが空のもの（iframe内の画像以外）のBaseをbaseHrefにします。baseHrefが空の場合は何もしません。
+func setImageBase(images []ImageSource, baseHref string) {
+	if baseHref == "" {
+		return
+	}
+	for i := range images {
+		if images[i].Base == "" {
+			images[i].Base = baseHref
+		}
+	}
+}
+
+// findBaseHrefはHTMLのノードn以下で最初のhref属性を持つbaseタグのhref属性を返します（ない場合は空文字列）。
+// ブラウザと同じく、2つ目以降のbaseタグは無視します。
+func findBaseHref(n *html.Node) string {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Base {
+		if href := firstAttr(n, "href"); href != "" {
+			return href
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if href := findBaseHref(c); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+// extraHTTPHeadersはheaderをChromeのnetwork.SetExtraHTTPHeadersに渡す形にします（同じ名前の値は", "でつなぎます）。
+func extraHTTPHeaders(header http.Header) network.Headers {
+	headers := make(network.Headers, len(header))
+	for key, values := range header {
+		headers[key] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+// authRequestPatternsは認証に応答するために一時停止させるリクエストのパターンを返します。
+// 一時停止したリクエストはChromeとの往復の分だけ遅くなるため、Basic認証だけの場合は認証情報を送るpageURLのホストのリクエストに限ります。
+// プロキシ認証は全てのリクエストで求められる可能性があるため、proxyAuthがある場合はnil（全てのリクエスト）を返します。
+func authRequestPatterns(pageURL string, proxyAuth *url.Userinfo) []*fetch.RequestPattern {
+	u, err := url.Parse(pageURL)
+	if proxyAuth != nil || err != nil {
+		return nil
+	}
+	return []*fetch.RequestPattern{{URLPattern: u.Scheme + "://" + u.Host + "/*", RequestStage: fetch.RequestStageRequest}}
+}
+
+// listenAuthRequiredはタブでプロキシ認証を求められた場合にproxyAuthで、pageURLと同じホストから認証を求められた場合にbasicAuthで
+// 応答するリスナーを登録します（どちらもnilの場合は既定の動作にします）。
+// fetchドメインではauthRequestPatternsに一致するリクエストが一時停止するため、認証以外のリクエストはそのまま再開します。
+func listenAuthRequired(ctx context.Context, pageURL string, proxyAuth, basicAuth *url.Userinfo) {
+	pageHost := ""
+	if u, err := url.Parse(pageURL); err == nil {
+		pageHost = u.Host
+	}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *fetch.EventRequestPaused:
+			go func() {
+				execCtx := cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Target)
+				if err := fetch.ContinueRequest(ev.RequestID).Do(execCtx); err != nil && ctx.Err() == nil {
+					slog.Warn("リクエストの再開に失敗しました", "url", ev.Request.URL, "error", err)
+				}
+			}()
+		case *fetch.EventAuthRequired:
+			go func() {
+				resp := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+				credentials := basicAuth
+				if ev.AuthChallenge.Source == fetch.AuthChallengeSourceProxy {
+					credentials = proxyAuth
+				} else if u, err := url.Parse(ev.Request.URL); err != nil || u.Host != pageHost {
+					// 別のホストにはページのBasic認証の認証情報を送らない
+					credentials = nil
+				}
+				if credentials != nil {
+					password, _ := credentials.Password()
+					resp = &fetch.AuthChallengeResponse{
+						Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+						Username: credentials.Username(),
+						Password: password,
+					}
+				}
+				execCtx := cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Target)
+				if err := fetch.ContinueWithAuth(ev.RequestID, resp).Do(execCtx); err != nil && ctx.Err() == nil {
+					slog.Warn("認証への応答に失敗しました", "url", ev.Request.URL, "error", err)
+				}
+			}()
+		}
+	})
+}
+
+// scrollToBottomはページを1画面ずつスクロールし、遅延読み込みの画像を読み込ませます。
+// 末尾に達してもdocument.body.scrollHeightが伸びなくなった時点で終了します。
+func scrollToBottom(ctx context.Context) error {
+	lastHeight := int64(-1)
+	for i := 0; i < maxScrollSteps; i++ {
+		var state struct {
+			Height int64 `json:"height"`
+			Bottom bool  `json:"bottom"`
+		}
+		if err := chromedp.Run(ctx,
+			chromedp.Evaluate(`window.scrollBy(0, window.innerHeight);
+				({height: document.body.scrollHeight, bottom: window.scrollY + window.innerHeight >= document.body.scrollHeight})`, &state),
+			chromedp.Sleep(scrollPause),
+		); err != nil {
+			return err
+		}
+		if state.Bottom && state.Height == lastHeight {
+			return nil
+		}
+		lastHeight = state.Height
+	}
+	slog.Warn("スクロール回数が上限に達したため、スクロールを終了します", "max_steps", maxScrollSteps)
+	return nil
+}
+
+// FetchImagesはpageURLのHTMLをclientで取得し、全imgタグの属性を抽出します。
+func FetchImages(ctx context.Context, client *http.Client, pageURL string, opts DownloadOptions) ([]ImageSource, error) {
+	body, err := fetchHTML(ctx, client, pageURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ParseImagesHTML(bytes.NewReader(body))
+}
+
+// fetchHTMLはpageURLのHTMLをclientで取得します（画像とリンクの抽出で同じHTMLを使うため、全体を読み込んで返します）。
+func fetchHTML(ctx context.Context, client *http.Client, pageURL string, opts DownloadOptions) ([]byte, error) {
+	resp, err := getWithRetry(ctx, client, pageURL, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTPステータスがOKではありません: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ParseImagesHTMLはHTMLを解析し、全imgタグのsrc/srcset・alt・title属性を取得します。
+// extractImagesJSの既定のセレクタと同様に、data-src/data-original属性をsrc属性より優先します。
+// 画像を読み込まないため、大きさは常に0（不明）です。
+func ParseImagesHTML(r io.Reader) ([]ImageSource, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageSource
+	// linkはnを囲む最も内側のaタグのhref属性
+	var walk func(n *html.Node, link string)
+	walk = func(n *html.Node, link string) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.A:
+				if href := firstAttr(n, "href"); href != "" {
+					link = href
+				}
+			case atom.Img:
+				images = append(images, ImageSource{
+					Src:    firstAttr(n, "data-src", "data-original", "src"),
+					Srcset: firstAttr(n, "data-srcset", "srcset"),
+					Link:   link,
+					Alt:    firstAttr(n, "alt"),
+					Title:  firstAttr(n, "title"),
+				})
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, link)
+		}
+	}
+	walk(doc, "")
+	setImageBase(images, findBaseHref(doc))
+	return images, nil
+}
+
+// ParseMediaHTMLはHTMLを解析し、videoタグ・audioタグとその中のsourceタグ、pictureタグのsourceタグの
+// src/srcset・title属性を取得します（extractMediaJSと同じ要素です）。URLを持たない要素は除きます。
+func ParseMediaHTML(r io.Reader) ([]ImageSource, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var media []ImageSource
+	var walk func(n *html.Node, link string)
+	walk = func(n *html.Node, link string) {
+		if n.Type == html.ElementNode {
+			isMedia := false
+			switch n.DataAtom {
+			case atom.A:
+				if href := firstAttr(n, "href"); href != "" {
+					link = href
+				}
+			case atom.Video, atom.Audio:
+				isMedia = true
+			case atom.Source:
+				parent := n.Parent.DataAtom
+				isMedia = parent == atom.Video || parent == atom.Audio || parent == atom.Picture
+			}
+			if isMedia {
+				src := ImageSource{
+					Src:    firstAttr(n, "data-src", "data-original", "src"),
+					Srcset: firstAttr(n, "data-srcset", "srcset"),
+					Link:   link,
+					Title:  firstAttr(n, "title"),
+				}
+				if src.Src != "" || src.Srcset != "" {
+					media = append(media, src)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, link)
+		}
+	}
+	walk(doc, "")
+	setImageBase(media, findBaseHref(doc))
+	return media, nil
+}
+
+// firstAttrはnの属性のうち、keysの順で最初に見つかった空でない値を返します。
+func firstAttr(n *html.Node, keys ...string) string {
+	for _, key := range keys {
+		for _, a := range n.Attr {
+			if a.Namespace == "" && a.Key == key && a.Val != "" {
+				return a.Val
+			}
+		}
+	}
+	return ""
+}
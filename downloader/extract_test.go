@@ -0,0 +1,268 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/fetch"
+)
+
+// TestParseImagesHTMLは静的なHTMLから全imgタグのsrc/srcset・alt・titleと囲んでいるリンクを取得できることを確認します。
+func TestParseImagesHTML(t *testing.T) {
+	const page = `<!DOCTYPE html>
+<html><body>
+<img src="/a.png">
+<p><img data-src="/lazy.png" src="/placeholder.gif" data-srcset="/lazy-2x.png 2x"></p>
+<a href="/full.png"><span><img src="/thumb.png" srcset="/thumb-640.png 640w"></span></a>
+<img alt="srcのない画像">
+<a><img src="/no-href.png" title="リンクなし"></a>
+</body></html>`
+	images, err := ParseImagesHTML(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImageSource{
+		{Src: "/a.png"},
+		{Src: "/lazy.png", Srcset: "/lazy-2x.png 2x"},
+		{Src: "/thumb.png", Srcset: "/thumb-640.png 640w", Link: "/full.png"},
+		{Alt: "srcのない画像"},
+		{Src: "/no-href.png", Title: "リンクなし"},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("images = %+v, want %+v", images, want)
+	}
+}
+
+// TestDownloadNoBrowserはNoBrowserの場合に、Chromeを使わずにページのHTMLから画像を抽出して保存することを確認します。
+func TestDownloadNoBrowser(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		// ページで設定されたCookieは画像のダウンロードでも使う
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret", Path: "/"})
+		w.Write([]byte(`<img src="attachment/1"><img srcset="/big.png 1000w, /small.png 100w" src="/small.png"><img src="attachment/1">`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+	for _, r := range results {
+		if !r.Success {
+			t.Errorf("失敗しました: %+v", r)
+		}
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "1.png")); got != "/attachment/1" {
+		t.Errorf("1.png の内容 = %q", got)
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "big.png")); got != "/big.png" {
+		t.Errorf("big.png の内容 = %q", got)
+	}
+}
+
+// TestDownloadNoBrowserBaseHrefはページにbaseタグがある場合に、imgタグの相対URLをページのURLではなく
+// baseタグのhref属性を基準に解決することを確認します。
+func TestDownloadNoBrowserBaseHref(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wiki/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><base href="/assets/"><base href="/ignored/"></head><body><img src="a.png"><img src="/root.png"></body></html>`))
+	})
+	mux.HandleFunc("/assets/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+	})
+	mux.HandleFunc("/root.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.RespectRobots = false
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	results, err := d.Download(context.Background(), srv.URL+"/wiki/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		if !r.Success {
+			t.Errorf("失敗しました: %+v", r)
+		}
+		got = append(got, r.URL)
+	}
+	want := []string{srv.URL + "/assets/a.png", srv.URL + "/root.png"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+}
+
+// TestParseMediaHTMLは静的なHTMLからvideo・audioタグとその中のsourceタグ、pictureタグのsourceタグのURLを取得できることを確認します。
+func TestParseMediaHTML(t *testing.T) {
+	const page = `<!DOCTYPE html>
+<html><body>
+<video src="/media/intro.mp4" title="紹介"></video>
+<video controls><source src="/media/clip.webm" type="video/webm"><source src="/media/clip.mp4" type="video/mp4"></video>
+<audio><source data-src="/media/voice.mp3"></audio>
+<picture><source srcset="/wide.png 2x" media="(min-width: 800px)"><img src="/narrow.png"></picture>
+<a href="/download"><video src="/media/linked.mp4"></video></a>
+<source src="/orphan.mp4">
+</body></html>`
+	media, err := ParseMediaHTML(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImageSource{
+		{Src: "/media/intro.mp4", Title: "紹介"},
+		{Src: "/media/clip.webm"},
+		{Src: "/media/clip.mp4"},
+		{Src: "/media/voice.mp3"},
+		{Srcset: "/wide.png 2x"},
+		{Src: "/media/linked.mp4", Link: "/download"},
+	}
+	if !reflect.DeepEqual(media, want) {
+		t.Errorf("media = %+v, want %+v", media, want)
+	}
+}
+
+// TestDownloadNoBrowserMediaはExtract.Mediaの場合に、videoタグとpictureタグのsourceタグのURLも保存し、
+// 拡張子のないURLにはContent-Typeの拡張子を付けることを確認します。
+func TestDownloadNoBrowserMedia(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<video controls><source src="/attachment/clip"></video>
+<picture><source srcset="/wide.png"><img src="/narrow.png"></picture>`))
+	})
+	mux.HandleFunc("/attachment/clip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write([]byte("mp4"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, media := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.OutDir = t.TempDir()
+		opts.NoBrowser = true
+		opts.RespectRobots = false
+		opts.Extract.Media = media
+		d, err := New(opts)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		results, err := d.Download(context.Background(), srv.URL+"/page")
+		d.Close()
+		if err != nil {
+			t.Fatalf("Download: %v", err)
+		}
+		var files []string
+		for _, r := range results {
+			if !r.Success {
+				t.Errorf("失敗しました: %+v", r)
+			}
+			files = append(files, r.File)
+		}
+		want := []string{"narrow.png"}
+		if media {
+			want = append(want, "clip.mp4", "wide.png")
+		}
+		if !reflect.DeepEqual(files, want) {
+			t.Errorf("Media = %v: ファイル = %v, want %v", media, files, want)
+		}
+	}
+}
+
+// TestImageSourceJSONはextractImagesJSが返す値（naturalWidth/naturalHeightを含む）をImageSourceに読み込み、
+// 大きさで絞り込めることを確認します。
+func TestImageSourceJSON(t *testing.T) {
+	const evaluated = `[
+		{"src": "/pixel.gif", "srcset": "", "link": "", "width": 1, "height": 1},
+		{"src": "/photo.png", "srcset": "", "link": "/full.png", "width": 800, "height": 600},
+		{"src": "/lazy.png", "srcset": "", "link": "", "width": 0, "height": 0}
+	]`
+	var images []ImageSource
+	if err := json.Unmarshal([]byte(evaluated), &images); err != nil {
+		t.Fatal(err)
+	}
+	if images[1] != (ImageSource{Src: "/photo.png", Link: "/full.png", Width: 800, Height: 600}) {
+		t.Errorf("images[1] = %+v", images[1])
+	}
+
+	base, _ := url.Parse("https://growi.example.com/page")
+	d := newTestDownloader(Options{SizeFilter: SizeFilter{MinWidth: 16, MinHeight: 16}})
+	want := []string{"https://growi.example.com/photo.png", "https://growi.example.com/lazy.png"}
+	if got := jobURLs(d.buildJobs(context.Background(), http.DefaultClient, base, "", images)); !reflect.DeepEqual(got, want) {
+		t.Errorf("URL = %v, want %v", got, want)
+	}
+
+	// Strictの場合は大きさが分からない画像も除外する
+	d = newTestDownloader(Options{SizeFilter: SizeFilter{MinWidth: 16, MinHeight: 16, Strict: true}})
+	want = want[:1]
+	if got := jobURLs(d.buildJobs(context.Background(), http.DefaultClient, base, "", images)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Strict: URL = %v, want %v", got, want)
+	}
+}
+
+// TestExtractImagesJSはセレクタと属性を、引用符を含んでもJavaScriptの文字列リテラルとして埋め込むことを確認します。
+func TestExtractImagesJS(t *testing.T) {
+	js := extractImagesJS(`a[title="it's \ here"]`, "href")
+	if !strings.Contains(js, `document.querySelectorAll("a[title=\"it's \\ here\"]")`) {
+		t.Errorf("セレクタが正しく埋め込まれていません: %s", js)
+	}
+	if !strings.Contains(js, `el.getAttribute("href")`) || strings.Contains(js, "data-src") {
+		t.Errorf("属性が正しく埋め込まれていません: %s", js)
+	}
+	// 既定のimg[src]では遅延読み込みの属性も見る
+	if js := extractImagesJS(DefaultSelector, DefaultAttr); !strings.Contains(js, `el.getAttribute("data-src")`) {
+		t.Errorf("data-srcを見ていません: %s", js)
+	}
+}
+
+// TestAuthRequestPatternsはBasic認証だけの場合はページのホストのリクエストだけを一時停止させ、
+// プロキシ認証がある場合は全てのリクエストを一時停止させることを確認します。
+func TestAuthRequestPatterns(t *testing.T) {
+	got := authRequestPatterns("https://growi.example.com:8443/Docs/page?x=1", nil)
+	want := []*fetch.RequestPattern{{URLPattern: "https://growi.example.com:8443/*", RequestStage: fetch.RequestStageRequest}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Basic認証のみ: %+v, want %+v", got, want)
+	}
+	if got := authRequestPatterns("https://growi.example.com/page", url.UserPassword("proxy", "secret")); got != nil {
+		t.Errorf("プロキシ認証: %+v, want nil（全てのリクエスト）", got)
+	}
+}
@@ -0,0 +1,214 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// contentDispositionFilenameはContent-Dispositionヘッダからファイル名を取得します。
+// RFC 5987形式のfilename*パラメータはmime.ParseMediaTypeがデコードし、filenameより優先されます。
+// パス区切り文字を含む場合は最後の要素のみを返し、取得できなければ空文字列を返します。
+func contentDispositionFilename(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// errUnsafePathは保存先のファイル名が保存先ディレクトリの外を指していることを表します。
+var errUnsafePath = errors.New("保存先がディレクトリの外を指しています")
+
+// checkFileNameはdirに保存するファイル名nameが、dirの外を指していないことを確認します。
+// URLとContent-Dispositionのどちらから決まった名前でも、保存の直前に確認します。
+// Windowsで区切り文字として扱われる"\"も区切り文字とみなし、".."の要素と"C:"のようなドライブ名で始まる名前を拒否します。
+func checkFileName(dir, name string) error {
+	for _, elem := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return fmt.Errorf("%w: %s", errUnsafePath, name)
+		}
+	}
+	if filepath.VolumeName(name) != "" || len(name) >= 2 && name[1] == ':' {
+		return fmt.Errorf("%w: %s", errUnsafePath, name)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Join(dir, name))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s", errUnsafePath, name)
+	}
+	return nil
+}
+
+// maxFilenameBytesはファイル名の最大長（バイト数）です。多くのファイルシステムの上限255バイトに余裕を持たせています。
+const maxFilenameBytes = 200
+
+// windowsReservedNamesはWindowsでファイル名として使えない予約名です（拡張子付きも不可）。
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFilenameはファイル名をどのOSでも安全に保存できる形に整えます。
+// URLエスケープを解除し、クエリ文字列の残りを取り除き、予約文字と制御文字を"_"に置き換えます。
+// Windowsの予約名には"_"を前置し、拡張子を保ったままmaxFilenameBytes以内に切り詰めます。
+func sanitizeFilename(name string) string {
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// Windowsでは末尾の空白とピリオドが取り除かれてしまうため、あらかじめ削る
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" {
+		return ""
+	}
+
+	// Windowsは最初の"."より前が予約名なら拒否する（"NUL.tar.gz"も使えない）
+	stem, _, _ := strings.Cut(name, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = "_" + name
+	}
+	return truncateFilename(name, maxFilenameBytes)
+}
+
+// truncateFilenameはnameを拡張子を保ったままmaxBytes以内に切り詰めます。
+// UTF-8の文字の途中では切りません。
+func truncateFilename(name string, maxBytes int) string {
+	if len(name) <= maxBytes {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) >= maxBytes {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	n := maxBytes - len(ext)
+	for n > 0 && !utf8.RuneStart(stem[n]) {
+		n--
+	}
+	return stem[:n] + ext
+}
+
+// isDataURIはsrcがdata: URIかどうかを返します。
+func isDataURI(src string) bool {
+	return len(src) >= 5 && strings.EqualFold(src[:5], "data:")
+}
+
+// decodeDataURIはdata: URIを解析し、MIMEタイプとデコードしたデータを返します。
+// MIMEタイプが省略されている場合はRFC 2397に従い"text/plain"とみなします。
+func decodeDataURI(uri string) (string, []byte, error) {
+	header, payload, ok := strings.Cut(uri[len("data:"):], ",")
+	if !ok {
+		return "", nil, errors.New("data URIに','がありません")
+	}
+
+	isBase64 := false
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		header = header[:len(header)-len(";base64")]
+		isBase64 = true
+	}
+	mediaType := "text/plain"
+	if header != "" {
+		mt, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return "", nil, fmt.Errorf("MIMEタイプのパースに失敗: %w", err)
+		}
+		mediaType = mt
+	}
+
+	if !isBase64 {
+		data, err := url.PathUnescape(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		return mediaType, []byte(data), nil
+	}
+	// 改行などの空白を含む場合やパディングが省略されている場合も受け付ける
+	payload = strings.Join(strings.Fields(payload), "")
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		if err != nil {
+			return "", nil, fmt.Errorf("base64のデコードに失敗: %w", err)
+		}
+	}
+	return mediaType, data, nil
+}
+
+// preferredExtensionsはMIMEタイプごとに優先する拡張子です。
+// mime.ExtensionsByTypeは複数の候補（".jpe"や".jfif"など）を返すため、一般的なものを固定します。
+var preferredExtensions = map[string]string{
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/svg+xml":            ".svg",
+	"image/bmp":                ".bmp",
+	"image/avif":               ".avif",
+	"image/tiff":               ".tiff",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"video/mp4":                ".mp4",
+	"video/webm":               ".webm",
+	"video/ogg":                ".ogv",
+	"video/quicktime":          ".mov",
+	"audio/mpeg":               ".mp3",
+	"audio/mp4":                ".m4a",
+	"audio/ogg":                ".ogg",
+	"audio/wav":                ".wav",
+	"audio/webm":               ".webm",
+	"audio/flac":               ".flac",
+	"application/pdf":          ".pdf",
+	"text/plain":               ".txt",
+	"text/html":                ".html",
+}
+
+// extensionForMIMEはMIMEタイプに対応する拡張子を返し、不明な場合は".bin"を返します。
+func extensionForMIME(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// GetFileExtensionはURLパスから拡張子を取得し、なければContent-Typeから拡張子を決めます。
+// Content-Typeが不明な場合は".bin"を返します。
+func GetFileExtension(path, contentType string) string {
+	if ext := filepath.Ext(path); ext != "" {
+		return ext
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ".bin"
+	}
+	return extensionForMIME(mediaType)
+}
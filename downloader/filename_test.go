@@ -0,0 +1,273 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// onePixelPNGは1×1ピクセルのPNG画像のbase64です。
+const onePixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+// TestSaveDataURIはbase64のPNGのdata: URIをデコードし、.pngの拡張子で保存することを確認します。
+func TestSaveDataURI(t *testing.T) {
+	opts := DownloadOptions{OutDir: t.TempDir()}
+	result, err := saveDataURI("data:image/png;base64,"+onePixelPNG, "image_3", opts)
+	if err != nil {
+		t.Fatalf("saveDataURI: %v", err)
+	}
+	if result.FileName != "image_3.png" || result.ContentType != "image/png" {
+		t.Errorf("result = %+v", result)
+	}
+	data := readFile(t, filepath.Join(opts.OutDir, "image_3.png"))
+	if int64(len(data)) != result.Size {
+		t.Errorf("Size = %d, ファイルは%dバイト", result.Size, len(data))
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatalf("PNGとしてデコードできません: %v", err)
+	}
+	if cfg.Width != 1 || cfg.Height != 1 {
+		t.Errorf("大きさ = %dx%d, want 1x1", cfg.Width, cfg.Height)
+	}
+}
+
+// TestSaveDataURIRerunは既に同じファイルがある場合、Overwriteでなければ書き込まずにスキップし、
+// Overwriteなら上書きすることを確認します。
+func TestSaveDataURIRerun(t *testing.T) {
+	const uri = "data:text/plain;base64,aGVsbG8="
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "image_1.txt")
+	if err := os.WriteFile(filePath, []byte("HELLO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := saveDataURI(uri, "image_1", DownloadOptions{OutDir: dir, Names: NewNameRegistry()})
+	if err != nil {
+		t.Fatalf("saveDataURI: %v", err)
+	}
+	if !result.Skipped || result.FileName != "image_1.txt" {
+		t.Errorf("result = %+v, want image_1.txtのスキップ", result)
+	}
+	if got := readFile(t, filePath); got != "HELLO" {
+		t.Errorf("既存のファイルの内容 = %q, want 書き換えない", got)
+	}
+
+	result, err = saveDataURI(uri, "image_1", DownloadOptions{OutDir: dir, Names: NewNameRegistry(), Overwrite: true})
+	if err != nil {
+		t.Fatalf("saveDataURI: %v", err)
+	}
+	if result.Skipped || readFile(t, filePath) != "hello" {
+		t.Errorf("Overwrite: result = %+v, 内容 = %q, want 上書き", result, readFile(t, filePath))
+	}
+}
+
+// TestSaveDataURICollisionは同じ実行で別のURL（別のページのdata: URIやHTTPでダウンロードしたファイル）に使った名前には
+// 番号を付けて保存し、先に保存したファイルを上書きしないことを確認します。
+func TestSaveDataURICollision(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("http"))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Names: NewNameRegistry()}
+	if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/image_1.txt", "image_1.txt", opts); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	var got []string
+	for _, uri := range []string{"data:text/plain;base64,aGVsbG8=", "data:text/plain,page2"} {
+		result, err := saveDataURI(uri, "image_1", opts)
+		if err != nil {
+			t.Fatalf("saveDataURI: %v", err)
+		}
+		got = append(got, result.FileName)
+	}
+	if want := []string{"image_1 (1).txt", "image_1 (2).txt"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("FileName = %q, want %q", got, want)
+	}
+	for name, want := range map[string]string{"image_1.txt": "http", "image_1 (1).txt": "hello", "image_1 (2).txt": "page2"} {
+		if got := readFile(t, filepath.Join(opts.OutDir, name)); got != want {
+			t.Errorf("%s の内容 = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestDecodeDataURIはbase64とURLエンコードのdata: URIをデコードできることを確認します。
+func TestDecodeDataURI(t *testing.T) {
+	tests := []struct {
+		uri       string
+		mediaType string
+		data      string
+	}{
+		{"data:text/plain;base64,aGVsbG8=", "text/plain", "hello"},
+		{"data:image/svg+xml;charset=utf-8,%3Csvg%2F%3E", "image/svg+xml", "<svg/>"},
+		{"data:,a%20b", "text/plain", "a b"},
+		{"DATA:image/gif;BASE64,R0lG\nODlh", "image/gif", "GIF89a"},
+		{"data:text/plain;base64,aGVsbG8", "text/plain", "hello"},
+	}
+	for _, tt := range tests {
+		mediaType, data, err := decodeDataURI(tt.uri)
+		if err != nil {
+			t.Errorf("decodeDataURI(%q): %v", tt.uri, err)
+			continue
+		}
+		if mediaType != tt.mediaType || string(data) != tt.data {
+			t.Errorf("decodeDataURI(%q) = %q, %q, want %q, %q", tt.uri, mediaType, data, tt.mediaType, tt.data)
+		}
+	}
+	for _, uri := range []string{"data:image/png;base64", "data:image/png;base64,!!!"} {
+		if _, _, err := decodeDataURI(uri); err == nil {
+			t.Errorf("decodeDataURI(%q): エラーになりませんでした", uri)
+		}
+	}
+}
+
+// TestGetFileExtensionはURLの拡張子がない場合にContent-Typeから拡張子を決め、不明なら.binにすることを確認します。
+func TestGetFileExtension(t *testing.T) {
+	tests := []struct {
+		path        string
+		contentType string
+		want        string
+	}{
+		{"/attachment/649abc", "image/png", ".png"},
+		{"/attachment/649abc", "image/gif", ".gif"},
+		{"/attachment/649abc", "application/pdf", ".pdf"},
+		{"/attachment/649abc", "image/jpeg; charset=binary", ".jpg"},
+		{"/attachment/649abc", "application/x-unknown-type", ".bin"},
+		{"/attachment/649abc", "", ".bin"},
+		{"/files/photo.jpeg", "image/png", ".jpeg"},
+	}
+	for _, tt := range tests {
+		if got := GetFileExtension(tt.path, tt.contentType); got != tt.want {
+			t.Errorf("GetFileExtension(%q, %q) = %q, want %q", tt.path, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+// TestDownloadFileContentTypeExtensionはURLに拡張子がない場合に、Content-Typeの拡張子を付けて保存することを確認します。
+func TestDownloadFileContentTypeExtension(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		w.Write([]byte("gif"))
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir()}
+	result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/attachment/649abc", "649abc", opts)
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if result.FileName != "649abc.gif" {
+		t.Errorf("FileName = %q, want %q", result.FileName, "649abc.gif")
+	}
+	readFile(t, filepath.Join(opts.OutDir, "649abc.gif"))
+}
+
+// TestSanitizeFilenameはWindowsの予約名、クエリ文字列、予約文字と制御文字、末尾の空白とピリオドの扱いを確認します。
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"image.png", "image.png"},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"COM1.tar.gz", "_COM1.tar.gz"},
+		{"aux .png", "_aux .png"},
+		{"CONSOLE.png", "CONSOLE.png"},
+		{"photo.jpg?width=800&v=2", "photo.jpg"},
+		{"photo.jpg#top", "photo.jpg"},
+		{"%E8%A8%AD%E8%A8%88.png", "設計.png"},
+		{`a<b>c:d"e|f*g.png`, "a_b_c_d_e_f_g.png"},
+		{"a%2Fb%5Cc.png", "a_b_c.png"},
+		{"tab\there\x7f.png", "tab_here_.png"},
+		{"  name.png. . ", "name.png"},
+		{"...", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.name); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSanitizeFilenameTruncateは長い名前を拡張子を保ったまま切り詰め、UTF-8の文字の途中で切らないことを確認します。
+func TestSanitizeFilenameTruncate(t *testing.T) {
+	// "あ"は3バイトのため、200バイトの境界が文字の途中になる
+	name := strings.Repeat("あ", 100) + ".png"
+	got := sanitizeFilename(name)
+	if len(got) > maxFilenameBytes {
+		t.Errorf("長さ = %dバイト, 上限 %dバイト", len(got), maxFilenameBytes)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("UTF-8として不正です: %q", got)
+	}
+	if want := strings.Repeat("あ", 65) + ".png"; got != want {
+		t.Errorf("sanitizeFilename = %q, want %q", got, want)
+	}
+
+	// 拡張子が長すぎる場合は拡張子を残さずに切り詰める
+	long := "a." + strings.Repeat("b", 300)
+	if got := sanitizeFilename(long); len(got) != maxFilenameBytes {
+		t.Errorf("長さ = %dバイト, want %d", len(got), maxFilenameBytes)
+	}
+}
+
+// TestCheckFileNameは"/"と"\"のどちらの区切り文字でも、保存先ディレクトリの外を指すファイル名を拒否することを確認します。
+func TestCheckFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"a.png", false},
+		{"attachment/page/a.png", false},
+		{`attachment\page\a.png`, false},
+		{"..a.png", false},
+		{"a..png", false},
+		{"../a.png", true},
+		{`..\a.png`, true},
+		{"attachment/../../a.png", true},
+		{`attachment\..\..\a.png`, true},
+		{"attachment/../a.png", true},
+		{"..", true},
+		{`C:\Windows\a.png`, true},
+		{"C:a.png", true},
+	}
+	for _, dir := range []string{"out", ""} {
+		for _, tt := range tests {
+			err := checkFileName(dir, tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkFileName(%q, %q) = %v, wantErr %v", dir, tt.name, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errUnsafePath) {
+				t.Errorf("checkFileName(%q, %q) = %v, want errUnsafePath", dir, tt.name, err)
+			}
+		}
+	}
+}
+
+// TestDownloadFileUnsafePathは保存先ディレクトリの外を指すファイル名の場合にリクエストを送らず、ファイルを作成しないことを確認します。
+func TestDownloadFileUnsafePath(t *testing.T) {
+	srv, requests := countingServer(t, "", func(string) string { return "evil" })
+	dir := t.TempDir()
+	opts := DownloadOptions{OutDir: filepath.Join(dir, "out")}
+	for _, name := range []string{"../evil.png", `..\evil.png`, "sub/../../evil.png"} {
+		if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/evil.png", name, opts); !errors.Is(err, errUnsafePath) {
+			t.Errorf("%s: err = %v, want errUnsafePath", name, err)
+		}
+	}
+	if requests.Load() != 0 {
+		t.Errorf("リクエストを送っています: %d", requests.Load())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.png")); err == nil {
+		t.Error("保存先ディレクトリの外にファイルが作成されました")
+	}
+}
@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ExtFilterは拡張子によるダウンロード対象の絞り込みの設定です。
+// 拡張子は大文字・小文字を区別せず、先頭の"."の有無も問いません（"png"と".PNG"は同じ）。
+type ExtFilter struct {
+	Include []string // 指定した場合、これらの拡張子のみをダウンロードする
+	Exclude []string // これらの拡張子はダウンロードしない
+}
+
+// Allowは拡張子extのファイルをダウンロードの対象にするかどうかを返します。
+// IncludeとExcludeの両方にある拡張子はIncludeを優先して対象にします。
+// Includeが空でなければ、Includeにない拡張子（拡張子なしを含む）は対象外です。
+func (f ExtFilter) Allow(ext string) bool {
+	ext = normalizeExt(ext)
+	if containsExt(f.Include, ext) {
+		return true
+	}
+	if len(f.Include) > 0 {
+		return false
+	}
+	return !containsExt(f.Exclude, ext)
+}
+
+// SizeFilterは画像の大きさ（naturalWidth/naturalHeight）によるダウンロード対象の絞り込みの設定です。
+// 1×1のトラッキング用画像や小さなアイコンを除外するために使います。
+type SizeFilter struct {
+	MinWidth  int  // この幅（ピクセル）未満の画像はダウンロードしない（0は制限なし）
+	MinHeight int  // この高さ（ピクセル）未満の画像はダウンロードしない（0は制限なし）
+	Strict    bool // 大きさが分からない（読み込まれていない）画像もダウンロードしない
+}
+
+// Allowは幅width・高さheightの画像をダウンロードの対象にするかどうかを返します。
+// widthかheightが0の場合は大きさが分からないものとして、Strictでなければ対象にします。
+func (f SizeFilter) Allow(width, height int) bool {
+	if f.MinWidth <= 0 && f.MinHeight <= 0 {
+		return true
+	}
+	if width <= 0 || height <= 0 {
+		return !f.Strict
+	}
+	return width >= f.MinWidth && height >= f.MinHeight
+}
+
+// URLFilterは正規表現による画像のURLの絞り込みの設定です。
+type URLFilter struct {
+	Match   *regexp.Regexp // 指定した場合、これに一致するURLのみをダウンロードする
+	Exclude *regexp.Regexp // これに一致するURLはダウンロードしない
+}
+
+// AllowはURLがuの画像をダウンロードの対象にするかどうかを返します。
+// MatchとExcludeの両方に一致する場合は対象外です。
+func (f URLFilter) Allow(u string) bool {
+	if f.Match != nil && !f.Match.MatchString(u) {
+		return false
+	}
+	return f.Exclude == nil || !f.Exclude.MatchString(u)
+}
+
+// normalizeExtは拡張子を比較用に小文字にし、先頭の"."を取り除きます。
+func normalizeExt(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
+
+// containsExtはextsにextが含まれるかどうかを返します。
+func containsExt(exts []string, ext string) bool {
+	for _, e := range exts {
+		if normalizeExt(e) == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"regexp"
+	"testing"
+)
+
+// TestExtFilterは拡張子の絞り込みのIncludeのみ、Excludeのみ、両方に同じ拡張子がある場合を確認します。
+func TestExtFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ExtFilter
+		allow  []string
+		deny   []string
+	}{
+		{"指定なし", ExtFilter{}, []string{".png", ".svg", ""}, nil},
+		{"Includeのみ", ExtFilter{Include: []string{"png", ".JPG"}}, []string{".png", ".PNG", "jpg", ".jpg"}, []string{".svg", ".gif", ""}},
+		{"Excludeのみ", ExtFilter{Exclude: []string{"svg", " gif "}}, []string{".png", ""}, []string{".svg", ".SVG", ".gif"}},
+		{"両方に指定", ExtFilter{Include: []string{"png", "svg"}, Exclude: []string{"svg", "gif"}}, []string{".png", ".svg"}, []string{".gif", ".jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, ext := range tt.allow {
+				if !tt.filter.Allow(ext) {
+					t.Errorf("Allow(%q) = false, want true", ext)
+				}
+			}
+			for _, ext := range tt.deny {
+				if tt.filter.Allow(ext) {
+					t.Errorf("Allow(%q) = true, want false", ext)
+				}
+			}
+		})
+	}
+}
+
+// TestSizeFilterは最小の幅と高さによる絞り込みと、大きさが分からない画像の扱いを確認します。
+func TestSizeFilter(t *testing.T) {
+	tests := []struct {
+		filter        SizeFilter
+		width, height int
+		want          bool
+	}{
+		{SizeFilter{}, 1, 1, true},
+		{SizeFilter{MinWidth: 10, MinHeight: 10}, 1, 1, false},
+		{SizeFilter{MinWidth: 10, MinHeight: 10}, 10, 10, true},
+		{SizeFilter{MinWidth: 10}, 9, 500, false},
+		{SizeFilter{MinHeight: 10}, 500, 9, false},
+		{SizeFilter{MinWidth: 10, MinHeight: 10}, 0, 0, true},
+		{SizeFilter{MinWidth: 10, MinHeight: 10, Strict: true}, 0, 0, false},
+		{SizeFilter{Strict: true}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Allow(tt.width, tt.height); got != tt.want {
+			t.Errorf("%+v.Allow(%d, %d) = %v, want %v", tt.filter, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+// TestURLFilterは正規表現に一致するURLのみを対象にし、除外の正規表現に一致するURLを除くことを確認します。
+func TestURLFilter(t *testing.T) {
+	filter := URLFilter{
+		Match:   regexp.MustCompile(`/attachment/`),
+		Exclude: regexp.MustCompile(`\.svg$`),
+	}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://growi.example.com/attachment/649abc", true},
+		{"https://growi.example.com/attachment/icon.svg", false},
+		{"https://cdn.example.com/logo.png", false},
+	}
+	for _, tt := range tests {
+		if got := filter.Allow(tt.url); got != tt.want {
+			t.Errorf("Allow(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+	if !(URLFilter{}).Allow("https://cdn.example.com/logo.png") {
+		t.Error("指定がない場合に除外しています")
+	}
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"bytes"
+	"html/template"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// galleryFileNameはWriteGalleryが出力するファイルの名前です。
+const galleryFileName = "index.html"
+
+// galleryTemplateはWriteGalleryが出力するHTMLのテンプレートです。
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if eq (len .Pages) 1}}{{index .Pages 0}}{{else}}{{len .Pages}} pages{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 1rem; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(200px, 1fr)); gap: 1rem; }
+figure { margin: 0; }
+figure img { width: 100%; height: 200px; object-fit: contain; background: #f4f4f4; }
+figcaption { font-size: 0.8rem; overflow-wrap: anywhere; }
+</style>
+</head>
+<body>
+{{range .Pages}}<p><a href="{{.}}">{{.}}</a></p>
+{{end}}<div class="grid">
+{{range .Images}}<figure>
+<a href="{{.Path}}"><img src="{{.Path}}" alt="{{.File}}" loading="lazy"></a>
+<figcaption>{{.File}}<br>{{if .Linkable}}<a href="{{.URL}}">{{.URL}}</a>{{else}}{{.URL}}{{end}}</figcaption>
+</figure>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// isImageFileはファイル名の拡張子が画像の種類かどうかを返します。
+func isImageFile(name string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(path.Ext(name)), "image/")
+}
+
+// galleryImageはギャラリーに表示する画像1件です。
+type galleryImage struct {
+	Path string // index.htmlからの相対URL
+	File string
+	URL  string
+	// Linkableはダウンロード元のURLにリンクできるかどうかです（data: URIはヘッダ部分しか残っていないためリンクしない）。
+	Linkable bool
+}
+
+// WriteGalleryはresultsのうちダウンロードに成功した画像（既に存在したためスキップしたものを含む）を一覧にした
+// index.htmlをOutDirに書き込みます。保存したファイルを相対パスで参照するため、オフラインでもブラウザで確認できます。
+func (d *Downloader) WriteGallery(results []Result) error {
+	var data struct {
+		Pages  []string
+		Images []galleryImage
+	}
+	seenPages := make(map[string]bool)
+	for _, r := range results {
+		if !seenPages[r.Page] {
+			seenPages[r.Page] = true
+			data.Pages = append(data.Pages, r.Page)
+		}
+		if !r.Success || r.Filtered || r.File == "" || !isImageFile(r.File) {
+			continue
+		}
+		// ファイル名の"#"や"?"、空白をエスケープし、":"を含む名前がスキームとみなされないようにする
+		ref := (&url.URL{Path: filepath.ToSlash(d.savedPath(r))}).String()
+		data.Images = append(data.Images, galleryImage{Path: ref, File: r.File, URL: r.URL, Linkable: !isDataURI(r.URL)})
+	}
+
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	_, err := writeFileAtomic(filepath.Join(d.opts.OutDir, galleryFileName), &buf)
+	return err
+}
@@ -0,0 +1,46 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWriteGalleryは生成したindex.htmlが保存した全ての画像を参照し、画像以外と失敗したファイルを含まないことを確認します。
+func TestWriteGallery(t *testing.T) {
+	for _, perPageDir := range []bool{false, true} {
+		d := &Downloader{opts: Options{OutDir: t.TempDir(), PerPageDir: perPageDir}}
+		page := "https://growi.example.com/Docs/設計"
+		results := []Result{
+			{Page: page, URL: "https://growi.example.com/attachment/1", File: "image.png", Success: true},
+			{Page: page, URL: "https://growi.example.com/attachment/2", File: "image (1).png", Success: true, Skipped: true},
+			{Page: page, URL: "https://growi.example.com/a#b.jpg", File: "a#b.jpg", Success: true},
+			{Page: page, URL: "data:image/png;base64", File: "inline.png", Success: true},
+			{Page: page, URL: "https://growi.example.com/doc.pdf", File: "doc.pdf", Success: true},
+			{Page: page, URL: "https://growi.example.com/missing.png", File: "missing.png", Error: "404"},
+			{Page: page, URL: "https://growi.example.com/icon.svg", File: "icon.svg", Success: true, Skipped: true, Filtered: true},
+		}
+		if err := d.WriteGallery(results); err != nil {
+			t.Fatalf("WriteGallery: %v", err)
+		}
+		html := readFile(t, filepath.Join(d.opts.OutDir, "index.html"))
+
+		prefix := ""
+		if perPageDir {
+			prefix = "Docs_%E8%A8%AD%E8%A8%88/" // "Docs_設計/"
+		}
+		for _, want := range []string{"image.png", "image%20%281%29.png", "a%23b.jpg", "inline.png"} {
+			if !strings.Contains(html, `src="`+prefix+want+`"`) {
+				t.Errorf("perPageDir=%v: %sを参照していません:\n%s", perPageDir, prefix+want, html)
+			}
+		}
+		for _, unwanted := range []string{"doc.pdf", "missing.png", "icon.svg"} {
+			if strings.Contains(html, unwanted) {
+				t.Errorf("perPageDir=%v: 対象外の%sを含んでいます", perPageDir, unwanted)
+			}
+		}
+		if !strings.Contains(html, `<a href="https://growi.example.com/attachment/1">`) {
+			t.Errorf("perPageDir=%v: ダウンロード元のURLへのリンクがありません", perPageDir)
+		}
+	}
+}
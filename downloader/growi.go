@@ -0,0 +1,155 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+// GROWIAttachmentはGROWIのAPI（/_api/v3/attachment/list）が返す添付ファイル1件の情報です。
+type GROWIAttachment struct {
+	ID                  string `json:"_id"`
+	OriginalName        string `json:"originalName"`        // アップロード時のファイル名
+	FileFormat          string `json:"fileFormat"`          // MIMEタイプ
+	FileSize            int64  `json:"fileSize"`            // バイト数
+	FilePathProxied     string `json:"filePathProxied"`     // 表示用のパス（/attachment/<id>）
+	DownloadPathProxied string `json:"downloadPathProxied"` // ダウンロード用のパス（/download/<id>）
+}
+
+// growiAttachmentListは/_api/v3/attachment/listのレスポンスです。
+type growiAttachmentList struct {
+	PaginateResult struct {
+		Docs        []GROWIAttachment `json:"docs"`
+		HasNextPage bool              `json:"hasNextPage"`
+		NextPage    int               `json:"nextPage"`
+	} `json:"paginateResult"`
+}
+
+// growiPageは/_api/v3/pageのレスポンスです。
+type growiPage struct {
+	Page struct {
+		ID string `json:"_id"`
+	} `json:"page"`
+}
+
+// growiPageIDPatternはGROWIのパーマリンク（/<ページID>）のパスです。
+var growiPageIDPattern = regexp.MustCompile(`^/([0-9a-f]{24})$`)
+
+// growiAttachmentPageLimitは添付ファイルの一覧を1回のAPI呼び出しで取得する件数です。
+const growiAttachmentPageLimit = 100
+
+// ListGROWIAttachmentsはpageURLのGROWIのページの添付ファイルをAPIで取得します。
+// GROWIはpageURLと同じホストのルートで動いているものとし、tokenが空でなければAPIトークンとしてAuthorizationヘッダで送ります
+// （クエリ文字列に入れるとプロキシやサーバーのアクセスログに残るため）。
+// pageURLがパーマリンクでない場合は、ページのパスからページIDを調べます。
+func ListGROWIAttachments(ctx context.Context, client *http.Client, pageURL *url.URL, token string, opts DownloadOptions) ([]GROWIAttachment, error) {
+	client = withGROWIToken(client, pageURL.Host, token)
+	pageID, err := growiPageID(ctx, client, pageURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []GROWIAttachment
+	for pageNumber := 1; ; {
+		query := url.Values{
+			"pageId":     {pageID},
+			"pageNumber": {strconv.Itoa(pageNumber)},
+			"limit":      {strconv.Itoa(growiAttachmentPageLimit)},
+		}
+		var list growiAttachmentList
+		if err := getGROWIAPI(ctx, client, growiAPIURL(pageURL, "/_api/v3/attachment/list", query), &list, opts); err != nil {
+			return nil, fmt.Errorf("添付ファイルの一覧の取得に失敗: %w", err)
+		}
+		attachments = append(attachments, list.PaginateResult.Docs...)
+		if !list.PaginateResult.HasNextPage || list.PaginateResult.NextPage <= pageNumber {
+			return attachments, nil
+		}
+		pageNumber = list.PaginateResult.NextPage
+	}
+}
+
+// growiPageIDはpageURLのGROWIのページIDを返します。
+func growiPageID(ctx context.Context, client *http.Client, pageURL *url.URL, opts DownloadOptions) (string, error) {
+	if m := growiPageIDPattern.FindStringSubmatch(pageURL.Path); m != nil {
+		return m[1], nil
+	}
+	var page growiPage
+	query := url.Values{"path": {pageURL.Path}}
+	if err := getGROWIAPI(ctx, client, growiAPIURL(pageURL, "/_api/v3/page", query), &page, opts); err != nil {
+		return "", fmt.Errorf("ページ情報の取得に失敗: %w", err)
+	}
+	if page.Page.ID == "" {
+		return "", fmt.Errorf("ページが見つかりません: %s", pageURL.Path)
+	}
+	return page.Page.ID, nil
+}
+
+// growiAPIURLはpageURLと同じホストのGROWIのAPIのURLを作成します。
+func growiAPIURL(pageURL *url.URL, apiPath string, query url.Values) string {
+	u := url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host, Path: apiPath, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// getGROWIAPIはGROWIのAPIを呼び出し、JSONのレスポンスをvにデコードします。
+func getGROWIAPI(ctx context.Context, client *http.Client, apiURL string, v any, opts DownloadOptions) error {
+	slog.Debug("GROWIのAPIを呼び出します", "url", apiURL)
+	resp, err := getWithRetry(ctx, client, apiURL, nil, opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTPステータスがOKではありません: %s: %s", apiURL, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("レスポンスのデコードに失敗: %s: %w", apiURL, err)
+	}
+	return nil
+}
+
+// attachmentURLはGROWIの添付ファイルをダウンロードするURLを返します。
+func (a GROWIAttachment) attachmentURL(pageURL *url.URL) string {
+	p := a.FilePathProxied
+	if p == "" {
+		p = path.Join("/attachment", a.ID)
+	}
+	u := url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host}
+	return u.ResolveReference(&url.URL{Path: p}).String()
+}
+
+// withGROWITokenはGROWIのホストhostへのリクエストにAPIトークンtokenを付けるHTTPクライアントを返します。
+// tokenが空の場合と、既に同じトークンを付けるクライアントの場合はclientをそのまま返します。
+func withGROWIToken(client *http.Client, host, token string) *http.Client {
+	if t, ok := client.Transport.(*growiTokenTransport); token == "" || ok && t.host == host && t.token == token {
+		return client
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &growiTokenTransport{base: transport, host: host, token: token}
+	return &c
+}
+
+// growiTokenTransportはGROWIのホストへのリクエストにAPIトークンをAuthorizationヘッダとして付けるRoundTripperです。
+type growiTokenTransport struct {
+	base  http.RoundTripper
+	host  string
+	token string
+}
+
+// RoundTripはreqのホストがGROWIのホストの場合にAuthorizationヘッダを付けて送信します。
+func (t *growiTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// growiTestTokenはテスト用のGROWIのAPIトークンです。
+const growiTestToken = "test-api-token"
+
+// newGROWIServerは記録したGROWIのAPIのレスポンス（testdata）を返すテスト用のGROWIサーバーを起動します。
+// APIトークンがAuthorizationヘッダにないリクエストと、クエリ文字列にトークンを含むリクエストは拒否します。
+func newGROWIServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_api/v3/page", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("path") != "/Docs/設計" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"page":{"_id":"65a1efa9e4b0a1b2c3d4e500","path":"/Docs/設計"}}`))
+	})
+	mux.HandleFunc("/_api/v3/attachment/list", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("pageId") != "65a1efa9e4b0a1b2c3d4e500" {
+			http.Error(w, `{"errors":[{"message":"Page not found"}]}`, http.StatusNotFound)
+			return
+		}
+		name := "growi_attachment_list.json"
+		if q.Get("pageNumber") == "2" {
+			name = "growi_attachment_list_2.json"
+		}
+		http.ServeFile(w, r, filepath.Join("testdata", name))
+	})
+	mux.HandleFunc("/attachment/", func(w http.ResponseWriter, r *http.Request) {
+		// GROWIは元のファイル名をContent-Dispositionで返す
+		w.Header().Set("Content-Disposition", `inline; filename="image.png"`)
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/attachment/")))
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("access_token") {
+			http.Error(w, "token in query", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+growiTestToken {
+			http.Error(w, `{"errors":[{"message":"Unauthorized"}]}`, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestGROWIAttachmentJSONは記録したAPIのレスポンスをGROWIAttachmentに読み込めることを確認します。
+func TestGROWIAttachmentJSON(t *testing.T) {
+	srv := newGROWIServer(t)
+	pageURL, _ := url.Parse(srv.URL + "/65a1efa9e4b0a1b2c3d4e500")
+	attachments, err := ListGROWIAttachments(context.Background(), srv.Client(), pageURL, growiTestToken, DownloadOptions{})
+	if err != nil {
+		t.Fatalf("ListGROWIAttachments: %v", err)
+	}
+	want := []GROWIAttachment{
+		{ID: "65a1f0c2e4b0a1b2c3d4e5f1", OriginalName: "image.png", FileFormat: "image/png", FileSize: 10,
+			FilePathProxied: "/attachment/65a1f0c2e4b0a1b2c3d4e5f1", DownloadPathProxied: "/download/65a1f0c2e4b0a1b2c3d4e5f1"},
+		{ID: "65a1f0d8e4b0a1b2c3d4e5f2", OriginalName: "image.png", FileFormat: "image/png", FileSize: 10,
+			FilePathProxied: "/attachment/65a1f0d8e4b0a1b2c3d4e5f2", DownloadPathProxied: "/download/65a1f0d8e4b0a1b2c3d4e5f2"},
+		{ID: "65a1f1a3e4b0a1b2c3d4e5f3", OriginalName: "設計書 v2.xlsx", FileFormat: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", FileSize: 14,
+			FilePathProxied: "/attachment/65a1f1a3e4b0a1b2c3d4e5f3", DownloadPathProxied: "/download/65a1f1a3e4b0a1b2c3d4e5f3"},
+	}
+	if !reflect.DeepEqual(attachments, want) {
+		t.Errorf("attachments = %+v, want %+v", attachments, want)
+	}
+}
+
+// TestListGROWIAttachmentsErrorsはトークンの誤りや存在しないページでエラーになることを確認します。
+func TestListGROWIAttachmentsErrors(t *testing.T) {
+	srv := newGROWIServer(t)
+	for _, tt := range []struct {
+		name  string
+		path  string
+		token string
+	}{
+		{"トークンの誤り", "/65a1efa9e4b0a1b2c3d4e500", "wrong"},
+		{"存在しないページ", "/Docs/none", growiTestToken},
+	} {
+		pageURL, _ := url.Parse(srv.URL + tt.path)
+		if _, err := ListGROWIAttachments(context.Background(), srv.Client(), pageURL, tt.token, DownloadOptions{}); err == nil {
+			t.Errorf("%s: エラーになりませんでした", tt.name)
+		} else if strings.Contains(err.Error(), tt.token) {
+			t.Errorf("%s: エラーにトークンが含まれています: %v", tt.name, err)
+		}
+	}
+}
+
+// TestDownloadAPIはAPIの場合にページのパスから添付ファイルの一覧を取得し、元のファイル名で保存することと、
+// 同じ名前の添付ファイルを添付ファイルのIDで区別することを確認します。
+func TestDownloadAPI(t *testing.T) {
+	srv := newGROWIServer(t)
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.API = true
+	opts.APIToken = growiTestToken
+	opts.HTTPClient = srv.Client()
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+
+	results, err := d.Download(context.Background(), srv.URL+"/Docs/%E8%A8%AD%E8%A8%88")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	want := map[string]string{
+		"image_65a1f0c2e4b0a1b2c3d4e5f1.png": "65a1f0c2e4b0a1b2c3d4e5f1",
+		"image_65a1f0d8e4b0a1b2c3d4e5f2.png": "65a1f0d8e4b0a1b2c3d4e5f2",
+		"設計書 v2.xlsx":                        "65a1f1a3e4b0a1b2c3d4e5f3",
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v", results)
+	}
+	for _, r := range results {
+		if !r.Success || want[r.File] != r.Source {
+			t.Errorf("result = %+v", r)
+		}
+	}
+	for name, id := range want {
+		if got := readFile(t, filepath.Join(opts.OutDir, name)); got != id {
+			t.Errorf("%s の内容 = %q, want %q", name, got, id)
+		}
+	}
+	if entries, _ := os.ReadDir(opts.OutDir); len(entries) != len(want) {
+		t.Errorf("保存したファイルの数 = %d, want %d", len(entries), len(want))
+	}
+}
@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// DefaultPerHostLimitは同じホストから同時にダウンロードする画像の数の既定の上限です。
+const DefaultPerHostLimit = 6
+
+// HostLimiterはホストごとに同時に実行するダウンロードの数を制限します。
+// 全体の同時ダウンロード数（Concurrency）とは別に、1つのサーバーに多くの接続を開かないようにします。
+// 同時に実行する全てのダウンロード（と全てのページ）で共有します。
+type HostLimiter struct {
+	limit int
+	mu    sync.Mutex
+	slots map[string]chan struct{} // ホスト → 実行中のダウンロードの数だけ値が入ったチャネル
+}
+
+// NewHostLimiterはホストごとの同時ダウンロード数をlimitまでに制限するHostLimiterを作成します。
+// limitが0以下の場合はnilを返します（nilのHostLimiterは制限しません）。
+func NewHostLimiter(limit int) *HostLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &HostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquireはurlStrのホストのダウンロードの枠が空くまで待ち、枠を返す関数を返します。
+// ctxがキャンセルされた場合はctx.Err()を返します。
+func (l *HostLimiter) acquire(ctx context.Context, urlStr string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	host := urlStr
+	if u, err := url.Parse(urlStr); err == nil {
+		host = u.Host
+	}
+	l.mu.Lock()
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[host] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyCounterは同時に処理しているリクエストの数と、その最大値を数えます。
+type concurrencyCounter struct {
+	mu       sync.Mutex
+	inflight int
+	peak     int
+}
+
+func (c *concurrencyCounter) enter() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight++
+	c.peak = max(c.peak, c.inflight)
+}
+
+func (c *concurrencyCounter) leave() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+}
+
+// TestRunDownloadsPerHostLimitは2つのホストの画像を、ホストごとに別々に上限まで同時にダウンロードすることを確認します。
+func TestRunDownloadsPerHostLimit(t *testing.T) {
+	const perHost = 2
+	var total concurrencyCounter
+	var jobs []downloadJob
+	hosts := make([]*concurrencyCounter, 2)
+	for h := range hosts {
+		hosts[h] = new(concurrencyCounter)
+		counter := hosts[h]
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			counter.enter()
+			total.enter()
+			time.Sleep(50 * time.Millisecond)
+			total.leave()
+			counter.leave()
+			w.Write([]byte("png"))
+		}))
+		defer srv.Close()
+		for i := 0; i < 6; i++ {
+			name := fmt.Sprintf("h%d_%d.png", h, i)
+			jobs = append(jobs, downloadJob{source: name, url: srv.URL + "/" + name, fileName: name})
+		}
+	}
+
+	opts := DownloadOptions{OutDir: t.TempDir(), HostLimit: NewHostLimiter(perHost)}
+	results, err := runDownloads(context.Background(), http.DefaultClient, "http://growi.example.com/page", jobs, len(jobs), false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if !r.Success {
+			t.Errorf("%sのダウンロードに失敗しました: %s", r.URL, r.Error)
+		}
+	}
+	for h, counter := range hosts {
+		if counter.peak > perHost {
+			t.Errorf("ホスト%dの同時リクエスト数 = %d, want %d以下", h, counter.peak, perHost)
+		}
+	}
+	// 1つのホストが上限に達していても、別のホストのダウンロードは待たない
+	if total.peak <= perHost {
+		t.Errorf("全体の同時リクエスト数 = %d, want %dより多い", total.peak, perHost)
+	}
+}
+
+// TestHostLimiterCancelは枠が空くのを待っている間にctxがキャンセルされた場合、ctx.Err()を返すことを確認します。
+func TestHostLimiterCancel(t *testing.T) {
+	l := NewHostLimiter(1)
+	release, err := l.acquire(context.Background(), "https://growi.example.com/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	// 別のホストは待たない
+	other, err := l.acquire(context.Background(), "https://cdn.example.com/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "https://growi.example.com/b.png"); err != context.DeadlineExceeded {
+		t.Errorf("acquire = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if NewHostLimiter(0) != nil {
+		t.Error("NewHostLimiter(0)がnilではありません")
+	}
+}
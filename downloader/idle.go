@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// networkIdleはタブで実行中のリクエストを記録し、リクエストのない状態が続くのを待てるようにします。
+// GROWIのページは表示後にXHRで本文や添付ファイルを読み込むため、要素の表示を待つだけでは画像が揃わないことがあります。
+type networkIdle struct {
+	mu       sync.Mutex
+	inflight map[network.RequestID]bool
+	changed  chan struct{} // 実行中のリクエストが変わるたびに閉じて作り直す
+}
+
+// listenNetworkIdleはctxのタブのリクエストの記録を始めます。ページに遷移する前に呼んでください。
+func listenNetworkIdle(ctx context.Context) *networkIdle {
+	n := &networkIdle{inflight: make(map[network.RequestID]bool), changed: make(chan struct{})}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			n.start(ev.RequestID)
+		case *network.EventLoadingFinished:
+			n.finish(ev.RequestID)
+		case *network.EventLoadingFailed:
+			n.finish(ev.RequestID)
+		}
+	})
+	return n
+}
+
+// startはリクエストidを実行中として記録します。
+func (n *networkIdle) start(id network.RequestID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.inflight[id] = true
+	n.notify()
+}
+
+// finishはリクエストidを完了したものとして記録から除きます。
+func (n *networkIdle) finish(id network.RequestID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.inflight[id] {
+		delete(n.inflight, id)
+		n.notify()
+	}
+}
+
+// notifyは待っているwaitに変化を知らせます。n.muをロックして呼んでください。
+func (n *networkIdle) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// waitは実行中のリクエストがない状態がquietの間続くまで待ちます。
+// 先にctxが終わった場合（上限の時間を超えた場合など）はctxのエラーを返します。
+func (n *networkIdle) wait(ctx context.Context, quiet time.Duration) error {
+	for {
+		n.mu.Lock()
+		idle, changed := len(n.inflight) == 0, n.changed
+		n.mu.Unlock()
+
+		if !idle {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-changed:
+			}
+			continue
+		}
+		timer := time.NewTimer(quiet)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// TestNetworkIdleWaitは実行中のリクエストが終わってからquietの間待って戻り、
+// リクエストが終わらない場合はctxの期限で戻ることを確認します。
+func TestNetworkIdleWait(t *testing.T) {
+	n := &networkIdle{inflight: make(map[network.RequestID]bool), changed: make(chan struct{})}
+	n.start("1")
+	n.start("2")
+	finished := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		n.finish("1")
+		time.Sleep(50 * time.Millisecond)
+		finished <- time.Now()
+		n.finish("2")
+	}()
+	const quiet = 100 * time.Millisecond
+	if err := n.wait(context.Background(), quiet); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if elapsed := time.Since(<-finished); elapsed < quiet {
+		t.Errorf("リクエストの完了から%vで戻りました（%v以上待つはず）", elapsed, quiet)
+	}
+
+	n.start("3")
+	// 記録していないリクエストの完了は無視する
+	n.finish("4")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.wait(ctx, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,33 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+// inlineDataURIPatternはHTMLやCSSに埋め込まれたbase64の画像のdata: URIです。
+// style属性のurl(&quot;...&quot;)のように引用符が文字参照になっていても、base64の文字が続く範囲だけを取り出します。
+var inlineDataURIPattern = regexp.MustCompile(`data:image/[A-Za-z0-9.+-]+(?:;[A-Za-z0-9.+-]+=[A-Za-z0-9.+-]+)*;base64,[A-Za-z0-9+/]+=*`)
+
+// findInlineImagesはシリアライズしたDOM（またはHTML）docのstyle属性、srcset属性、data-*属性、styleタグのCSSなどに
+// 埋め込まれたbase64の画像を探し、data: URIのsrcとして返します。外部のURLを持たない図などを保存するために使います。
+// imgタグのsrc属性のものも含みますが、同じ内容の画像はbuildJobsで1つにまとめます。
+func findInlineImages(doc string) []ImageSource {
+	var images []ImageSource
+	for _, uri := range inlineDataURIPattern.FindAllString(doc, -1) {
+		images = append(images, ImageSource{Src: uri})
+	}
+	return images
+}
+
+// dataURIKeyはdata: URIの重複を判定するキー（デコードしたデータのSHA-256）を返します。
+// MIMEタイプのパラメータの違いやbase64とURLエンコードの違いがあっても、同じ画像は同じキーになります。
+func dataURIKey(uri string) string {
+	data := []byte(uri)
+	if _, decoded, err := decodeDataURI(uri); err == nil {
+		data = decoded
+	}
+	sum := sha256.Sum256(data)
+	return "data:" + hex.EncodeToString(sum[:])
+}
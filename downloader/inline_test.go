@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestFindInlineImagesはstyle属性・srcset属性・data-*属性・styleタグに埋め込まれたbase64の画像を見つけることを確認します。
+func TestFindInlineImages(t *testing.T) {
+	const png = "data:image/png;base64," + onePixelPNG
+	const svg = "data:image/svg+xml;charset=utf-8;base64,PHN2Zy8+"
+	doc := `<html><head><style>.logo { background: url("` + svg + `") no-repeat; }</style></head><body>
+<div style="background-image: url(&quot;` + png + `&quot;)"></div>
+<img srcset="` + png + ` 2x">
+<span data-icon="` + svg + `"></span>
+<a href="data:text/plain;base64,aGVsbG8=">テキスト</a>
+<p>data:image/png;base64,</p>
+</body></html>`
+	var got []string
+	for _, img := range findInlineImages(doc) {
+		got = append(got, img.Src)
+	}
+	want := []string{svg, png, png, svg}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findInlineImages = %q, want %q", got, want)
+	}
+}
+
+// TestBuildJobsInlineDuplicatesは同じ内容のdata: URIの画像を1つにまとめることを確認します。
+func TestBuildJobsInlineDuplicates(t *testing.T) {
+	base, _ := url.Parse("https://growi.example.com/page")
+	// base64とURLエンコードで表現が異なっても、デコードした内容が同じなら同じ画像
+	images := []ImageSource{
+		{Src: "data:image/svg+xml;base64,PHN2Zy8+"},
+		{Src: "data:image/png;base64," + onePixelPNG},
+		{Src: "data:image/svg+xml,%3Csvg%2F%3E"},
+		{Src: "data:image/png;base64," + onePixelPNG},
+	}
+	d := newTestDownloader(Options{})
+	jobs := d.buildJobs(context.Background(), http.DefaultClient, base, "", images)
+	want := []string{images[0].Src, images[1].Src}
+	if got := jobURLs(jobs); !reflect.DeepEqual(got, want) {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+}
+
+// TestDownloadNoBrowserInlineはExtract.InlineDataの場合に、style属性に埋め込まれたbase64の画像をデコードして保存することを確認します。
+func TestDownloadNoBrowserInline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<div class="diagram" style="background-image: url(&quot;data:image/png;base64,` + onePixelPNG + `&quot;)"></div>
+<div style="background: url(data:image/png;base64,` + onePixelPNG + `)"></div>`))
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.RespectRobots = false
+	opts.Extract.InlineData = true
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("results = %+v, want 1件の成功", results)
+	}
+	want, _ := base64.StdEncoding.DecodeString(onePixelPNG)
+	if got := readFile(t, filepath.Join(opts.OutDir, results[0].File)); got != string(want) {
+		t.Errorf("%s の内容がデコードした画像と異なります", results[0].File)
+	}
+	if filepath.Ext(results[0].File) != ".png" {
+		t.Errorf("ファイル名 = %q, want 拡張子.png", results[0].File)
+	}
+}
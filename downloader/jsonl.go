@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+)
+
+// JSONLWriterはダウンロードが1件終わるごとに、その結果を1行のJSON（JSON Lines）として書き込みます。
+// 全ての結果を最後にまとめて書き込むマニフェストと異なり、長いクロールの途中でも別のプロセスが結果を読めます。
+// 同時に実行するダウンロードで共有するため、1行ずつ排他して書き込みます。
+type JSONLWriter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	failed bool // 書き込みに失敗したため、以降は書き込まない
+}
+
+// jsonlRecordはJSONLWriterが書き込む1行の内容です。
+type jsonlRecord struct {
+	Page   string `json:"page"`
+	URL    string `json:"url"`
+	Path   string `json:"path,omitempty"` // 保存したファイルのパス（アーカイブの場合はエントリの名前。保存しなかった場合は空）
+	Status string `json:"status"`         // "downloaded"、"skipped"、"filtered"、"failed"のいずれか
+	Bytes  int64  `json:"bytes"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewJSONLWriterはwに書き込むJSONLWriterを作成します。
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &JSONLWriter{enc: enc}
+}
+
+// writeはダウンロード1件の結果rを1行書き込みます。optsはrのダウンロードに使った設定です（保存先のパスを決めるために使います）。
+// wがnilの場合は何もしません。書き込みに失敗した場合は一度だけログに出力し、以降は書き込みません。
+func (w *JSONLWriter) write(r Result, opts DownloadOptions) {
+	if w == nil {
+		return
+	}
+	record := jsonlRecord{Page: r.Page, URL: r.URL, Bytes: r.Size, Error: r.Error}
+	switch {
+	case !r.Success:
+		record.Status = "failed"
+	case r.Filtered:
+		record.Status = "filtered"
+	case r.Skipped:
+		record.Status = "skipped"
+	default:
+		record.Status = "downloaded"
+	}
+	if r.Success && !r.Filtered && r.File != "" {
+		record.Path = r.File
+		if opts.Archive == nil {
+			record.Path = filepath.Join(opts.OutDir, r.File)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed {
+		return
+	}
+	if err := w.enc.Encode(record); err != nil {
+		w.failed = true
+		slog.Error("JSON Linesの書き込みに失敗しました。以降の結果は書き込みません", "error", err)
+	}
+}
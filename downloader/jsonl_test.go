@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// TestDownloadJSONLはJSONLを指定した場合に、ダウンロードが1件終わるごとに結果を1行のJSONとして書き込むことを確認します。
+func TestDownloadJSONL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<img src="/a.png"><img src="/missing.png"><img src="/attachment/1">`))
+	})
+	mux.HandleFunc("/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	// URLに拡張子がないため、Content-Typeで拡張子が決まってから絞り込まれる
+	mux.HandleFunc("/attachment/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte("<svg/>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.RespectRobots = false
+	opts.Download.Retry.Retries = 0
+	opts.Download.ExtFilter = ExtFilter{Exclude: []string{"svg"}}
+	opts.Download.JSONL = NewJSONLWriter(&buf)
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	if _, err := d.Download(context.Background(), srv.URL+"/page"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+
+	// 完了した順に書き込まれるため、URLごとに確認する
+	records := make(map[string]jsonlRecord)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record jsonlRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("JSONとして解析できない行です: %q: %v", scanner.Text(), err)
+		}
+		records[record.URL] = record
+	}
+	if len(records) != 3 {
+		t.Fatalf("records = %+v, want 3件", records)
+	}
+	if got, want := records[srv.URL+"/a.png"], (jsonlRecord{Page: srv.URL + "/page", URL: srv.URL + "/a.png", Path: filepath.Join(opts.OutDir, "a.png"), Status: "downloaded", Bytes: 3}); got != want {
+		t.Errorf("a.png = %+v, want %+v", got, want)
+	}
+	if got := records[srv.URL+"/missing.png"]; got.Status != "failed" || got.Error == "" || got.Path != "" {
+		t.Errorf("missing.png = %+v, want 理由のあるfailed", got)
+	}
+	if got := records[srv.URL+"/attachment/1"]; got.Status != "filtered" || got.Path != "" {
+		t.Errorf("attachment/1 = %+v, want filtered", got)
+	}
+}
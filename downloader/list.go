@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// listJobsはjobsのファイルをダウンロードせずにHEADリクエストで調べ、種類とサイズを結果に記録します。
+// ファイル名はダウンロードの場合と同様にContent-DispositionとContent-Typeで決めます。
+func listJobs(ctx context.Context, client *http.Client, pageURL string, jobs []downloadJob, opts DownloadOptions) []Result {
+	results := make([]Result, len(jobs))
+	for i, job := range jobs {
+		if ctx.Err() != nil {
+			results[i] = newResult(pageURL, job, DownloadResult{FileName: job.fileName}, ctx.Err())
+			continue
+		}
+		jobOpts := opts
+		if job.keepName {
+			jobOpts.IgnoreContentDisposition = true
+		}
+		result, err := probeJob(ctx, client, job, jobOpts)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("ファイルの情報を取得できませんでした", "url", displayURL(job.url), "error", err)
+		}
+		results[i] = newResult(pageURL, job, result, err)
+	}
+	return results
+}
+
+// probeJobはjobのファイルの名前・種類・サイズ（不明な場合は-1）を調べます。data: URIの場合はデコードして調べます。
+func probeJob(ctx context.Context, client *http.Client, job downloadJob, opts DownloadOptions) (DownloadResult, error) {
+	if isDataURI(job.url) {
+		mediaType, data, err := decodeDataURI(job.url)
+		if err != nil {
+			return DownloadResult{FileName: job.fileName}, err
+		}
+		return DownloadResult{FileName: job.fileName + extensionForMIME(mediaType), ContentType: mediaType, Size: int64(len(data))}, nil
+	}
+	return probeURL(ctx, client, job.url, job.fileName, opts)
+}
+
+// probeURLはurlStrにHEADリクエストを送り、保存する場合のファイル名とContent-Type、Content-Length（不明な場合は-1）を返します。
+// HEADを受け付けないサーバー（405や501を返す場合）には、Rangeヘッダで先頭の1バイトだけをGETし、
+// Content-Rangeの全体のサイズを使います。
+func probeURL(ctx context.Context, client *http.Client, urlStr, fileName string, opts DownloadOptions) (DownloadResult, error) {
+	result := DownloadResult{FileName: fileName, Size: -1}
+	resp, err := sendProbe(ctx, client, http.MethodHead, urlStr, opts)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		slog.Debug("HEADを受け付けないため、先頭の1バイトをGETします", "url", urlStr, "status", resp.Status)
+		resp, err = sendProbe(ctx, client, http.MethodGet, urlStr, opts)
+	}
+	if err != nil {
+		return result, err
+	}
+	result.StatusCode = resp.StatusCode
+	result.ContentType = resp.Header.Get("Content-Type")
+	switch resp.StatusCode {
+	case http.StatusOK:
+		result.Size = resp.ContentLength
+	case http.StatusPartialContent:
+		result.Size = contentRangeSize(resp.Header.Get("Content-Range"))
+	default:
+		return result, fmt.Errorf("HTTPステータスがOKではありません: %s", resp.Status)
+	}
+	result.FileName = responseFileName(fileName, resp, opts)
+	return result, nil
+}
+
+// sendProbeはurlStrにmethodのリクエストを送ります。GETの場合は先頭の1バイトだけを要求し、本文は読まずに閉じます。
+func sendProbe(ctx context.Context, client *http.Client, method, urlStr string, opts DownloadOptions) (*http.Response, error) {
+	req, err := newRequest(ctx, method, urlStr, opts)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// contentRangeSizeはContent-Rangeヘッダ（"bytes 0-0/12345"）の全体のサイズを返します。不明な場合は-1を返します。
+func contentRangeSize(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// printListはlistJobsの結果を番号、ファイル名、URL、Content-Type、サイズの表としてwに出力します。
+func printList(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tFILE\tURL\tCONTENT-TYPE\tSIZE")
+	for i, r := range results {
+		contentType, size := r.ContentType, "-"
+		if r.Size >= 0 {
+			size = strconv.FormatInt(r.Size, 10)
+		}
+		if r.Error != "" {
+			contentType = "error: " + r.Error
+		}
+		if contentType == "" {
+			contentType = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", i+1, r.File, r.URL, contentType, size)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestListJobsはHEADリクエストで種類とサイズを調べ、HEADを受け付けないサーバーには先頭の1バイトのGETで調べることを確認します。
+func TestListJobs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.png":
+			if r.Method != http.MethodHead {
+				t.Errorf("/a.png: method = %s, want HEAD", r.Method)
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", "1234")
+		case "/download":
+			if r.Method == http.MethodHead {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if got := r.Header.Get("Range"); got != "bytes=0-0" {
+				t.Errorf("/download: Range = %q, want %q", got, "bytes=0-0")
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+			w.Header().Set("Content-Range", "bytes 0-0/5678")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("%"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir()}
+	jobs := []downloadJob{
+		{url: srv.URL + "/a.png", fileName: "a.png"},
+		{url: srv.URL + "/download", fileName: "download"},
+		{url: srv.URL + "/missing.png", fileName: "missing.png"},
+	}
+	results := listJobs(context.Background(), srv.Client(), srv.URL+"/page", jobs, opts)
+
+	want := []struct {
+		file, contentType string
+		size              int64
+		failed            bool
+	}{
+		{"a.png", "image/png", 1234, false},
+		{"report.pdf", "application/pdf", 5678, false},
+		{"missing.png", "text/plain; charset=utf-8", -1, true},
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.File != w.file || r.ContentType != w.contentType || r.Size != w.size || (r.Error != "") != w.failed {
+			t.Errorf("results[%d] = {File: %q, ContentType: %q, Size: %d, Error: %q}, want {%q, %q, %d, failed: %v}",
+				i, r.File, r.ContentType, r.Size, r.Error, w.file, w.contentType, w.size, w.failed)
+		}
+	}
+	if entries, _ := os.ReadDir(opts.OutDir); len(entries) != 0 {
+		t.Errorf("保存先にファイルが作成されました: %d件", len(entries))
+	}
+
+	var buf bytes.Buffer
+	printList(&buf, results)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("表の行数 = %d, want 4:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []string{"report.pdf", "application/pdf", "5678"} {
+		if !strings.Contains(lines[2], want) {
+			t.Errorf("2件目の行に%q（%d番目）がありません: %q", want, i, lines[2])
+		}
+	}
+	if !strings.Contains(lines[3], "error:") || !strings.HasSuffix(lines[3], "-") {
+		t.Errorf("失敗した行 = %q, want error: と サイズ\"-\"", lines[3])
+	}
+}
+
+// TestContentRangeSizeはContent-Rangeヘッダから全体のサイズを取り出せることを確認します。
+func TestContentRangeSize(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"bytes 0-0/5678", 5678},
+		{"bytes 0-0/*", -1},
+		{"", -1},
+		{"bytes 0-0", -1},
+	}
+	for _, tt := range tests {
+		if got := contentRangeSize(tt.header); got != tt.want {
+			t.Errorf("contentRangeSize(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
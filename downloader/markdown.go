@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// markdownEscaperはMarkdownで意味を持つ文字をバックスラッシュでエスケープします。
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "{", `\{`, "}", `\}`, "[", `\[`, "]", `\]`,
+	"(", `\(`, ")", `\)`, "#", `\#`, "+", `\+`, "!", `\!`, "|", `\|`, "<", `\<`, ">", `\>`, "~", `\~`,
+)
+
+// WriteMarkdownはresultsのうちダウンロードに成功したファイル（既に存在したためスキップしたものを含む）の一覧を
+// MarkdownでmdPathに書き込みます。画像は画像として、それ以外はリンクとして、ダウンロード元のURLと保存先のパスを添えます。
+// 保存先へのリンクはmdPathのディレクトリからの相対パスにします。nowは見出しに記載する実行日時です。
+func (d *Downloader) WriteMarkdown(mdPath string, results []Result, now time.Time) error {
+	baseDir, err := filepath.Abs(filepath.Dir(mdPath))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	renderMarkdown(&buf, results, now, func(r Result) string {
+		filePath, err := filepath.Abs(filepath.Join(d.opts.OutDir, d.savedPath(r)))
+		if err != nil {
+			return filepath.Join(d.opts.OutDir, d.savedPath(r))
+		}
+		if rel, err := filepath.Rel(baseDir, filePath); err == nil {
+			return rel
+		}
+		return filePath
+	})
+	_, err = writeFileAtomic(mdPath, &buf)
+	return err
+}
+
+// renderMarkdownはWriteMarkdownの内容をページごとの見出しに分けてwに出力します。
+// localPathはファイルのリンク先（Markdownのファイルからの相対パス）を返します。
+func renderMarkdown(w io.Writer, results []Result, now time.Time, localPath func(Result) string) {
+	fmt.Fprintf(w, "# Downloaded attachments\n\nGenerated: %s\n", now.Format(time.RFC3339))
+	page := ""
+	for i, r := range results {
+		if i == 0 || r.Page != page {
+			page = r.Page
+			fmt.Fprintf(w, "\n## <%s>\n\n", page)
+		}
+		if !r.Success || r.Filtered || r.File == "" {
+			continue
+		}
+		local := filepath.ToSlash(localPath(r))
+		// 空白や括弧を含むファイル名でもリンクが切れないように、パスをURLとしてエスケープする
+		dest := (&url.URL{Path: local}).String()
+		name := markdownEscaper.Replace(r.File)
+		if isImageFile(r.File) {
+			fmt.Fprintf(w, "- ![%s](%s)\n", name, dest)
+		} else {
+			fmt.Fprintf(w, "- [%s](%s)\n", name, dest)
+		}
+		if isDataURI(r.URL) {
+			fmt.Fprintf(w, "  - Source: %s\n", markdownEscaper.Replace(r.URL))
+		} else {
+			fmt.Fprintf(w, "  - Source: <%s>\n", r.URL)
+		}
+		fmt.Fprintf(w, "  - Local: %s\n", markdownEscaper.Replace(local))
+	}
+}
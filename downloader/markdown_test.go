@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// markdownTestResultsはMarkdownのテストに使う、2ページ分のダウンロード結果です。
+var markdownTestResults = []Result{
+	{Page: "https://growi.example.com/Docs", URL: "https://growi.example.com/attachment/1", File: "image_1.png", Success: true},
+	{Page: "https://growi.example.com/Docs", URL: "https://growi.example.com/attachment/2", File: "image (1).png", Success: true, Skipped: true},
+	{Page: "https://growi.example.com/Docs", URL: "https://growi.example.com/missing.png", File: "missing.png", Error: "404"},
+	{Page: "https://growi.example.com/Docs/設計", URL: "https://growi.example.com/attachment/3", File: "設計書 [v2].xlsx", Success: true},
+	{Page: "https://growi.example.com/Docs/設計", URL: "data:image/png;base64", File: "inline.png", Success: true},
+	{Page: "https://growi.example.com/Docs/設計", URL: "https://growi.example.com/icon.svg", File: "icon.svg", Success: true, Skipped: true, Filtered: true},
+}
+
+// TestRenderMarkdownは出力したMarkdownが期待するスナップショット（testdata/markdown.golden.md）と一致することを確認します。
+func TestRenderMarkdown(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("JST", 9*60*60))
+	var buf bytes.Buffer
+	renderMarkdown(&buf, markdownTestResults, now, func(r Result) string { return filepath.Join("out", r.File) })
+	want, err := os.ReadFile(filepath.Join("testdata", "markdown.golden.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("Markdownがスナップショットと異なります:\n--- got\n%s\n--- want\n%s", got, want)
+	}
+}
+
+// TestWriteMarkdownは保存先へのリンクがMarkdownのファイルからの相対パスになることを確認します。
+func TestWriteMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	d := &Downloader{opts: Options{OutDir: filepath.Join(dir, "out"), PerPageDir: true}}
+	mdPath := filepath.Join(dir, "attachments.md")
+	if err := d.WriteMarkdown(mdPath, markdownTestResults[:1], time.Now()); err != nil {
+		t.Fatalf("WriteMarkdown: %v", err)
+	}
+	if got := readFile(t, mdPath); !strings.Contains(got, "![image\\_1.png](out/Docs/image_1.png)") {
+		t.Errorf("相対パスのリンクがありません:\n%s", got)
+	}
+}
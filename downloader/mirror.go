@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// mirrorExtはMirrorで保存するページのHTMLのファイル名に付ける拡張子です。
+const mirrorExt = ".html"
+
+// mirrorSrcAttrsは画像のURLを持つ属性です（遅延読み込みのライブラリが使う属性を含む）。
+var mirrorSrcAttrs = []string{"data-src", "data-original", "src"}
+
+// writeMirrorはpageのHTML（body）の画像の参照をresultsで保存したファイルに書き換え、OutDirに"<ページのパス>.html"として保存します。
+// ダウンロードに失敗した画像は元のURLのまま残し、そのほかの相対URL（リンクやスタイルシート）は絶対URLにするため、
+// 保存したHTMLは画像をオフラインで表示でき、リンクは元のサイトを指します。
+func (d *Downloader) writeMirror(base *url.URL, body []byte, results []Result) error {
+	// HTMLをOutDirに置くため、画像はOutDirからの相対パスで参照する
+	files := make(map[string]string)
+	for _, r := range results {
+		if !r.Success || r.Filtered || r.File == "" || isDataURI(r.URL) {
+			continue
+		}
+		files[r.URL] = (&url.URL{Path: filepath.ToSlash(d.savedPath(r))}).String()
+	}
+	mirrored, rewritten, err := rewriteMirrorHTML(body, base, files)
+	if err != nil {
+		return fmt.Errorf("ページのHTMLの解析に失敗: %w", err)
+	}
+	filePath := filepath.Join(d.opts.OutDir, pageDirName(base)+mirrorExt)
+	if _, err := writeFileAtomic(filePath, bytes.NewReader(mirrored)); err != nil {
+		return err
+	}
+	slog.Info("ページのHTMLを保存しました", "page", base.String(), "file", filePath, "images", rewritten)
+	return nil
+}
+
+// rewriteMirrorHTMLはHTMLのimgタグなどの画像の参照のうち、files（画像の絶対URL → 保存したファイルの相対URL）にあるものを
+// 保存したファイルに書き換え、書き換えたHTMLと書き換えた画像の数を返します。
+// 書き換えた相対URLの基準が変わらないようにbaseタグを、保存したHTMLを元のサイトのスクリプトが書き換えないようにscriptタグを取り除きます。
+func rewriteMirrorHTML(body []byte, base *url.URL, files map[string]string) ([]byte, int, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	// 画像のURLはbaseタグのhref属性を基準に解決しているため、baseタグを取り除く前に基準を合わせる
+	if href := findBaseHref(doc); href != "" {
+		if b, err := base.Parse(href); err == nil {
+			base = b
+		}
+	}
+	rewritten := 0
+	var removed []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script, atom.Base:
+				removed = append(removed, n)
+				return
+			case atom.Img, atom.Source:
+				if rewriteMirrorImage(n, base, files) {
+					rewritten++
+				}
+			default:
+				for i, a := range n.Attr {
+					if a.Namespace == "" && (a.Key == "href" || a.Key == "src") {
+						n.Attr[i].Val = mirrorURL(a.Val, base, files)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	for _, n := range removed {
+		n.Parent.RemoveChild(n)
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), rewritten, nil
+}
+
+// rewriteMirrorImageはimgタグかsourceタグnのsrc属性とsrcset属性を保存したファイルに書き換え、書き換えたかどうかを返します。
+// 遅延読み込みのdata-src属性などにしか保存したファイルがない場合も、スクリプトなしで表示できるようsrc属性に設定します。
+func rewriteMirrorImage(n *html.Node, base *url.URL, files map[string]string) bool {
+	local := ""
+	for _, key := range mirrorSrcAttrs {
+		if local = files[resolveMirrorURL(firstAttr(n, key), base)]; local != "" {
+			break
+		}
+	}
+	for i, a := range n.Attr {
+		if a.Namespace != "" {
+			continue
+		}
+		switch a.Key {
+		case "srcset", "data-srcset":
+			srcset, candidate := mirrorSrcset(a.Val, base, files)
+			n.Attr[i].Val = srcset
+			if local == "" {
+				local = candidate
+			}
+		case "src", "data-src", "data-original":
+			n.Attr[i].Val = mirrorURL(a.Val, base, files)
+		}
+	}
+	if local == "" {
+		return false
+	}
+	if n.DataAtom == atom.Img {
+		setAttr(n, "src", local)
+	}
+	return true
+}
+
+// mirrorSrcsetはsrcset属性の候補のうち保存したファイルがあるものだけを残して書き換え、書き換えたsrcsetと、
+// 保存したファイルのうち最初の候補の相対URLを返します。保存したファイルが1つもない場合は絶対URLにしたsrcsetを返します。
+func mirrorSrcset(srcset string, base *url.URL, files map[string]string) (string, string) {
+	var local, remote []string
+	first := ""
+	for _, c := range parseSrcset(srcset) {
+		descriptor := ""
+		switch {
+		case c.width != 0:
+			descriptor = " " + strconv.Itoa(c.width) + "w"
+		case c.density != 1:
+			descriptor = " " + strconv.FormatFloat(c.density, 'g', -1, 64) + "x"
+		}
+		abs := resolveMirrorURL(c.url, base)
+		if file := files[abs]; file != "" {
+			local = append(local, file+descriptor)
+			if first == "" {
+				first = file
+			}
+		} else {
+			remote = append(remote, mirrorURL(c.url, base, files)+descriptor)
+		}
+	}
+	if len(local) > 0 {
+		return strings.Join(local, ", "), first
+	}
+	if len(remote) == 0 {
+		return srcset, ""
+	}
+	return strings.Join(remote, ", "), ""
+}
+
+// mirrorURLは属性の値のURLを、保存したファイルがあればその相対URLに、なければ絶対URLにして返します。
+// ページ内のリンク（"#"で始まるもの）やjavascript:、data:などのhttp(s)以外のURLはそのまま返します。
+func mirrorURL(value string, base *url.URL, files map[string]string) string {
+	abs := resolveMirrorURL(value, base)
+	if abs == "" {
+		return value
+	}
+	if file := files[abs]; file != "" {
+		return file
+	}
+	return abs
+}
+
+// resolveMirrorURLはvalueをbaseで解決したhttp(s)の絶対URLを返します。解決できない場合は空文字列を返します。
+func resolveMirrorURL(value string, base *url.URL) string {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.HasPrefix(value, "#") {
+		return ""
+	}
+	u, err := base.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+// setAttrはnのkey属性をvalueにします（ない場合は追加します）。
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
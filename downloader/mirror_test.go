@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// TestRewriteMirrorHTMLは保存した画像の参照をファイルの相対URLに、保存していない画像とリンクを絶対URLに書き換え、
+// scriptタグとbaseタグを取り除くことを確認します。
+func TestRewriteMirrorHTML(t *testing.T) {
+	base, _ := url.Parse("https://growi.example.com/Docs/page")
+	files := map[string]string{
+		"https://growi.example.com/attachment/a.png": "a.png",
+		"https://growi.example.com/attachment/b.png": "b%20(1).png",
+		"https://growi.example.com/attachment/c.png": "c.png",
+		"https://cdn.example.com/big.png":            "big.png",
+	}
+	body := `<html><head><base href="/other/"><script>render()</script><link rel="stylesheet" href="/style.css"></head><body>
+<img src="/attachment/a.png">
+<img src="placeholder.gif" data-src="/attachment/b.png">
+<img src="/attachment/missing.png" srcset="/attachment/missing.png 1x, https://cdn.example.com/big.png 2x">
+<picture><source srcset="/attachment/c.png 640w, /attachment/d.png 1280w"><img src="/attachment/d.png"></picture>
+<img src="data:image/png;base64,AAAA">
+<a href="/attachment/c.png">original</a> <a href="#top">top</a> <a href="other">other</a>
+</body></html>`
+	out, rewritten, err := rewriteMirrorHTML([]byte(body), base, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewritten != 4 {
+		t.Errorf("書き換えた画像の数 = %d, want 4", rewritten)
+	}
+	got := string(out)
+	for _, want := range []string{
+		`<img src="a.png"/>`,
+		`<img src="b%20(1).png" data-src="b%20(1).png"/>`,
+		`<img src="big.png" srcset="big.png 2x"/>`,
+		`<source srcset="c.png 640w"/><img src="https://growi.example.com/attachment/d.png"/>`,
+		`<img src="data:image/png;base64,AAAA"/>`,
+		`<link rel="stylesheet" href="https://growi.example.com/style.css"/>`,
+		`<a href="c.png">original</a> <a href="#top">top</a> <a href="https://growi.example.com/other/other">other</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%sが含まれていません:\n%s", want, got)
+		}
+	}
+	for _, removed := range []string{"<script", "<base"} {
+		if strings.Contains(got, removed) {
+			t.Errorf("%sが残っています:\n%s", removed, got)
+		}
+	}
+}
+
+// TestDownloadMirrorはMirrorの場合にページのHTMLをOutDirに保存し、書き換えたsrc属性が保存したファイルを指し、
+// ダウンロードに失敗した画像は元のURLのまま残ることを確認します。
+func TestDownloadMirror(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Docs/page":
+			w.Write([]byte(`<!DOCTYPE html><html><body><img src="/attachment/a.png"><img src="../attachment/b.png"><img src="/attachment/missing.png"></body></html>`))
+		case "/attachment/missing.png":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.RespectRobots = false
+	opts.Mirror = true
+	opts.Download.Retry.Retries = 0
+	d, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.Download(context.Background(), srv.URL+"/Docs/page"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(opts.OutDir, "Docs_page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, _ := ParseImagesHTML(bytes.NewReader(data))
+	if len(images) != 3 {
+		t.Fatalf("imgタグの数 = %d, want 3:\n%s", len(images), data)
+	}
+	for _, img := range images[:2] {
+		if _, err := os.Stat(filepath.Join(opts.OutDir, filepath.FromSlash(img.Src))); err != nil {
+			t.Errorf("src = %q: 保存したファイルがありません: %v", img.Src, err)
+		}
+	}
+	if want := srv.URL + "/attachment/missing.png"; images[2].Src != want {
+		t.Errorf("失敗した画像のsrc = %q, want %q", images[2].Src, want)
+	}
+	if doc.FirstChild == nil || doc.FirstChild.Type != html.DoctypeNode {
+		t.Error("文書型宣言がありません")
+	}
+}
@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// NameRegistryは1回の実行で保存先として使ったファイルと、そのファイルのURLを記録します。
+// GROWIに貼り付けた画像はほとんどが"image.png"という名前のため、別のURLのファイルが同じ名前になった場合は
+// "image (1).png"のように番号を付けて区別します。複数のゴルーチンから同時に使えます。
+type NameRegistry struct {
+	mu    sync.Mutex
+	owner map[string]string // 保存先のパス → URL
+}
+
+// NewNameRegistryは空のNameRegistryを作成します。
+func NewNameRegistry() *NameRegistry {
+	return &NameRegistry{owner: make(map[string]string)}
+}
+
+// reserveはurlStrの保存先としてdir/nameを予約し、実際に使うファイル名と、既存のファイルをそのまま使えるか（スキップできるか）を返します。
+// この実行で別のURLに使われた名前は使わず、番号を付けた名前を使います。
+// 既存のファイルはoverwriteなら上書きし、そうでなければサイズがsize（Content-Length。不明な場合は-1）と同じか不明な場合にスキップします。
+// サイズが異なる場合、この実行で同じURLから保存したファイルなら書き込み途中のものとみなして上書きしますが、
+// それ以外は別のファイルとみなし、番号を付けた名前を使います（書き込みは一時ファイル経由のため、中断されたファイルは残りません）。
+// rがnilの場合は実行中の記録を使わずに判断します。
+func (r *NameRegistry) reserve(dir, name, urlStr string, size int64, overwrite bool) (string, bool) {
+	if r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+	for i := 0; ; i++ {
+		candidate := numberedName(name, i)
+		filePath := filepath.Join(dir, candidate)
+		owner, owned := r.ownerOf(filePath)
+		if owned && owner != urlStr {
+			continue
+		}
+		info, err := os.Stat(filePath)
+		switch {
+		case err != nil || overwrite:
+			r.set(filePath, urlStr)
+			return candidate, false
+		case size < 0 || info.Size() == size:
+			r.set(filePath, urlStr)
+			return candidate, true
+		case owned:
+			slog.Warn("既存のファイルのサイズがContent-Lengthと異なるため再取得します", "file", candidate, "size", info.Size(), "content_length", size)
+			return candidate, false
+		}
+	}
+}
+
+// reserveExistingはurlStrの保存先として使える既存のファイル（この実行で別のURLに使われていないもの）があれば予約し、
+// そのファイル名を返します。番号を付けた名前も順に確認し、見つからない場合は何も予約しません。
+func (r *NameRegistry) reserveExisting(dir, name, urlStr string) (string, bool) {
+	if r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+	for i := 0; ; i++ {
+		candidate := numberedName(name, i)
+		filePath := filepath.Join(dir, candidate)
+		if _, err := os.Stat(filePath); err != nil {
+			return "", false
+		}
+		if owner, ok := r.ownerOf(filePath); ok && owner != urlStr {
+			continue
+		}
+		r.set(filePath, urlStr)
+		return candidate, true
+	}
+}
+
+// ownerOfはこの実行でfilePathに保存したURLを返します。
+func (r *NameRegistry) ownerOf(filePath string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	owner, ok := r.owner[filePath]
+	return owner, ok
+}
+
+// setはfilePathをurlStrの保存先として記録します。
+func (r *NameRegistry) set(filePath, urlStr string) {
+	if r != nil {
+		r.owner[filePath] = urlStr
+	}
+}
+
+// numberedNameはnameのi番目の候補（0は元の名前、1以降は"image (1).png"の形式）を返します。
+func numberedName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+}
@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// DefaultNameTemplateは-name-templateの既定値で、URLから決めたファイル名をそのまま使います。
+const DefaultNameTemplate = "{{.OrigName}}"
+
+// NameTemplateDataは-name-templateのテンプレートに渡す、画像1件の情報です。
+type NameTemplateData struct {
+	Index     int    // ページ内での画像の番号（1から）
+	OrigName  string // URLから決めたファイル名（拡張子を含む。決められない場合は"image_<番号>"）
+	Ext       string // OrigNameの拡張子（"."を含む。URLにない場合は空で、ダウンロード時にContent-Typeから付ける）
+	URLHash   string // 画像のURLのSHA-256の先頭16文字
+	PageTitle string // ページのタイトル（ファイル名に使えない文字は"_"に置き換え済み。DOMから取得できない場合はURLのパスから決める）
+}
+
+// ParseNameTemplateは-name-templateのテンプレートを解析します。
+// 存在しない変数などの誤りは実行するまで分からないため、例の値で実行して確認します。
+func ParseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := NameTemplateData{Index: 1, OrigName: "image.png", Ext: ".png", URLHash: urlHash("https://growi.example.com/attachment/1"), PageTitle: "page"}
+	if _, err := renderName(tmpl, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderNameはtmplをdataで実行し、sanitizeFilenameで整えたファイル名を返します。
+func renderName(tmpl *template.Template, data NameTemplateData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return sanitizeFilename(b.String()), nil
+}
+
+// jobFileNameはOptions.NameTemplateでindex番目の画像のファイル名を決め、TitlePrefixの場合は先頭にページのタイトルを付けます。
+func (d *Downloader) jobFileName(index int, origName, urlStr, pageTitle string) string {
+	name := d.templateFileName(index, origName, urlStr, pageTitle)
+	if d.opts.TitlePrefix && pageTitle != "" {
+		name = truncateFilename(pageTitle+"_"+name, maxFilenameBytes)
+	}
+	return name
+}
+
+// templateFileNameはOptions.NameTemplateでindex番目の画像のファイル名を決めます。
+// テンプレートがない場合や、結果が空になる場合はorigNameを使います。
+func (d *Downloader) templateFileName(index int, origName, urlStr, pageTitle string) string {
+	if d.opts.NameTemplate == nil {
+		return origName
+	}
+	data := NameTemplateData{
+		Index:     index,
+		OrigName:  origName,
+		Ext:       filepath.Ext(origName),
+		URLHash:   urlHash(urlStr),
+		PageTitle: pageTitle,
+	}
+	name, err := renderName(d.opts.NameTemplate, data)
+	if err != nil {
+		slog.Warn("ファイル名のテンプレートを実行できないため、元の名前を使います", "url", displayURL(urlStr), "error", err)
+		return origName
+	}
+	if name == "" {
+		return origName
+	}
+	return name
+}
+
+// urlHashはurlStrのSHA-256の先頭16文字を返します。
+func urlHash(urlStr string) string {
+	sum := sha256.Sum256([]byte(urlStr))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// titleReplacerはページのタイトルのうち、sanitizeFilenameがURLの記号として扱う文字を置き換えます。
+var titleReplacer = strings.NewReplacer("?", "_", "#", "_", "%", "_")
+
+// pageTitleOrPathはDOMから取得したページのタイトルをファイル名に使える形に整えて返します。
+// タイトルが空の場合（NoBrowserやAPIで取得しない場合を含む）はpageTitleFromURLを使います。
+func pageTitleOrPath(title string, pageURL *url.URL) string {
+	// sanitizeFilenameはURLの一部として扱うため、タイトル中の"?"や"#"以降が切り捨てられたり"%"がデコードされたりしないようにする
+	title = titleReplacer.Replace(strings.TrimSpace(title))
+	if title := sanitizeFilename(title); title != "" {
+		return title
+	}
+	return pageTitleFromURL(pageURL)
+}
+
+// pageTitleFromURLはページURLのパスの最後の要素をページのタイトルとして返します（GROWIではページ名にあたります）。
+// パスが"/"の場合はホスト名を返します。
+func pageTitleFromURL(pageURL *url.URL) string {
+	if base := path.Base(pageURL.Path); base != "/" && base != "." {
+		if title := sanitizeFilename(base); title != "" {
+			return title
+		}
+	}
+	return sanitizeFilename(pageURL.Hostname())
+}
@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// TestParseNameTemplateは構文の誤りと存在しない変数を起動時にエラーにすることを確認します。
+func TestParseNameTemplate(t *testing.T) {
+	tests := []struct {
+		text    string
+		wantErr bool
+	}{
+		{DefaultNameTemplate, false},
+		{`{{.PageTitle}}_{{printf "%03d" .Index}}{{.Ext}}`, false},
+		{"{{.OrigName", true},
+		{"{{.Title}}", true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseNameTemplate(tt.text); (err != nil) != tt.wantErr {
+			t.Errorf("ParseNameTemplate(%q) = %v, wantErr %v", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+// TestBuildJobsNameTemplateは-name-templateで画像ごとのファイル名を決め、結果をファイル名に使える形に整えることを確認します。
+func TestBuildJobsNameTemplate(t *testing.T) {
+	base, _ := url.Parse("https://growi.example.com/Docs/設計")
+	images := []ImageSource{
+		{Src: "/attachment/a.png"},
+		{Src: "/attachment/649abc"},
+		{Src: "data:image/png;base64," + onePixelPNG},
+	}
+	tests := []struct {
+		template string
+		want     []string
+	}{
+		{DefaultNameTemplate, []string{"a.png", "649abc", "image_3"}},
+		{`{{printf "%03d" .Index}}{{.Ext}}`, []string{"001.png", "002", "003"}},
+		{"{{.PageTitle}}_{{.OrigName}}", []string{"設計_a.png", "設計_649abc", "設計_image_3"}},
+		{"{{.URLHash}}{{.Ext}}", []string{urlHash("https://growi.example.com/attachment/a.png") + ".png", urlHash("https://growi.example.com/attachment/649abc"), urlHash("data:image/png;base64," + onePixelPNG)}},
+		{"../{{.OrigName}}", []string{".._a.png", ".._649abc", ".._image_3"}},
+		{"{{if eq .Index 2}}{{else}}{{.OrigName}}{{end}}", []string{"a.png", "649abc", "image_3"}},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseNameTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("ParseNameTemplate(%q): %v", tt.template, err)
+		}
+		jobs := newTestDownloader(Options{NameTemplate: tmpl}).buildJobs(context.Background(), http.DefaultClient, base, "", images)
+		var got []string
+		for _, job := range jobs {
+			got = append(got, job.fileName)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ファイル名 = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+// TestPageTitleFromURLはページURLのパスの最後の要素をタイトルとし、パスがない場合はホスト名を使うことを確認します。
+func TestPageTitleFromURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://growi.example.com/Docs/%E8%A8%AD%E8%A8%88": "設計",
+		"https://growi.example.com/Docs/":                   "Docs",
+		"https://growi.example.com/":                        "growi.example.com",
+	} {
+		u, _ := url.Parse(in)
+		if got := pageTitleFromURL(u); got != want {
+			t.Errorf("pageTitleFromURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestBuildJobsTitlePrefixはTitlePrefixでDOMから取得したページのタイトルをファイル名の先頭に付け、
+// タイトルが空の場合はURLのパスで代用することを確認します。
+func TestBuildJobsTitlePrefix(t *testing.T) {
+	base, _ := url.Parse("https://growi.example.com/Docs/%E8%A8%AD%E8%A8%88")
+	images := []ImageSource{{Src: "/attachment/image.png"}, {Src: "data:image/png;base64," + onePixelPNG}}
+	tests := []struct {
+		title string
+		want  []string
+	}{
+		{"MyPage", []string{"MyPage_image.png", "MyPage_image_2"}},
+		{"  Q&A: 質問? #1  ", []string{"Q&A_ 質問_ _1_image.png", "Q&A_ 質問_ _1_image_2"}},
+		{"", []string{"設計_image.png", "設計_image_2"}},
+		{" ", []string{"設計_image.png", "設計_image_2"}},
+	}
+	for _, tt := range tests {
+		jobs := newTestDownloader(Options{TitlePrefix: true}).buildJobs(context.Background(), http.DefaultClient, base, tt.title, images)
+		var got []string
+		for _, job := range jobs {
+			got = append(got, job.fileName)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("タイトル %q: ファイル名 = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+
+	// テンプレートの{{.PageTitle}}にもDOMのタイトルを渡し、TitlePrefixはテンプレートの結果に付ける
+	tmpl, err := ParseNameTemplate(`{{.PageTitle}}-{{.Index}}{{.Ext}}`)
+	if err != nil {
+		t.Fatalf("ParseNameTemplate: %v", err)
+	}
+	jobs := newTestDownloader(Options{NameTemplate: tmpl}).buildJobs(context.Background(), http.DefaultClient, base, "MyPage", images[:1])
+	if len(jobs) != 1 || jobs[0].fileName != "MyPage-1.png" {
+		t.Errorf("テンプレート: jobs = %+v", jobs)
+	}
+	jobs = newTestDownloader(Options{NameTemplate: tmpl, TitlePrefix: true}).buildJobs(context.Background(), http.DefaultClient, base, "MyPage", images[:1])
+	if len(jobs) != 1 || jobs[0].fileName != "MyPage_MyPage-1.png" {
+		t.Errorf("テンプレートとTitlePrefix: jobs = %+v", jobs)
+	}
+}
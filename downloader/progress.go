@@ -0,0 +1,29 @@
+package downloader
+
+import "sync/atomic"
+
+// Progressは全ページのダウンロードの進み具合（終わった件数と対象の件数）です。
+// 同時に実行するダウンロードで共有するため、アトミックに数えます。ページを読み込むたびに対象の件数が増えます。
+type Progress struct {
+	total atomic.Int64
+	done  atomic.Int64
+}
+
+// Countsは終わった件数（成功・スキップ・失敗を含む）と、これまでに読み込んだページの対象の件数を返します。
+func (p *Progress) Counts() (done, total int64) {
+	return p.done.Load(), p.total.Load()
+}
+
+// addはn件のダウンロードを対象の件数に加えます。pがnilの場合は何もしません。
+func (p *Progress) add(n int) {
+	if p != nil {
+		p.total.Add(int64(n))
+	}
+}
+
+// finishはダウンロードが1件終わったことを記録します。pがnilの場合は何もしません。
+func (p *Progress) finish() {
+	if p != nil {
+		p.done.Add(1)
+	}
+}
@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestDownloadProgressは失敗したものも含め、全てのダウンロードが終わった件数として数えられることを確認します。
+func TestDownloadProgress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Write([]byte(`<img src="/a.png"><img src="/b.png"><img src="/missing.png"><img src="/c.png">`))
+		case "/missing.png":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("png"))
+		}
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.Download.Retry.Retries = 0
+	opts.Download.Progress = new(Progress)
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := d.Download(context.Background(), srv.URL+"/page"); err != nil {
+			t.Fatalf("Download: %v", err)
+		}
+	}
+	if done, total := opts.Download.Progress.Counts(); done != 8 || total != 8 {
+		t.Errorf("Counts() = %d, %d, want 8, 8", done, total)
+	}
+}
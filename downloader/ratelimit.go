@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// maxRateBurstは転送速度を制限する場合に一度に読み込むバイト数の上限です。
+const maxRateBurst = 32 * 1024
+
+// newRateLimiterは転送速度をbytesPerSecバイト/秒に制限するrate.Limiterを作成します。
+// 同時に実行する全てのダウンロードで共有し、合計の転送速度を制限します。bytesPerSecが0以下の場合はnilを返します。
+func newRateLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(min(bytesPerSec, maxRateBurst)))
+}
+
+// rateLimitedReaderは読み込んだバイト数だけlimiterのトークンを待つio.Readerです。
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// WaitNはバーストより大きい値を受け付けないため、一度に読み込む量をバーストまでにする
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunDownloadsMaxRateは転送速度の制限が同時に実行する全てのダウンロードの合計に適用されることを確認します。
+func TestRunDownloadsMaxRate(t *testing.T) {
+	const size = 64 * 1024
+	payload := strings.Repeat("x", size)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer srv.Close()
+
+	jobs := make([]downloadJob, 2)
+	for i := range jobs {
+		name := fmt.Sprintf("%d.png", i)
+		jobs[i] = downloadJob{url: srv.URL + "/" + name, fileName: name}
+	}
+	// 64KB/sで合計128KB（最初のバースト32KBは待たない）のため、約1.5秒かかる
+	// （ワーカーごとの制限であれば約0.5秒で終わる）
+	const maxRate = 64 * 1024
+	opts := DownloadOptions{OutDir: t.TempDir(), Limiter: newRateLimiter(maxRate)}
+	start := time.Now()
+	results, err := runDownloads(context.Background(), srv.Client(), srv.URL, jobs, len(jobs), false, opts)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("runDownloads: %v", err)
+	}
+	for i, r := range results {
+		if !r.Success || r.Size != size {
+			t.Errorf("results[%d] = %+v", i, r)
+		}
+	}
+	if elapsed < 1200*time.Millisecond || elapsed > 4*time.Second {
+		t.Errorf("所要時間 = %v, want 約1.5秒", elapsed)
+	}
+}
+
+// TestNewRateLimiterは上限が0以下の場合は制限せず、上限が小さい場合はバーストを上限に合わせることを確認します。
+func TestNewRateLimiter(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Errorf("newRateLimiter(0) = %v, want nil", l)
+	}
+	if got := newRateLimiter(1000).Burst(); got != 1000 {
+		t.Errorf("newRateLimiter(1000).Burst() = %d, want 1000", got)
+	}
+	if got := newRateLimiter(10 << 20).Burst(); got != maxRateBurst {
+		t.Errorf("newRateLimiter(10MB).Burst() = %d, want %d", got, maxRateBurst)
+	}
+}
@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// partialPathはurlStrをdir/fileNameに保存する途中のファイル（.<ファイル名>.<URLのハッシュ>.part）のパスを返します。
+// 別のURLの同じ名前のファイル（GROWIの"image.png"など）と混ざらないよう、名前にURLのハッシュを含めます。
+func partialPath(dir, fileName, urlStr string) string {
+	parent, name := filepath.Split(filepath.Join(dir, fileName))
+	return filepath.Join(parent, "."+name+"."+urlHash(urlStr)+".part")
+}
+
+// partialSizeはpartPathの途中までのファイルのサイズを返します。ない場合は0を返します。
+func partialSize(partPath string) int64 {
+	info, err := os.Stat(partPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// acceptsRangesはサーバーがRangeリクエストに対応している（Accept-Ranges: bytesを返した）かどうかを返します。
+func acceptsRanges(resp *http.Response) bool {
+	for _, v := range resp.Header.Values("Accept-Ranges") {
+		for _, unit := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contentRangeStartはContent-Rangeヘッダ（"bytes 100-199/200"）の開始位置を返します。不明な場合は-1を返します。
+func contentRangeStart(header string) int64 {
+	unit, spec, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(unit, "bytes") {
+		return -1
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// writeFilePartialはrの内容をpartPathの途中までのファイルに書き込み（resumeの場合は末尾に追加し、そうでなければ最初から書き込み）、
+// 全て書き込めた場合のみfilePathにリネームします。書き込んだバイト数を返します。
+// 途中で失敗した場合はpartPathを残し、次の試行でRangeリクエストで続きから取得できるようにします。
+func writeFilePartial(filePath, partPath string, r io.Reader, resume bool) (n int64, err error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flag = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(partPath, flag, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Rename(partPath, filePath)
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resumeTestBodyはRangeリクエストのテストでサーバーが返すファイルの内容です。
+const resumeTestBody = "0123456789abcdefghij"
+
+// TestDownloadFileResumeは途中までのファイルがある場合にRangeリクエストを送り、206なら続きを追加し、
+// 200なら最初から保存し直すことを確認します。
+func TestDownloadFileResume(t *testing.T) {
+	var ranges atomic.Value
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ranges/a.png", func(w http.ResponseWriter, r *http.Request) {
+		ranges.Store(r.Header.Get("Range"))
+		// ServeContentはAccept-Rangesを返し、Rangeリクエストに206で応答する
+		http.ServeContent(w, r, "a.png", time.Time{}, strings.NewReader(resumeTestBody))
+	})
+	mux.HandleFunc("/norange/a.png", func(w http.ResponseWriter, r *http.Request) {
+		ranges.Store(r.Header.Get("Range"))
+		w.Write([]byte(resumeTestBody))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		partial   string
+		wantRange string
+	}{
+		{"206で続きを追加", "/ranges/a.png", "0123456789", "bytes=10-"},
+		{"200で最初から保存", "/norange/a.png", "0123456789", "bytes=10-"},
+		{"サーバーのファイルより大きい場合は416で最初から保存", "/ranges/a.png", resumeTestBody + "extra", ""},
+		{"途中までのファイルなし", "/ranges/a.png", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DownloadOptions{OutDir: t.TempDir()}
+			urlStr := srv.URL + tt.path
+			partPath := partialPath(opts.OutDir, "a.png", urlStr)
+			if tt.partial != "" {
+				if err := os.WriteFile(partPath, []byte(tt.partial), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			result, err := DownloadFile(context.Background(), srv.Client(), urlStr, "a.png", opts)
+			if err != nil {
+				t.Fatalf("DownloadFile: %v", err)
+			}
+			if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != resumeTestBody || result.Size != int64(len(resumeTestBody)) {
+				t.Errorf("内容 = %q、サイズ = %d", got, result.Size)
+			}
+			// 続きから再開した場合も、ファイル全体のSHA-256にする
+			if sum := sha256.Sum256([]byte(resumeTestBody)); result.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("SHA256 = %s", result.SHA256)
+			}
+			if got := ranges.Load(); got != tt.wantRange {
+				t.Errorf("最後のリクエストのRange = %q, want %q", got, tt.wantRange)
+			}
+			if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+				t.Errorf("途中までのファイルが残っています: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloadFileResumeAfterTruncationは接続が途中で切れた場合に途中までのファイルを残し、
+// 再試行では続きだけを取得することを確認します。
+func TestDownloadFileResumeAfterTruncation(t *testing.T) {
+	var requests atomic.Int32
+	var lastRange atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastRange.Store(r.Header.Get("Range"))
+		if requests.Add(1) > 1 {
+			http.ServeContent(w, r, "a.png", time.Time{}, strings.NewReader(resumeTestBody))
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nAccept-Ranges: bytes\r\nContent-Length: 20\r\n\r\n01234567")
+		buf.Flush()
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), Retry: RetryPolicy{Retries: 1, Wait: time.Millisecond}}
+	if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "a.png")); got != resumeTestBody {
+		t.Errorf("内容 = %q", got)
+	}
+	if got := lastRange.Load(); got != "bytes=8-" {
+		t.Errorf("再試行のRange = %q, want %q", got, "bytes=8-")
+	}
+}
+
+// TestContentRangeStartはContent-Rangeヘッダの開始位置を返すことを確認します。
+func TestContentRangeStart(t *testing.T) {
+	for in, want := range map[string]int64{
+		"bytes 10-19/20": 10,
+		"bytes 0-0/*":    0,
+		"bytes */20":     -1,
+		"items 10-19/20": -1,
+		"":               -1,
+	} {
+		if got := contentRangeStart(in); got != want {
+			t.Errorf("contentRangeStart(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RobotsAgentはrobots.txtのUser-agentの行で、このツール専用の規則を選ぶための名前です。
+// この名前のグループがない場合は"*"のグループの規則に従います。
+const RobotsAgent = "go_download_attachment"
+
+// maxRobotsSizeは読み込むrobots.txtの大きさの上限です（RFC 9309で求められている500KiB）。
+const maxRobotsSize = 500 << 10
+
+// robotsRuleはrobots.txtのAllowかDisallowの1行です。
+type robotsRule struct {
+	pattern string         // パスのパターン（長さで優先度を決める）
+	re      *regexp.Regexp // patternの"*"と末尾の"$"を正規表現にしたもの
+	allow   bool
+}
+
+// robotsRulesはrobots.txtのうち、このツールに適用される規則です。
+type robotsRules []robotsRule
+
+// parseRobotsはrobots.txtを解析し、agentに適用される規則を返します（RFC 9309のUser-agent・Allow・Disallowのみに対応）。
+// agentの名前（大文字と小文字は区別しない）のグループがあればその規則を、なければ"*"のグループの規則を使います。
+func parseRobots(r io.Reader, agent string) robotsRules {
+	agent = strings.ToLower(agent)
+	var specific, wildcard robotsRules
+	var matchSpecific, matchWildcard, inRules bool
+	scanner := bufio.NewScanner(io.LimitReader(r, maxRobotsSize))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			// 規則の後のUser-agentは新しいグループの始まり
+			if inRules {
+				matchSpecific, matchWildcard, inRules = false, false, false
+			}
+			name := strings.ToLower(value)
+			matchSpecific = matchSpecific || name == agent
+			matchWildcard = matchWildcard || name == "*"
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				// 空のDisallowは全て許可（規則なし）と同じ
+				continue
+			}
+			rule := robotsRule{pattern: value, re: robotsPattern(value), allow: key == "allow"}
+			if matchSpecific {
+				specific = append(specific, rule)
+			}
+			if matchWildcard {
+				wildcard = append(wildcard, rule)
+			}
+		}
+	}
+	if specific != nil {
+		return specific
+	}
+	return wildcard
+}
+
+// robotsPatternはrobots.txtのパスのパターンを、パスの先頭から一致する正規表現にします。
+// "*"は任意の文字列に、末尾の"$"はパスの終わりに一致します。
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allowedはpath（クエリを含むエスケープされたパス）にアクセスしてよいかどうかを返します。
+// 一致する規則のうちパターンが最も長いものに従い、同じ長さのAllowとDisallowがあればAllowを優先します。
+func (rules robotsRules) allowed(path string) bool {
+	best, allow := -1, true
+	for _, rule := range rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+			best, allow = n, rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsCacheは実行中に取得したホストごとのrobots.txtの規則です。複数のゴルーチンから同時に使えます。
+type robotsCache struct {
+	mu    sync.Mutex
+	hosts map[string]*robotsHost // "scheme://host" → 規則
+}
+
+// robotsHostは1つのホストのrobots.txtの規則です。
+// 取得中はmuを持ち続けるため、同じホストでは1回だけ取得し、別のホストの確認は待たせません。
+type robotsHost struct {
+	mu      sync.Mutex
+	rules   robotsRules
+	fetched bool
+}
+
+// newRobotsCacheは空のrobotsCacheを作成します。
+func newRobotsCache() *robotsCache {
+	return &robotsCache{hosts: make(map[string]*robotsHost)}
+}
+
+// allowedはurlStrにアクセスしてよいかどうかを、そのホストのrobots.txtで判断します。
+// robots.txtはホストごとに初回だけclientで取得します。取得できない場合（404やネットワークエラー）は全て許可します。
+// HTTP(S)以外のURL（data: URIなど）は常に許可します。
+func (c *robotsCache) allowed(ctx context.Context, client *http.Client, urlStr string, opts DownloadOptions) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return true
+	}
+	origin := u.Scheme + "://" + u.Host
+	c.mu.Lock()
+	host, ok := c.hosts[origin]
+	if !ok {
+		host = new(robotsHost)
+		c.hosts[origin] = host
+	}
+	c.mu.Unlock()
+
+	host.mu.Lock()
+	rules := host.rules
+	if !host.fetched {
+		rules = fetchRobots(ctx, client, origin, opts)
+		// 中断された場合は次に取得し直す
+		if ctx.Err() == nil {
+			host.rules, host.fetched = rules, true
+		}
+	}
+	host.mu.Unlock()
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return rules.allowed(path)
+}
+
+// fetchRobotsはoriginのrobots.txtを取得して解析します。取得できない場合は規則なし（全て許可）を返します。
+func fetchRobots(ctx context.Context, client *http.Client, origin string, opts DownloadOptions) robotsRules {
+	robotsURL := origin + "/robots.txt"
+	opts.Referer = ""
+	resp, err := getWithRetry(ctx, client, robotsURL, nil, opts)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("robots.txtを取得できないため、全てのパスを許可します", "url", robotsURL, "error", err)
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Debug("robots.txtがないため、全てのパスを許可します", "url", robotsURL, "status", resp.Status)
+		return nil
+	}
+	rules := parseRobots(resp.Body, RobotsAgent)
+	slog.Debug("robots.txtを読み込みました", "url", robotsURL, "rules", len(rules))
+	return rules
+}
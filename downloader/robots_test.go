@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRobotsAllowedは許可・禁止されたパスの判定（最も長い規則の優先、ワイルドカード、末尾の$、Agentごとのグループ）を確認します。
+func TestRobotsAllowed(t *testing.T) {
+	const robots = `# コメント
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /tmp/
+
+User-agent: other-bot
+Disallow: /
+`
+	rules := parseRobots(strings.NewReader(robots), RobotsAgent)
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/docs/page", true},
+		{"/private", false},
+		{"/private/secret", false},
+		{"/privateer", false}, // パスの接頭辞で一致する
+		{"/private/public/a.png", true},
+		{"/attachment/a.pdf", false},
+		{"/attachment/a.pdf?download=1", true}, // "$"はパスの終わりにのみ一致する
+		{"/tmp", true},
+		{"/tmp/a", false},
+	}
+	for _, tt := range tests {
+		if got := rules.allowed(tt.path); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// このツール専用のグループがある場合は"*"のグループを使わない
+	specific := parseRobots(strings.NewReader("User-agent: *\nDisallow: /\n\nUser-agent: Go_Download_Attachment\nUser-agent: another\nDisallow: /admin\n"), RobotsAgent)
+	if !specific.allowed("/docs") || specific.allowed("/admin/users") {
+		t.Errorf("専用のグループの規則になっていません: %+v", specific)
+	}
+	// 空のDisallowは全て許可
+	if empty := parseRobots(strings.NewReader("User-agent: *\nDisallow:\n"), RobotsAgent); !empty.allowed("/any") {
+		t.Error("空のDisallowで禁止されました")
+	}
+}
+
+// TestDownloadRespectRobotsはrobots.txtで禁止されたページを読み込まずにErrRobotsDisallowedを返し、禁止された画像をダウンロードしないことと、
+// robots.txtをホストごとに1回だけ取得することを確認します。
+func TestDownloadRespectRobots(t *testing.T) {
+	var robotsRequests atomic.Int32
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			robotsRequests.Add(1)
+			w.Write([]byte("User-agent: *\nDisallow: /secret\nDisallow: /attachment/private\n"))
+			return
+		case "/page", "/secret/page":
+			w.Write([]byte(`<img src="/attachment/a.png"><img src="/attachment/private/b.png">`))
+		default:
+			w.Write([]byte("png"))
+		}
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	for _, respect := range []bool{true, false} {
+		paths = nil
+		opts := DefaultOptions()
+		opts.OutDir = t.TempDir()
+		opts.NoBrowser = true
+		opts.Concurrency = 1
+		opts.RespectRobots = respect
+		d, err := New(opts)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer d.Close()
+		for _, page := range []string{"/page", "/secret/page"} {
+			_, err := d.Download(context.Background(), srv.URL+page)
+			if respect && page == "/secret/page" {
+				if !errors.Is(err, ErrRobotsDisallowed) {
+					t.Errorf("Download(%s) = %v, want %v", page, err, ErrRobotsDisallowed)
+				}
+			} else if err != nil {
+				t.Fatalf("Download(%s): %v", page, err)
+			}
+		}
+		want := []string{"/page", "/attachment/a.png"}
+		if !respect {
+			want = []string{"/page", "/attachment/a.png", "/attachment/private/b.png", "/secret/page", "/attachment/a.png", "/attachment/private/b.png"}
+		}
+		if !reflect.DeepEqual(paths, want) {
+			t.Errorf("RespectRobots=%v: リクエストしたパス = %q, want %q", respect, paths, want)
+		}
+	}
+	if n := robotsRequests.Load(); n != 1 {
+		t.Errorf("robots.txtの取得回数 = %d, want 1", n)
+	}
+}
+
+// TestRobotsCacheSlowHostはrobots.txtの取得に時間がかかるホストがあっても、別のホストの確認を待たせないことを確認します。
+func TestRobotsCacheSlowHost(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("User-agent: *\nDisallow: /\n"))
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /secret\n"))
+	}))
+	defer fast.Close()
+
+	c := newRobotsCache()
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		c.allowed(context.Background(), http.DefaultClient, slow.URL+"/a.png", DownloadOptions{})
+	}()
+	<-started
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan bool)
+	go func() {
+		done <- c.allowed(context.Background(), http.DefaultClient, fast.URL+"/secret/a.png", DownloadOptions{})
+	}()
+	select {
+	case allowed := <-done:
+		if allowed {
+			t.Error("robots.txtで禁止されたURLが許可されました")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("別のホストのrobots.txtの取得を待っています")
+	}
+}
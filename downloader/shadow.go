@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/chromedp"
+)
+
+// extractShadowImagesJSはopenのshadow root（入れ子も含む）内のselectorに一致する全要素の属性を取得するJavaScriptを返します。
+// document.querySelectorAllはshadow rootの中を探さないため、Web Componentsが描画した画像はこれで抽出します。
+// closedのshadow rootはJavaScriptから読めないため含みません（countClosedShadowRootsで数えて警告します）。
+func extractShadowImagesJS(selector, attr string) string {
+	return `(() => {
+	const toSource = ` + imageSourceJS(attr) + `;
+	const images = [];
+	const walk = root => {
+		for (const el of root.querySelectorAll("*")) {
+			if (!el.shadowRoot) {
+				continue;
+			}
+			for (const img of el.shadowRoot.querySelectorAll(` + jsString(selector) + `)) {
+				images.push(toSource(img));
+			}
+			walk(el.shadowRoot);
+		}
+	};
+	walk(document);
+	return images;
+})()`
+}
+
+// extractShadowImagesはctxのタブに表示したページのopenのshadow root内の画像の属性を取得します。
+// closedのshadow rootがある場合は、その中の画像を抽出できないことをログに出力します。
+func extractShadowImages(ctx context.Context, opts ExtractOptions) ([]ImageSource, error) {
+	var images []ImageSource
+	if err := chromedp.Run(ctx, chromedp.Evaluate(extractShadowImagesJS(opts.selector(), opts.attr()), &images)); err != nil {
+		return nil, err
+	}
+	closed, err := countClosedShadowRoots(ctx)
+	switch {
+	case err != nil:
+		slog.Debug("closedのshadow rootを数えられませんでした", "error", err)
+	case closed > 0:
+		slog.Warn("closedのshadow rootの中は読めないため、画像を抽出できません", "closed_shadow_roots", closed)
+	}
+	return images, nil
+}
+
+// countClosedShadowRootsはctxのタブに表示したページのclosedのshadow rootの数を返します。
+// DevToolsのDOMドメインはJavaScriptと異なり、closedのshadow rootも返します。
+func countClosedShadowRoots(ctx context.Context) (int, error) {
+	var root *cdp.Node
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		root, err = dom.GetDocument().WithDepth(-1).WithPierce(true).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	var walk func(n *cdp.Node)
+	walk = func(n *cdp.Node) {
+		if n == nil {
+			return
+		}
+		if n.ShadowRootType == cdp.ShadowRootTypeClosed {
+			count++
+		}
+		for _, c := range n.ShadowRoots {
+			walk(c)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+		walk(n.ContentDocument)
+		walk(n.TemplateContent)
+	}
+	walk(root)
+	return count, nil
+}
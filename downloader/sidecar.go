@@ -0,0 +1,19 @@
+package downloader
+
+import (
+	"strings"
+)
+
+// altSidecarExtはalt属性を書き込むファイルの、画像のファイル名に付ける拡張子です（image.png → image.png.txt）。
+const altSidecarExt = ".txt"
+
+// writeAltSidecarはfilePathの画像のalt属性をfilePath+".txt"に書き込みます。
+// 前後の空白を除いたaltが空の場合は書き込みません（装飾用の画像はalt=""にするため）。
+func writeAltSidecar(filePath, alt string) error {
+	alt = strings.TrimSpace(alt)
+	if alt == "" {
+		return nil
+	}
+	_, err := writeFileAtomic(filePath+altSidecarExt, strings.NewReader(alt+"\n"))
+	return err
+}
@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDownloadSidecarAltはalt属性が空でない画像にのみ、alt属性を書き込んだ"<ファイル名>.txt"を作成することを確認します。
+func TestDownloadSidecarAlt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/arch.png" alt=" システム構成図 " title="構成">` +
+				`<img src="/spacer.png" alt="">` +
+				`<img src="/blank.png" alt="   ">` +
+				`<img src="/noalt.png">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.Download.SidecarAlt = true
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	results, err := d.Download(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "arch.png.txt")); got != "システム構成図\n" {
+		t.Errorf("arch.png.txt = %q", got)
+	}
+	for _, name := range []string{"spacer.png", "blank.png", "noalt.png"} {
+		if _, err := os.Stat(filepath.Join(opts.OutDir, name+".txt")); !os.IsNotExist(err) {
+			t.Errorf("altが空の%sのテキストファイルを作成しました: %v", name, err)
+		}
+	}
+	if results[0].Alt != " システム構成図 " || results[0].Title != "構成" {
+		t.Errorf("Alt, Title = %q, %q", results[0].Alt, results[0].Title)
+	}
+}
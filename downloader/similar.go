@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"image"
+	"image/color"
+	"log/slog"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// DefaultSimilarThresholdはFindSimilarで見た目が似ているとみなす、64ビットのハッシュのハミング距離の既定の上限です。
+const DefaultSimilarThreshold = 5
+
+// 差分ハッシュを求めるときに縮小する大きさです。横に隣り合う画素を比べるため、幅は高さより1つ大きくします。
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// dHashSamplesは縮小後の1画素の明るさを求めるときに、縦横それぞれで読む画素の数の上限です。
+// 大きな画像でも全ての画素を読まずに済むよう、間引いて平均します。
+const dHashSamples = 16
+
+// FindSimilarはresultsの保存した画像のうち、先に保存した画像と見た目が似ている（差分ハッシュのハミング距離がthreshold以下の）
+// 画像を探し、その画像の結果のSimilarToに先の画像のOutDirからの相対パスを記録して、見つけた件数を返します。
+// 圧縮率を変えて書き出し直した画像などを見つけるためのもので、ファイルは削除も置き換えもしません。
+// DedupContentでリンクに置き換えた画像は対象にしません。
+func (d *Downloader) FindSimilar(results []Result, threshold int) int {
+	type hashedImage struct {
+		path string
+		hash uint64
+	}
+	var seen []hashedImage
+	found := 0
+	for i := range results {
+		r := &results[i]
+		if !r.Success || r.Filtered || r.File == "" || r.DuplicateOf != "" || !isImageFile(r.File) {
+			continue
+		}
+		rel := d.savedPath(*r)
+		hash, err := imageHash(filepath.Join(d.opts.OutDir, rel))
+		if err != nil {
+			slog.Debug("画像をデコードできないため、似ている画像の確認から除外します", "file", rel, "error", err)
+			continue
+		}
+		best, bestDistance := "", threshold+1
+		for _, s := range seen {
+			if s.path == rel {
+				continue
+			}
+			if distance := bits.OnesCount64(s.hash ^ hash); distance < bestDistance {
+				best, bestDistance = s.path, distance
+			}
+		}
+		seen = append(seen, hashedImage{path: rel, hash: hash})
+		if best == "" {
+			continue
+		}
+		slog.Info("見た目が似ている画像が見つかりました", "file", rel, "similar_to", best, "distance", bestDistance)
+		r.SimilarTo = best
+		found++
+	}
+	return found
+}
+
+// imageHashはfilePathの画像をデコードし、dHashで差分ハッシュを求めます。
+func imageHash(filePath string) (uint64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+	return dHash(img), nil
+}
+
+// dHashはimgの差分ハッシュ（difference hash）を返します。
+// 画像をグレースケールで9x8画素に縮小し、各行の隣り合う画素で右の方が明るければ1とした64ビットの値です。
+// 明るさの相対的な変化だけを使うため、圧縮率や大きさを変えただけの画像はハミング距離が小さくなります。
+func dHash(img image.Image) uint64 {
+	b := img.Bounds()
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/dHashHeight, b.Min.Y+(y+1)*b.Dy()/dHashHeight
+		prev := 0
+		for x := 0; x < dHashWidth; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/dHashWidth, b.Min.X+(x+1)*b.Dx()/dHashWidth
+			gray := averageGray(img, image.Rect(x0, y0, x1, y1))
+			if x > 0 {
+				hash <<= 1
+				if gray > prev {
+					hash |= 1
+				}
+			}
+			prev = gray
+		}
+	}
+	return hash
+}
+
+// averageGrayはimgのrの範囲の明るさ（0～65535）の平均を返します。範囲が空の場合は左上の1画素を使います。
+// 各方向でdHashSamples個を超える画素は間引いて読みます。
+func averageGray(img image.Image, r image.Rectangle) int {
+	if r.Dx() < 1 {
+		r.Max.X = r.Min.X + 1
+	}
+	if r.Dy() < 1 {
+		r.Max.Y = r.Min.Y + 1
+	}
+	stepX, stepY := max(r.Dx()/dHashSamples, 1), max(r.Dy()/dHashSamples, 1)
+	sum, n := 0, 0
+	for y := r.Min.Y; y < r.Max.Y; y += stepY {
+		for x := r.Min.X; x < r.Max.X; x += stepX {
+			sum += int(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+			n++
+		}
+	}
+	return sum / n
+}
@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDiagramは左から右へ明るくなる背景に、暗い円を描いた画像を返します。mirrorがtrueの場合は左右を反転します。
+func testDiagram(w, h int, mirror bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			px := x
+			if mirror {
+				px = w - 1 - x
+			}
+			v := uint8(px * 255 / w)
+			if dx, dy := px-w/3, y-h/2; dx*dx+dy*dy < (h/4)*(h/4) {
+				v /= 4
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+// TestDHashは左から右へ明るくなる画像の差分ハッシュが全て1になることと、
+// 同じ画像をJPEGで圧縮し直したものや縮小したものとのハミング距離が小さく、左右を反転した画像とは大きいことを確認します。
+func TestDHash(t *testing.T) {
+	gradient := image.NewGray(image.Rect(0, 0, 90, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 90; x++ {
+			gradient.SetGray(x, y, color.Gray{uint8(x * 2)})
+		}
+	}
+	if got := dHash(gradient); got != ^uint64(0) {
+		t.Errorf("dHash(グラデーション) = %016x, want ffffffffffffffff", got)
+	}
+
+	original := testDiagram(400, 300, false)
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, original, &jpeg.Options{Quality: 30}); err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := jpeg.Decode(&encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := dHash(original)
+	tests := []struct {
+		name    string
+		img     image.Image
+		similar bool
+	}{
+		{"JPEGで圧縮し直した画像", reencoded, true},
+		{"縮小した画像", testDiagram(200, 150, false), true},
+		{"左右を反転した画像", testDiagram(400, 300, true), false},
+	}
+	for _, tt := range tests {
+		distance := bits.OnesCount64(hash ^ dHash(tt.img))
+		if (distance <= DefaultSimilarThreshold) != tt.similar {
+			t.Errorf("%s: ハミング距離 = %d, 似ているか = %v", tt.name, distance, tt.similar)
+		}
+	}
+}
+
+// TestFindSimilarは保存した画像のうち、先の画像と見た目が似ている画像にのみSimilarToを記録することを確認します。
+func TestFindSimilar(t *testing.T) {
+	dir := t.TempDir()
+	writeImage := func(name string, img image.Image, asJPEG bool) {
+		var buf bytes.Buffer
+		var err error
+		if asJPEG {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 40})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeImage("diagram.png", testDiagram(400, 300, false), false)
+	writeImage("mirrored.png", testDiagram(400, 300, true), false)
+	writeImage("diagram-export.jpg", testDiagram(400, 300, false), true)
+	if err := os.WriteFile(filepath.Join(dir, "broken.png"), []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.OutDir = dir
+	opts.NoBrowser = true
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	results := []Result{
+		{File: "diagram.png", Success: true},
+		{File: "mirrored.png", Success: true},
+		{File: "broken.png", Success: true},
+		{File: "diagram-export.jpg", Success: true},
+	}
+	if n := d.FindSimilar(results, DefaultSimilarThreshold); n != 1 {
+		t.Errorf("見つけた件数 = %d, want 1", n)
+	}
+	want := map[string]string{"diagram-export.jpg": "diagram.png"}
+	for _, r := range results {
+		if r.SimilarTo != want[r.File] {
+			t.Errorf("%s: SimilarTo = %q, want %q", r.File, r.SimilarTo, want[r.File])
+		}
+	}
+}
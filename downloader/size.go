@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnitsはParseSizeとFormatSizeで使う単位です（1KB = 1024バイト）。
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+}
+
+// ParseSizeは"50MB"や"1.5G"、"1024"のような人が読む形式のサイズをバイト数に変換します。
+// 単位はB、KB、MB、GB、TB（1KB = 1024バイト）で、大文字と小文字を区別せず、"B"と"iB"は省略できます。
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if prefix := u.suffix[:1]; strings.HasSuffix(value, prefix) {
+			value, unit = strings.TrimSuffix(value, prefix), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || n*float64(unit) >= math.MaxInt64 {
+		return 0, fmt.Errorf("サイズの形式が正しくありません: %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// FormatSizeはバイト数nを"1.5 MB"のような人が読む形式にします。
+func FormatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f %s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// errTooLargeはダウンロード中のファイルがDownloadOptions.MaxSizeを超えたことを表します。
+var errTooLarge = errors.New("ファイルのサイズが上限を超えました")
+
+// maxSizeReaderはmaxバイトを超えて読み込んだ場合にerrTooLargeを返すio.Readerです。
+// Content-Lengthのないレスポンスでもサイズの上限を守るために使います。
+type maxSizeReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.read > r.max {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseSizeは単位付きのサイズをバイト数に変換できることを確認します。
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"10B", 10, false},
+		{"50MB", 50 << 20, false},
+		{"50m", 50 << 20, false},
+		{"1.5GB", 3 << 29, false},
+		{"2KiB", 2048, false},
+		{" 1 TB ", 1 << 40, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+		{"10XB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestFormatSizeはバイト数を単位付きの形式にできることを確認します。
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KB"},
+		{50 << 20, "50.0 MB"},
+		{3 << 29, "1.5 GB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.in); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestDownloadFileMaxSizeはContent-Lengthと実際に読み込んだサイズの両方で、上限を超えるファイルを保存しないことを確認します。
+func TestDownloadFileMaxSize(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/chunked.png" {
+			// Flushすると以降はchunked転送になり、Content-Lengthが送られない
+			w.Write([]byte(body[:10]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[10:]))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		maxSize  int64
+		wantSave bool
+	}{
+		{"length.png", 50, false},
+		{"chunked.png", 50, false},
+		{"length.png", 100, true},
+		{"chunked.png", 100, true},
+		{"chunked.png", 0, true},
+	}
+	for _, tt := range tests {
+		opts := DownloadOptions{OutDir: t.TempDir(), MaxSize: tt.maxSize}
+		result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/"+tt.name, tt.name, opts)
+		if err != nil {
+			t.Fatalf("%s (max %d): DownloadFile: %v", tt.name, tt.maxSize, err)
+		}
+		entries, _ := os.ReadDir(opts.OutDir)
+		if tt.wantSave {
+			if result.Skipped || readFile(t, filepath.Join(opts.OutDir, tt.name)) != body {
+				t.Errorf("%s (max %d): 上限以下のファイルが保存されていません", tt.name, tt.maxSize)
+			}
+			continue
+		}
+		if !result.Skipped || !result.Filtered {
+			t.Errorf("%s (max %d): Skipped = %v, Filtered = %v, want true", tt.name, tt.maxSize, result.Skipped, result.Filtered)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%s (max %d): 上限を超えるファイル（一時ファイルを含む）が残っています: %v", tt.name, tt.maxSize, entries)
+		}
+	}
+}
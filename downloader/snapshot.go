@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// DefaultScreenshotQualityはJPEGで保存するスクリーンショットの既定の品質です。
+const DefaultScreenshotQuality = 90
+
+// ScreenshotOptionsはChromeで表示したページ全体のスクリーンショットの設定です。
+type ScreenshotOptions struct {
+	Path    string // 保存先のパス（空の場合は保存しない。拡張子が.jpgか.jpegの場合はJPEG、それ以外はPNG）
+	Quality int    // JPEGの品質（1〜100。PNGの場合は使わない）
+}
+
+// isJPEGはスクリーンショットをJPEGで保存するかどうかを返します。
+func (o ScreenshotOptions) isJPEG() bool {
+	ext := strings.ToLower(filepath.Ext(o.Path))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+// validateはスクリーンショットの設定が正しいかどうかを確認します。
+func (o ScreenshotOptions) validate() error {
+	if o.Path != "" && o.isJPEG() && (o.Quality < 1 || o.Quality > 100) {
+		return fmt.Errorf("スクリーンショットの品質は1〜100で指定してください: %d", o.Quality)
+	}
+	return nil
+}
+
+// DefaultPDFPaperはPDFの既定の用紙の大きさです。
+const DefaultPDFPaper = "A4"
+
+// DefaultPDFMarginはPDFの既定の余白（ミリメートル）です。
+const DefaultPDFMargin = 10
+
+// pdfPapersはPDFの用紙の大きさの名前（小文字）と、幅と高さ（インチ）です。
+var pdfPapers = map[string][2]float64{
+	"a3":      {11.69, 16.54},
+	"a4":      {8.27, 11.69},
+	"a5":      {5.83, 8.27},
+	"b4":      {10.12, 14.33},
+	"b5":      {7.17, 10.12},
+	"letter":  {8.5, 11},
+	"legal":   {8.5, 14},
+	"tabloid": {11, 17},
+}
+
+// PDFOptionsはChromeで表示したページをPDFとして保存する設定です。
+type PDFOptions struct {
+	Path       string  // 保存先のパス（空の場合は保存しない）
+	Paper      string  // 用紙の大きさ（"A4"、"B5"（JIS）、"Letter"など。大文字と小文字は区別しない。空の場合はDefaultPDFPaper）
+	Margin     float64 // 上下左右の余白（ミリメートル）
+	Background bool    // 背景色と背景画像も印刷する
+	Landscape  bool    // 用紙を横向きにする
+}
+
+// paperSizeは用紙の幅と高さ（インチ）を返します。
+func (o PDFOptions) paperSize() ([2]float64, bool) {
+	paper := o.Paper
+	if paper == "" {
+		paper = DefaultPDFPaper
+	}
+	size, ok := pdfPapers[strings.ToLower(paper)]
+	return size, ok
+}
+
+// validateはPDFの設定が正しいかどうかを確認します。
+func (o PDFOptions) validate() error {
+	if o.Path == "" {
+		return nil
+	}
+	if _, ok := o.paperSize(); !ok {
+		return fmt.Errorf("PDFの用紙の大きさが不明です（A3、A4、A5、B4、B5、Letter、Legal、Tabloid）: %q", o.Paper)
+	}
+	if o.Margin < 0 {
+		return fmt.Errorf("PDFの余白は0以上で指定してください: %g", o.Margin)
+	}
+	return nil
+}
+
+// saveSnapshotsはctxのタブに表示したpageURLのページのスクリーンショットとPDFを保存します（指定したもののみ）。
+// 複数のページを処理する場合、2ページ目以降は"page (1).png"のように保存先のパスに番号を付けます。
+// 保存に失敗してもページの画像のダウンロードは続けるため、エラーはログに出力するだけです。
+func (d *Downloader) saveSnapshots(ctx context.Context, pageURL string) {
+	opts := &d.opts
+	if (opts.Screenshot.Path == "" && opts.PDF.Path == "") || opts.DryRun || opts.List {
+		return
+	}
+	d.mu.Lock()
+	index := d.snapshots
+	d.snapshots++
+	d.mu.Unlock()
+
+	if opts.Screenshot.Path != "" {
+		filePath := numberedName(opts.Screenshot.Path, index)
+		if err := captureScreenshot(ctx, filePath, opts.Screenshot); err != nil {
+			if ctx.Err() == nil {
+				slog.Error("スクリーンショットの保存に失敗しました", "page", pageURL, "file", filePath, "error", err)
+			}
+		} else {
+			slog.Info("スクリーンショットを保存しました", "page", pageURL, "file", filePath)
+		}
+	}
+	if opts.PDF.Path != "" {
+		filePath := numberedName(opts.PDF.Path, index)
+		if err := printPDF(ctx, filePath, opts.PDF); err != nil {
+			if ctx.Err() == nil {
+				slog.Error("PDFの保存に失敗しました", "page", pageURL, "file", filePath, "error", err)
+			}
+		} else {
+			slog.Info("PDFを保存しました", "page", pageURL, "file", filePath)
+		}
+	}
+}
+
+// captureScreenshotはctxのタブに表示したページ全体（画面の外を含む）のスクリーンショットをfilePathに保存します。
+func captureScreenshot(ctx context.Context, filePath string, opts ScreenshotOptions) error {
+	// FullScreenshotは品質が100の場合にPNG、それ以外はJPEGで撮る
+	quality := 100
+	if opts.isJPEG() {
+		quality = min(opts.Quality, 99)
+	}
+	var buf []byte
+	if err := chromedp.Run(ctx, chromedp.FullScreenshot(&buf, quality)); err != nil {
+		return err
+	}
+	return writeSnapshot(filePath, buf)
+}
+
+// printPDFはctxのタブに表示したページを印刷したPDFをfilePathに保存します。
+// Chromeはヘッドレスモードの場合のみPDFを作成できます。
+func printPDF(ctx context.Context, filePath string, opts PDFOptions) error {
+	size, ok := opts.paperSize()
+	if !ok {
+		return fmt.Errorf("PDFの用紙の大きさが不明です: %q", opts.Paper)
+	}
+	margin := opts.Margin / 25.4
+	var buf []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		// DevToolsはPDFをbase64で返すが、cdprotoがデコードしたものを返す
+		var err error
+		buf, _, err = page.PrintToPDF().
+			WithPaperWidth(size[0]).
+			WithPaperHeight(size[1]).
+			WithLandscape(opts.Landscape).
+			WithMarginTop(margin).
+			WithMarginBottom(margin).
+			WithMarginLeft(margin).
+			WithMarginRight(margin).
+			WithPrintBackground(opts.Background).
+			Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(filePath, buf)
+}
+
+// writeSnapshotはスクリーンショットかPDFのdataをfilePathに保存します（途中のディレクトリも作成します）。
+func writeSnapshot(filePath string, data []byte) error {
+	if dir := filepath.Dir(filePath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	_, err := writeFileAtomic(filePath, bytes.NewReader(data))
+	return err
+}
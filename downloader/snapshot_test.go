@@ -0,0 +1,41 @@
+package downloader
+
+import "testing"
+
+// TestScreenshotOptionsValidateはJPEGで保存する場合のみ品質の範囲を確認することを確認します。
+func TestScreenshotOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts    ScreenshotOptions
+		wantErr bool
+	}{
+		{ScreenshotOptions{}, false},
+		{ScreenshotOptions{Path: "page.png"}, false},
+		{ScreenshotOptions{Path: "page.jpg", Quality: 80}, false},
+		{ScreenshotOptions{Path: "page.JPEG", Quality: 0}, true},
+		{ScreenshotOptions{Path: "page.jpg", Quality: 101}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: validate() = %v, want エラー %v", tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+// TestPDFOptionsValidateは用紙の大きさを大文字と小文字を区別せずに探し、不明な用紙と負の余白をエラーにすることを確認します。
+func TestPDFOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts    PDFOptions
+		wantErr bool
+	}{
+		{PDFOptions{Paper: "B0"}, false},
+		{PDFOptions{Path: "page.pdf"}, false},
+		{PDFOptions{Path: "page.pdf", Paper: "letter", Margin: 0}, false},
+		{PDFOptions{Path: "page.pdf", Paper: "B0"}, true},
+		{PDFOptions{Path: "page.pdf", Paper: "A4", Margin: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: validate() = %v, want エラー %v", tt.opts, err, tt.wantErr)
+		}
+	}
+}
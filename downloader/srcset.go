@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"strconv"
+	"strings"
+)
+
+// srcsetCandidateはsrcset属性の1つの候補です。
+type srcsetCandidate struct {
+	url     string
+	width   int     // wディスクリプタの値（指定がなければ0）
+	density float64 // xディスクリプタの値（wディスクリプタの場合は0）
+}
+
+// parseSrcsetはsrcset属性を候補のリストに分解します。
+// ディスクリプタが不正な候補は、ブラウザと同様に無視します。
+func parseSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+	rest := srcset
+	for {
+		rest = strings.TrimLeft(rest, " \t\n\r\f,")
+		if rest == "" {
+			return candidates
+		}
+
+		// URLは次の空白まで（末尾のカンマは区切り）
+		end := strings.IndexAny(rest, " \t\n\r\f")
+		if end < 0 {
+			end = len(rest)
+		}
+		rawURL := rest[:end]
+		rest = rest[end:]
+
+		var descriptors string
+		if strings.HasSuffix(rawURL, ",") {
+			rawURL = strings.TrimRight(rawURL, ",")
+		} else {
+			// ディスクリプタは次のカンマまで（括弧内のカンマは除く）
+			depth, i := 0, 0
+		scan:
+			for ; i < len(rest); i++ {
+				switch rest[i] {
+				case '(':
+					depth++
+				case ')':
+					if depth > 0 {
+						depth--
+					}
+				case ',':
+					if depth == 0 {
+						break scan
+					}
+				}
+			}
+			descriptors = rest[:i]
+			rest = rest[i:]
+		}
+
+		if c, ok := parseSrcsetDescriptors(rawURL, descriptors); ok {
+			candidates = append(candidates, c)
+		}
+	}
+}
+
+// parseSrcsetDescriptorsは1つの候補のディスクリプタ（"640w"や"2x"）を解析します。
+func parseSrcsetDescriptors(rawURL, descriptors string) (srcsetCandidate, bool) {
+	c := srcsetCandidate{url: rawURL}
+	for _, d := range strings.Fields(descriptors) {
+		value := d[:len(d)-1]
+		switch d[len(d)-1] {
+		case 'w':
+			w, err := strconv.Atoi(value)
+			if err != nil || w <= 0 || c.width != 0 || c.density != 0 {
+				return c, false
+			}
+			c.width = w
+		case 'x':
+			x, err := strconv.ParseFloat(value, 64)
+			if err != nil || x <= 0 || c.width != 0 || c.density != 0 {
+				return c, false
+			}
+			c.density = x
+		case 'h':
+			// 高さのディスクリプタは候補の選択には使わない
+		default:
+			return c, false
+		}
+	}
+	if c.width == 0 && c.density == 0 {
+		c.density = 1
+	}
+	return c, true
+}
+
+// bestSrcsetCandidateはsrcset属性から最も高解像度の候補のURLを返します。
+// wディスクリプタの候補があれば最大幅のものを、なければ最大密度のものを選びます。
+// 有効な候補がなければ空文字列を返します。
+func bestSrcsetCandidate(srcset string) string {
+	var best *srcsetCandidate
+	candidates := parseSrcset(srcset)
+	for i := range candidates {
+		c := &candidates[i]
+		switch {
+		case best == nil:
+			best = c
+		case c.width != 0 || best.width != 0:
+			if c.width > best.width {
+				best = c
+			}
+		case c.density > best.density:
+			best = c
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.url
+}
+
+// srcsetCandidateForはsrcset属性から解像度densityの画面でブラウザが選ぶ候補のURLを返します。
+// xディスクリプタの候補のうちdensity以上で最も小さいもの（なければ最大密度のもの）を選びます。
+// wディスクリプタの候補はsizes属性がないと画面に合うものを決められないため、bestSrcsetCandidateと同じく最大幅のものを選びます。
+func srcsetCandidateFor(srcset string, density float64) string {
+	var best *srcsetCandidate
+	candidates := parseSrcset(srcset)
+	for i := range candidates {
+		c := &candidates[i]
+		if c.width != 0 {
+			return bestSrcsetCandidate(srcset)
+		}
+		switch {
+		case best == nil:
+			best = c
+		case best.density < density:
+			if c.density > best.density {
+				best = c
+			}
+		case c.density >= density && c.density < best.density:
+			best = c
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.url
+}
@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseSrcsetはwディスクリプタ、xディスクリプタ、不正な候補を含むsrcsetを解析できることを確認します。
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		name   string
+		srcset string
+		want   []srcsetCandidate
+	}{
+		{"空", "", nil},
+		{"wディスクリプタ", "a.png 320w, b.png 640w",
+			[]srcsetCandidate{{url: "a.png", width: 320}, {url: "b.png", width: 640}}},
+		{"xディスクリプタ", "a.png, b.png 2x,c.png 1.5x",
+			[]srcsetCandidate{{url: "a.png", density: 1}, {url: "b.png", density: 2}, {url: "c.png", density: 1.5}}},
+		{"URL末尾のカンマ", "a.png, b.png 2x",
+			[]srcsetCandidate{{url: "a.png", density: 1}, {url: "b.png", density: 2}}},
+		{"URL内のカンマ", "img.php?size=1,2 100w",
+			[]srcsetCandidate{{url: "img.php?size=1,2", width: 100}}},
+		{"不正な候補は無視", "a.png 0w, b.png abcw, c.png 2y, d.png 100w 2x, e.png 200w",
+			[]srcsetCandidate{{url: "e.png", width: 200}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSrcset(tt.srcset); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSrcset(%q) = %+v, want %+v", tt.srcset, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBestSrcsetCandidateは最大幅、なければ最大密度の候補を選ぶことを確認します。
+func TestBestSrcsetCandidate(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   string
+	}{
+		{"a.png 320w, b.png 1280w, c.png 640w", "b.png"},
+		{"a.png 1x, b.png 3x, c.png 2x", "b.png"},
+		{"a.png 3x, b.png 100w", "b.png"},
+		{"a.png 0w", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := bestSrcsetCandidate(tt.srcset); got != tt.want {
+			t.Errorf("bestSrcsetCandidate(%q) = %q, want %q", tt.srcset, got, tt.want)
+		}
+	}
+}
+
+// TestSrcsetCandidateForは画面の解像度以上で最も小さい密度の候補を選び、なければ最大密度の候補を選ぶことを確認します。
+func TestSrcsetCandidateFor(t *testing.T) {
+	tests := []struct {
+		srcset  string
+		density float64
+		want    string
+	}{
+		{"a.png 1x, c.png 3x, b.png 2x", 2, "b.png"},
+		{"a.png 1x, c.png 3x, b.png 2x", 1, "a.png"},
+		{"a.png, c.png 3x, b.png 2x", 1.5, "b.png"},
+		{"a.png 1x, b.png 2x", 3, "b.png"},
+		{"a.png 1x, b.png 640w, c.png 320w", 1, "b.png"},
+		{"", 2, ""},
+	}
+	for _, tt := range tests {
+		if got := srcsetCandidateFor(tt.srcset, tt.density); got != tt.want {
+			t.Errorf("srcsetCandidateFor(%q, %g) = %q, want %q", tt.srcset, tt.density, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// StateEntryは前回までの実行でダウンロードしたURL1件の記録です。
+type StateEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Path         string `json:"path"` // 保存したファイルのパス（OutDirを含む）
+}
+
+// Stateは-stateで指定したファイルに保存する、ダウンロード済みのURLの記録です。
+// 次回の実行ではETagとLast-Modifiedで条件付きリクエストを送り、変更されていないファイルのダウンロードを省略します。
+// 複数のゴルーチンから同時に使えます。
+type State struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]StateEntry // URL → 記録
+}
+
+// LoadStateはpathの状態ファイルを読み込みます。ファイルが存在しない場合は空のStateを返します。
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, entries: make(map[string]StateEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// cachedはurlStrをdirに保存した記録があり、そのファイルが残っている場合に記録を返します。
+func (s *State) cached(urlStr, dir string) (StateEntry, bool) {
+	if s == nil {
+		return StateEntry{}, false
+	}
+	s.mu.Lock()
+	entry, ok := s.entries[urlStr]
+	s.mu.Unlock()
+	// PreservePathの場合はdirのサブディレクトリに保存しているため、dir配下であればよい
+	if !ok || entry.relPath(dir) == "" {
+		return StateEntry{}, false
+	}
+	if _, err := os.Stat(entry.Path); err != nil {
+		return StateEntry{}, false
+	}
+	return entry, true
+}
+
+// recordはurlStrをfilePathに保存したことをrespのETagとLast-Modifiedとともに記録し、状態ファイルを更新します。
+// 状態ファイルを書き込めない場合は警告のみとし、ダウンロードは成功として扱います。
+func (s *State) record(urlStr, filePath string, resp *http.Response) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[urlStr] = StateEntry{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Path:         filePath,
+	}
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err == nil {
+		_, err = writeFileAtomic(s.path, bytes.NewReader(append(data, '\n')))
+	}
+	if err != nil {
+		slog.Warn("状態ファイルの書き込みに失敗しました", "file", s.path, "error", err)
+	}
+}
+
+// relPathはentryのファイルのdirからの相対パス（PreservePathの場合はサブディレクトリを含む）を返します。
+// ファイルがdir配下にない場合は空文字列を返します。
+func (entry StateEntry) relPath(dir string) string {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(entry.Path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return rel
+}
+
+// conditionalHeaderはentryの記録から条件付きリクエストのヘッダ（If-None-MatchとIf-Modified-Since）を作成します。
+func (entry StateEntry) conditionalHeader() http.Header {
+	header := make(http.Header)
+	if entry.ETag != "" {
+		header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		header.Set("If-Modified-Since", entry.LastModified)
+	}
+	return header
+}
@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestStateRoundTripは記録した内容を状態ファイルから読み込めることと、ファイルがない場合は空の状態になることを確認します。
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	s, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if _, ok := s.cached("https://growi.example.com/a.png", dir); ok {
+		t.Fatal("空の状態にURLの記録があります")
+	}
+
+	filePath := filepath.Join(dir, "a.png")
+	if err := os.WriteFile(filePath, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp := &http.Response{Header: http.Header{"Etag": {`"v1"`}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}}
+	s.record("https://growi.example.com/a.png", filePath, resp)
+
+	loaded, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	entry, ok := loaded.cached("https://growi.example.com/a.png", dir)
+	want := StateEntry{ETag: `"v1"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT", Path: filePath}
+	if !ok || entry != want {
+		t.Errorf("cached = %+v, %v, want %+v", entry, ok, want)
+	}
+	if _, ok := loaded.cached("https://growi.example.com/a.png", t.TempDir()); ok {
+		t.Error("別の保存先ディレクトリでも記録が使われました")
+	}
+	os.Remove(filePath)
+	if _, ok := loaded.cached("https://growi.example.com/a.png", dir); ok {
+		t.Error("削除されたファイルの記録が使われました")
+	}
+}
+
+// TestDownloadFileStateは記録のあるURLに条件付きリクエストを送り、304ならファイルを残してスキップし、
+// 変更されていれば同じファイルに上書きして記録を更新することを確認します。
+func TestDownloadFileState(t *testing.T) {
+	var mu sync.Mutex
+	etag, body := `"v1"`, "version 1"
+	var gotIfNoneMatch string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		gotIfNoneMatch = r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", etag)
+		if gotIfNoneMatch == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 実行ごとに状態ファイルを読み込み直す
+	download := func() DownloadResult {
+		t.Helper()
+		state, err := LoadState(statePath)
+		if err != nil {
+			t.Fatalf("LoadState: %v", err)
+		}
+		opts := DownloadOptions{OutDir: outDir, State: state, Names: NewNameRegistry()}
+		result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/a.png", "a.png", opts)
+		if err != nil {
+			t.Fatalf("DownloadFile: %v", err)
+		}
+		return result
+	}
+
+	if result := download(); result.Skipped || gotIfNoneMatch != "" {
+		t.Fatalf("初回: Skipped = %v, If-None-Match = %q", result.Skipped, gotIfNoneMatch)
+	}
+
+	result := download()
+	if !result.Skipped || result.FileName != "a.png" || gotIfNoneMatch != `"v1"` {
+		t.Errorf("変更なし: Skipped = %v, FileName = %q, If-None-Match = %q, want true, a.png, \"v1\"", result.Skipped, result.FileName, gotIfNoneMatch)
+	}
+	if got := readFile(t, filepath.Join(outDir, "a.png")); got != "version 1" {
+		t.Errorf("変更なし: 内容 = %q", got)
+	}
+
+	mu.Lock()
+	etag, body = `"v2"`, "version 2"
+	mu.Unlock()
+	if result := download(); result.Skipped || result.FileName != "a.png" {
+		t.Errorf("変更あり: Skipped = %v, FileName = %q, want false, a.png", result.Skipped, result.FileName)
+	}
+	if got := readFile(t, filepath.Join(outDir, "a.png")); got != "version 2" {
+		t.Errorf("変更あり: 内容 = %q, want %q", got, "version 2")
+	}
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := state.cached(srv.URL+"/a.png", outDir); entry.ETag != `"v2"` {
+		t.Errorf("記録されたETag = %q, want %q", entry.ETag, `"v2"`)
+	}
+}
+
+// TestDownloadPreservePathRerunはPreservePathで保存したファイルにも、次回の実行で既存のファイルと状態ファイルの記録から
+// 条件付きリクエストを送り、304ならスキップし、変更されていればサブディレクトリの同じファイルに上書きすることを確認します。
+func TestDownloadPreservePathRerun(t *testing.T) {
+	var mu sync.Mutex
+	etag, body := `"v1"`, "version 1"
+	lastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<img src="/attachment/page/a.png">`))
+	})
+	mux.HandleFunc("/attachment/page/a.png", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-None-Match") == etag || (r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") != "") {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(body))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "out")
+	statePath := filepath.Join(dir, "state.json")
+	want := filepath.Join("attachment", "page", "a.png")
+	// 実行ごとにDownloaderを作り直す（useStateの場合は状態ファイルを読み込み直す）
+	download := func(useState bool) Result {
+		t.Helper()
+		opts := DefaultOptions()
+		opts.OutDir = outDir
+		opts.NoBrowser = true
+		opts.RespectRobots = false
+		opts.PreservePath = true
+		if useState {
+			state, err := LoadState(statePath)
+			if err != nil {
+				t.Fatalf("LoadState: %v", err)
+			}
+			opts.Download.State = state
+		}
+		d, err := New(opts)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer d.Close()
+		results, err := d.Download(context.Background(), srv.URL+"/page")
+		if err != nil {
+			t.Fatalf("Download: %v", err)
+		}
+		if len(results) != 1 || !results[0].Success || results[0].File != want {
+			t.Fatalf("results = %+v, want %sの1件の成功", results, want)
+		}
+		return results[0]
+	}
+
+	if r := download(true); r.Skipped {
+		t.Fatalf("初回: Skipped = %v", r.Skipped)
+	}
+	if r := download(false); !r.Skipped {
+		t.Error("既存のファイル（If-Modified-Since）: スキップされませんでした")
+	}
+	if r := download(true); !r.Skipped {
+		t.Error("状態ファイル（If-None-Match）: スキップされませんでした")
+	}
+
+	mu.Lock()
+	etag, body = `"v2"`, "version 2"
+	mu.Unlock()
+	if r := download(true); r.Skipped {
+		t.Error("変更あり: スキップされました")
+	}
+	if got := readFile(t, filepath.Join(outDir, want)); got != "version 2" {
+		t.Errorf("変更あり: 内容 = %q, want %q", got, "version 2")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "a.png")); err == nil {
+		t.Error("サブディレクトリではなくOutDirの直下に保存されました")
+	}
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JPEGのマーカーです。
+const (
+	jpegSOI  = 0xd8 // 画像の開始
+	jpegEOI  = 0xd9 // 画像の終わり
+	jpegSOS  = 0xda // スキャンの開始（以降は圧縮された画素のデータ）
+	jpegTEM  = 0x01
+	jpegRST0 = 0xd0
+	jpegRST7 = 0xd7
+)
+
+// jpegMetadataMarkersは取り除くJPEGのセグメントのマーカーです（APP1: EXIF・XMP、APP13: IPTC、COM: コメント）。
+// APP0（JFIF）・APP2（ICCプロファイル）・APP14（Adobe）は色の解釈に使うため残します。
+var jpegMetadataMarkers = map[byte]bool{0xe1: true, 0xed: true, 0xfe: true}
+
+// pngSignatureはPNGファイルの先頭の8バイトです。
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunksは取り除くPNGのチャンクの種類です。
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// errMalformedImageはメタデータを取り除くために解析した画像が壊れていることを表します。
+var errMalformedImage = errors.New("画像の形式が正しくありません")
+
+// stripSavedはDownloadOptions.StripMetadataの場合に、filePathのJPEG・PNGからEXIF・IPTC・XMPなどのメタデータを取り除いて書き戻し、
+// resultのSizeとSHA256を更新します。画素のデータはそのまま残し、メタデータがない場合は書き戻しません。
+// 解析できない画像は警告してそのまま残します。
+func stripSaved(filePath string, result *DownloadResult, opts DownloadOptions) error {
+	if !opts.StripMetadata {
+		return nil
+	}
+	var strip func([]byte) ([]byte, error)
+	switch imageFormats[strings.ToLower(filepath.Ext(filePath))] {
+	case "jpeg":
+		strip = stripJPEG
+	case "png":
+		strip = stripPNG
+	default:
+		return nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	stripped, err := strip(data)
+	if err != nil {
+		slog.Warn("画像を解析できないため、メタデータを取り除きません", "file", result.FileName, "error", err)
+		return nil
+	}
+	if len(stripped) == len(data) {
+		return nil
+	}
+	if _, err := writeFileAtomic(filePath, bytes.NewReader(stripped)); err != nil {
+		return err
+	}
+	slog.Debug("画像のメタデータを取り除きました", "file", result.FileName, "removed", len(data)-len(stripped))
+	sum := sha256.Sum256(stripped)
+	result.Size, result.SHA256 = int64(len(stripped)), hex.EncodeToString(sum[:])
+	return nil
+}
+
+// stripJPEGはdataのJPEGからjpegMetadataMarkersのセグメントを取り除いたものを返します。
+// 最初のスキャン（SOS）以降は圧縮された画素のデータのため、そのまま残します。
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != jpegSOI {
+		return nil, errMalformedImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	pos := 2
+	for pos < len(data) {
+		start := pos
+		if data[pos] != 0xff {
+			return nil, errMalformedImage
+		}
+		// マーカーの前には0xffの詰め物を置ける
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, errMalformedImage
+		}
+		marker := data[pos]
+		pos++
+		switch {
+		case marker == jpegSOS || marker == jpegEOI:
+			return append(out, data[start:]...), nil
+		case marker == jpegTEM || (marker >= jpegRST0 && marker <= jpegRST7):
+			// 長さを持たないマーカー
+			out = append(out, data[start:pos]...)
+			continue
+		}
+		if pos+2 > len(data) {
+			return nil, errMalformedImage
+		}
+		end := pos + int(binary.BigEndian.Uint16(data[pos:]))
+		if end < pos+2 || end > len(data) {
+			return nil, errMalformedImage
+		}
+		if !jpegMetadataMarkers[marker] {
+			out = append(out, data[start:end]...)
+		}
+		pos = end
+	}
+	return nil, errMalformedImage
+}
+
+// stripPNGはdataのPNGからpngMetadataChunksのチャンクを取り除いたものを返します。
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformedImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	pos := len(pngSignature)
+	for pos < len(data) {
+		// チャンクは長さ（4バイト）・種類（4バイト）・データ・CRC（4バイト）
+		if pos+8 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end < pos || end > len(data) {
+			return nil, errMalformedImage
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		if !pngMetadataChunks[chunkType] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+		if chunkType == "IEND" {
+			return out, nil
+		}
+	}
+	return nil, errMalformedImage
+}
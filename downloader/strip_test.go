@@ -0,0 +1,132 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testEXIFはカメラのメーカー（Make）に"TestCamera"を記録したEXIFのAPP1セグメントのデータです（マーカーと長さを除く）。
+var testEXIF = []byte("Exif\x00\x00" +
+	"MM\x00\x2a\x00\x00\x00\x08" + // TIFFヘッダ（ビッグエンディアン、IFDは8バイト目から）
+	"\x00\x01" + // IFDのエントリ数
+	"\x01\x0f\x00\x02\x00\x00\x00\x0b\x00\x00\x00\x1a" + // Make、ASCII、11文字、データは26バイト目
+	"\x00\x00\x00\x00" + // 次のIFDはない
+	"TestCamera\x00")
+
+// jpegSegmentはmarkerとdataからJPEGのセグメントを作成します。
+func jpegSegment(marker byte, data []byte) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(data)+2))
+	return append(seg, data...)
+}
+
+// pngChunkはchunkTypeとdataからCRCを付けたPNGのチャンクを作成します。
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// TestStripJPEGはEXIF・XMP・コメントを含むJPEGからメタデータを取り除き、画素のデータが変わらないことを確認します。
+func TestStripJPEG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testDiagram(64, 48, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := encoded.Bytes()
+	var data []byte
+	data = append(data, plain[:2]...)
+	data = append(data, jpegSegment(0xe1, testEXIF)...)
+	data = append(data, jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPS</x:xmpmeta>"))...)
+	data = append(data, jpegSegment(0xfe, []byte("secret comment"))...)
+	data = append(data, plain[2:]...)
+	// 作成したJPEGがEXIFを含むことを確認してから取り除く
+	if !bytes.Contains(data, []byte("TestCamera")) {
+		t.Fatal("テスト用のJPEGにEXIFがありません")
+	}
+
+	stripped, err := stripJPEG(data)
+	if err != nil {
+		t.Fatalf("stripJPEG: %v", err)
+	}
+	for _, s := range []string{"Exif", "TestCamera", "xmpmeta", "secret comment"} {
+		if bytes.Contains(stripped, []byte(s)) {
+			t.Errorf("%qが残っています", s)
+		}
+	}
+	if !bytes.Equal(stripped, plain) {
+		t.Errorf("メタデータ以外のデータが変わりました（%dバイト、want %dバイト）", len(stripped), len(plain))
+	}
+	want, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("取り除いた後のJPEGをデコードできません: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("取り除いた後の画素のデータが元と異なります")
+	}
+
+	if _, err := stripJPEG([]byte("not a jpeg")); err == nil {
+		t.Error("JPEGでないデータでエラーになりません")
+	}
+}
+
+// TestDownloadFileStripMetadataはStripMetadataの場合に、ダウンロードしたJPEGとPNGのメタデータを取り除いて保存することを確認します。
+func TestDownloadFileStripMetadata(t *testing.T) {
+	var jpegData, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, testDiagram(64, 48, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, testDiagram(64, 48, false)); err != nil {
+		t.Fatal(err)
+	}
+	withEXIF := append(append(append([]byte{}, jpegData.Bytes()[:2]...), jpegSegment(0xe1, testEXIF)...), jpegData.Bytes()[2:]...)
+	// PNGはIHDRの後ろにテキストとEXIFのチャンクを入れる
+	const ihdrEnd = 8 + 12 + 13
+	p := pngData.Bytes()
+	withText := append(append([]byte{}, p[:ihdrEnd]...), pngChunk("tEXt", []byte("Author\x00alice"))...)
+	withText = append(withText, pngChunk("eXIf", testEXIF[6:])...)
+	withText = append(withText, p[ihdrEnd:]...)
+	files := map[string][]byte{"/photo.jpg": withEXIF, "/diagram.png": withText}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	opts := DownloadOptions{OutDir: t.TempDir(), StripMetadata: true, Verify: true}
+	for _, name := range []string{"photo.jpg", "diagram.png"} {
+		result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/"+name, name, opts)
+		if err != nil {
+			t.Fatalf("%s: DownloadFile: %v", name, err)
+		}
+		data := readFile(t, filepath.Join(opts.OutDir, name))
+		for _, s := range []string{"TestCamera", "alice"} {
+			if bytes.Contains([]byte(data), []byte(s)) {
+				t.Errorf("%s: %qが残っています", name, s)
+			}
+		}
+		if _, _, err := image.Decode(bytes.NewReader([]byte(data))); err != nil {
+			t.Errorf("%s: 取り除いた後の画像をデコードできません: %v", name, err)
+		}
+		if result.Size != int64(len(data)) {
+			t.Errorf("%s: Size = %d, want %d", name, result.Size, len(data))
+		}
+	}
+	if got := readFile(t, filepath.Join(opts.OutDir, "diagram.png")); got != pngData.String() {
+		t.Errorf("diagram.png: メタデータ以外のチャンクが変わりました（%dバイト、want %dバイト）", len(got), pngData.Len())
+	}
+}
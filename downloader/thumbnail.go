@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// ThumbnailDirはサムネイルを保存する、OutDir配下のサブディレクトリ名です。
+const ThumbnailDir = "thumbs"
+
+// ThumbnailSizeはサムネイルの大きさの上限です。画像の縦横比を保ったまま、この中に収まるように縮小します。
+type ThumbnailSize struct {
+	Width  int
+	Height int
+}
+
+// ParseThumbnailSizeは"200x150"のような"幅x高さ"の形式のサイズを変換します。
+func ParseThumbnailSize(s string) (ThumbnailSize, error) {
+	w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width < 1 || height < 1 {
+		return ThumbnailSize{}, fmt.Errorf("サムネイルの大きさの形式が正しくありません（例: 200x150）: %q", s)
+	}
+	return ThumbnailSize{Width: width, Height: height}, nil
+}
+
+// fitは幅w・高さhの画像を縦横比を保ったままsに収まるように縮小した大きさを返します。
+// sより小さい画像は拡大せず、元の大きさを返します。
+func (s ThumbnailSize) fit(w, h int) (int, int) {
+	if w <= s.Width && h <= s.Height {
+		return w, h
+	}
+	// 幅と高さのうち、上限に対してより大きい方に合わせる
+	if w*s.Height >= h*s.Width {
+		return s.Width, max(h*s.Width/w, 1)
+	}
+	return max(w*s.Height/h, 1), s.Height
+}
+
+// Thumbnailerは保存した画像のサムネイルを、ダウンロードとは別のゴルーチンで作成します。
+// サムネイルはroot/thumbs配下に、rootからの相対パスと同じ構成で保存します。
+// Addは待たずに戻るため、サムネイルの作成がダウンロードを遅らせることはありません。使い終わったらCloseを呼んでください。
+type Thumbnailer struct {
+	root    string
+	size    ThumbnailSize
+	mu      sync.Mutex
+	queue   []string
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+	created int
+}
+
+// NewThumbnailerはrootに保存した画像のサムネイルをsizeの大きさで作成するThumbnailerを作成し、作成用のゴルーチンを起動します。
+func NewThumbnailer(root string, size ThumbnailSize) *Thumbnailer {
+	t := &Thumbnailer{root: root, size: size, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+// AddはfilePathの画像のサムネイルの作成を予約します。
+// JPEG・PNG・GIF以外のファイルとroot配下にないファイルは無視します。tがnilかClose後の場合は何もしません。
+func (t *Thumbnailer) Add(filePath string) {
+	if t == nil {
+		return
+	}
+	if _, ok := imageFormats[strings.ToLower(filepath.Ext(filePath))]; !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.queue = append(t.queue, filePath)
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Closeは予約済みのサムネイルを全て作成してからゴルーチンを終了させ、作成したサムネイルの数を返します。
+// 複数回呼んでも構いません。tがnilの場合は0を返します。
+func (t *Thumbnailer) Close() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.wake)
+	}
+	t.mu.Unlock()
+	<-t.done
+	return t.created
+}
+
+// runは予約されたサムネイルを順に作成し、Closeされて予約がなくなったら終了します。
+func (t *Thumbnailer) run() {
+	defer close(t.done)
+	for {
+		t.mu.Lock()
+		queue := t.queue
+		t.queue = nil
+		t.mu.Unlock()
+		for _, filePath := range queue {
+			created, err := t.create(filePath)
+			switch {
+			case err != nil:
+				slog.Warn("サムネイルを作成できませんでした", "file", filePath, "error", err)
+			case created:
+				t.created++
+			}
+		}
+		if _, ok := <-t.wake; !ok {
+			// Close前に予約された分が残っていれば作成してから終了する
+			t.mu.Lock()
+			remaining := len(t.queue)
+			t.mu.Unlock()
+			if remaining == 0 {
+				return
+			}
+		}
+	}
+}
+
+// createはfilePathの画像のサムネイルを作成し、作成したかどうかを返します。
+// サムネイルが既にあり、元の画像より新しい場合は作成しません。
+// JPEGのサムネイルはJPEG、それ以外はPNGで保存します（PNG以外は元のファイル名に".png"を付けます）。
+func (t *Thumbnailer) create(filePath string) (bool, error) {
+	rel, err := filepath.Rel(t.root, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false, errors.New("保存先ディレクトリの外のファイルです")
+	}
+	ext := strings.ToLower(filepath.Ext(filePath))
+	isJPEG := imageFormats[ext] == "jpeg"
+	thumbPath := filepath.Join(t.root, ThumbnailDir, rel)
+	if !isJPEG && ext != ".png" {
+		thumbPath += ".png"
+	}
+	src, err := os.Stat(filePath)
+	if err != nil {
+		return false, err
+	}
+	if thumb, err := os.Stat(thumbPath); err == nil && !thumb.ModTime().Before(src.ModTime()) {
+		return false, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+	thumb := t.resize(img)
+	var buf bytes.Buffer
+	if isJPEG {
+		err = jpeg.Encode(&buf, thumb, nil)
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		return false, err
+	}
+	if _, err := writeFileAtomic(thumbPath, &buf); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// resizeはimgをsizeに収まるように縮小した画像を返します。
+func (t *Thumbnailer) resize(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := t.size.fit(b.Dx(), b.Dy())
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
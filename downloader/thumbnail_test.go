@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseThumbnailSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ThumbnailSize
+		wantErr bool
+	}{
+		{"200x150", ThumbnailSize{200, 150}, false},
+		{" 64X64 ", ThumbnailSize{64, 64}, false},
+		{"200", ThumbnailSize{}, true},
+		{"0x100", ThumbnailSize{}, true},
+		{"ax100", ThumbnailSize{}, true},
+		{"100x-1", ThumbnailSize{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseThumbnailSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseThumbnailSize(%q) = %v, %v, want %v (エラー: %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestThumbnailerは横長と縦長の画像のサムネイルが、縦横比を保ったまま指定した大きさに収まることを確認します。
+func TestThumbnailer(t *testing.T) {
+	dir := t.TempDir()
+	writeImage := func(name string, w, h int) {
+		var buf bytes.Buffer
+		img := testDiagram(w, h, false)
+		var err error
+		if filepath.Ext(name) == ".jpg" {
+			err = jpeg.Encode(&buf, img, nil)
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeImage("landscape.png", 800, 400)
+	writeImage("page/portrait.jpg", 300, 900)
+	writeImage("small.png", 40, 30)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("text"), 0o644)
+
+	thumbs := NewThumbnailer(dir, ThumbnailSize{Width: 200, Height: 150})
+	for _, name := range []string{"landscape.png", "page/portrait.jpg", "small.png", "notes.txt"} {
+		thumbs.Add(filepath.Join(dir, name))
+	}
+	if n := thumbs.Close(); n != 3 {
+		t.Errorf("作成したサムネイルの数 = %d, want 3", n)
+	}
+	tests := []struct {
+		name                  string
+		format                string
+		wantWidth, wantHeight int
+	}{
+		{"landscape.png", "png", 200, 100},
+		{"page/portrait.jpg", "jpeg", 50, 150},
+		{"small.png", "png", 40, 30}, // 上限より小さい画像は拡大しない
+	}
+	for _, tt := range tests {
+		f, err := os.Open(filepath.Join(dir, ThumbnailDir, tt.name))
+		if err != nil {
+			t.Errorf("サムネイルがありません: %v", err)
+			continue
+		}
+		cfg, format, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if format != tt.format || cfg.Width != tt.wantWidth || cfg.Height != tt.wantHeight {
+			t.Errorf("%s: %s %dx%d, want %s %dx%d", tt.name, format, cfg.Width, cfg.Height, tt.format, tt.wantWidth, tt.wantHeight)
+		}
+		if cfg.Width > 200 || cfg.Height > 150 {
+			t.Errorf("%s: %dx%dが200x150に収まっていません", tt.name, cfg.Width, cfg.Height)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ThumbnailDir, "notes.txt")); !os.IsNotExist(err) {
+		t.Errorf("画像以外のファイルのサムネイルを作成しました: %v", err)
+	}
+
+	// 元の画像より新しいサムネイルは作り直さない
+	thumbs = NewThumbnailer(dir, ThumbnailSize{Width: 200, Height: 150})
+	thumbs.Add(filepath.Join(dir, "landscape.png"))
+	if n := thumbs.Close(); n != 0 {
+		t.Errorf("2回目: 作成したサムネイルの数 = %d, want 0", n)
+	}
+}
+
+// TestDownloadThumbnailsはダウンロードした画像のサムネイルをOutDir/thumbsに作成することを確認します。
+func TestDownloadThumbnails(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, testDiagram(300, 600, false)); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/diagram.png">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img.Bytes())
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.Thumbnail = ThumbnailSize{Width: 100, Height: 100}
+	d, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+	if _, err := d.Download(context.Background(), srv.URL+"/page"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n := d.WaitThumbnails(); n != 1 {
+		t.Errorf("作成したサムネイルの数 = %d, want 1", n)
+	}
+	f, err := os.Open(filepath.Join(opts.OutDir, ThumbnailDir, "diagram.png"))
+	if err != nil {
+		t.Fatalf("サムネイルがありません: %v", err)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width != 50 || cfg.Height != 100 {
+		t.Errorf("サムネイルの大きさ = %dx%d (%v), want 50x100", cfg.Width, cfg.Height, err)
+	}
+}
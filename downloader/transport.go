@@ -0,0 +1,238 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	DefaultMaxIdleConns    = 100              // 全てのホストで保持するアイドル接続の数の既定の上限
+	DefaultIdleConnTimeout = 90 * time.Second // アイドル接続を閉じるまでの既定の時間
+)
+
+// TransportOptionsはダウンロード用のHTTPトランスポートの接続の使い回しの設定です。
+type TransportOptions struct {
+	MaxIdleConns   int           // 全てのホストで保持するアイドル接続の数の上限（0の場合は制限しない）
+	MaxIdlePerHost int           // ホストごとに保持するアイドル接続の数（0の場合は同時ダウンロード数）
+	IdleTimeout    time.Duration // アイドル接続を閉じるまでの時間（0の場合は閉じない）
+	DisableHTTP2   bool          // HTTP/2を使わずにHTTP/1.1で接続する（HTTP/2の実装に問題があるサーバーやプロキシ向け）
+}
+
+// validateはトランスポートの設定が正しいかどうかを確認します。
+func (o TransportOptions) validate() error {
+	if o.MaxIdleConns < 0 || o.MaxIdlePerHost < 0 {
+		return fmt.Errorf("アイドル接続の数は0以上にしてください: %d, %d", o.MaxIdleConns, o.MaxIdlePerHost)
+	}
+	if o.IdleTimeout < 0 {
+		return fmt.Errorf("アイドル接続を閉じるまでの時間は0以上にしてください: %v", o.IdleTimeout)
+	}
+	return nil
+}
+
+// newTransportはoptsのTLS・プロキシ・接続の設定を反映したダウンロード用のHTTPトランスポートを作成します。
+// 全てのダウンロードで1つのトランスポートを共有し、同じホストへの接続（TLSのハンドシェイク）を使い回します。
+// CACertの証明書はシステムの証明書に追加して信頼します。
+// HTTPプロキシの場合、URLに含まれる認証情報はProxy-Authorizationヘッダとして送られます。
+// SOCKS5プロキシの場合はユーザ名/パスワード認証を使い、名前解決もプロキシ側で行います（DNSが漏れません）。
+func newTransport(opts *Options) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = opts.Transport.MaxIdleConns
+	transport.IdleConnTimeout = opts.Transport.IdleTimeout
+	// 同じホストへの同時ダウンロードで毎回接続し直さないよう、ワーカー数分のアイドル接続を保持する
+	transport.MaxIdleConnsPerHost = opts.Transport.MaxIdlePerHost
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = max(opts.Concurrency, http.DefaultMaxIdleConnsPerHost)
+	}
+	// TLSClientConfigを変えてもHTTP/2を使う（1つの接続で同時にダウンロードできる）
+	transport.ForceAttemptHTTP2 = !opts.Transport.DisableHTTP2
+	if opts.Transport.DisableHTTP2 {
+		// 空でないTLSNextProtoを設定すると、TransportはHTTP/2を使わない
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if opts.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	} else if opts.CACert != "" {
+		pool, err := loadCertPool(opts.CACert)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if opts.Proxy == "" {
+		return transport, nil
+	}
+	proxyURL, err := ParseProxyURL(opts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if !isSOCKSProxy(proxyURL) {
+		transport.Proxy = http.ProxyURL(proxyURL)
+		return transport, nil
+	}
+
+	dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("SOCKS5プロキシの設定に失敗: %w", err)
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("SOCKS5プロキシのダイアラーがコンテキストに対応していません")
+	}
+	transport.Proxy = nil
+	transport.DialContext = contextDialer.DialContext
+	return transport, nil
+}
+
+// loadCertPoolはシステムの証明書プールにpathのPEMファイルの証明書を追加したプールを返します。
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CA証明書の読み込みに失敗: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA証明書ファイルに有効なPEM形式の証明書がありません: %s", path)
+	}
+	return pool, nil
+}
+
+// certSPKIHashesはpathのPEMファイルの各証明書の公開鍵（SPKI）のSHA-256ハッシュをbase64で返します。
+// Chromeの--ignore-certificate-errors-spki-listに渡すために使います。
+func certSPKIHashes(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return hashes, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		hashes = append(hashes, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+}
+
+// isSOCKSProxyはproxyURLがSOCKS5プロキシかどうかを返します。
+func isSOCKSProxy(proxyURL *url.URL) bool {
+	return proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h"
+}
+
+// ParseProxyURLはOptions.Proxyの値をパースし、対応しているスキームかどうかを確認します。
+func ParseProxyURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("プロキシURLのパースに失敗: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("対応していないプロキシのスキームです: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("プロキシURLにホストがありません: %q", rawURL)
+	}
+	return u, nil
+}
+
+// withRedirectPolicyはnoFollowの場合はリダイレクトをたどらず、そうでなければmaxRedirects回（0の場合はGoの既定値）まで
+// リダイレクトをたどるHTTPクライアントを返します。どちらも指定しない場合はclientをそのまま返します。
+func withRedirectPolicy(client *http.Client, noFollow bool, maxRedirects int) *http.Client {
+	if !noFollow && maxRedirects <= 0 {
+		return client
+	}
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if noFollow {
+			// 3xxのレスポンスをそのまま返し、ダウンロードの失敗にする
+			return http.ErrUseLastResponse
+		}
+		if len(via) > maxRedirects {
+			return fmt.Errorf("リダイレクトが%d回を超えました", maxRedirects)
+		}
+		return nil
+	}
+	return &c
+}
+
+// withBasicAuthはホストhostへのリクエストにBasic認証の認証情報authを付けるHTTPクライアントを返します。
+// authがnilの場合はclientをそのまま返します。別のホストの画像には認証情報を送りません。
+func withBasicAuth(client *http.Client, host string, auth *url.Userinfo) *http.Client {
+	if auth == nil {
+		return client
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &basicAuthTransport{base: transport, host: host, auth: auth}
+	return &c
+}
+
+// basicAuthTransportはページのホストへのリクエストにBasic認証のAuthorizationヘッダを付けるRoundTripperです。
+type basicAuthTransport struct {
+	base http.RoundTripper
+	host string
+	auth *url.Userinfo
+}
+
+// RoundTripはreqのホストがページのホストの場合にAuthorizationヘッダを付けて送信します。
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		password, _ := t.auth.Password()
+		req.SetBasicAuth(t.auth.Username(), password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newSessionClientはChromeから取得したCookieをCookieJarに設定したHTTPクライアントを返します。
+// baseのTransportなどの設定はそのまま使い、Jarだけを新しいものに置き換えます。
+// Cookieはドメイン・パスに従って送信されるため、ページと別ホストの画像には送られません。
+func newSessionClient(pageURL *url.URL, cookies []*network.Cookie, base *http.Client) (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	httpCookies := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		httpCookies = append(httpCookies, &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+		})
+	}
+	jar.SetCookies(pageURL, httpCookies)
+	client := *base
+	client.Jar = jar
+	return &client, nil
+}
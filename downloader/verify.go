@@ -0,0 +1,135 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// imageFormatsは拡張子ごとの、image.DecodeConfigが返すべき画像の形式です。
+var imageFormats = map[string]string{
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+}
+
+// fileSignaturesは画像以外の拡張子ごとの、ファイルの先頭にあるべきバイト列です。
+var fileSignatures = map[string][]byte{
+	".pdf": []byte("%PDF-"),
+}
+
+// verifyFileはfilePathの内容が拡張子の形式のファイルであることを確認します。
+// JPEG・PNG・GIFはimage.DecodeConfigでデコードできるか、PDFは先頭のシグネチャで確認し、
+// それ以外の拡張子は確認しません。ログインページへのリダイレクトやエラーページのHTMLが画像として保存された場合に失敗します。
+func verifyFile(filePath string) error {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	wantFormat, isImage := imageFormats[ext]
+	signature, hasSignature := fileSignatures[ext]
+	if !isImage && !hasSignature {
+		return nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if hasSignature {
+		head := make([]byte, len(signature))
+		if _, err := io.ReadFull(f, head); err != nil || !bytes.Equal(head, signature) {
+			return fmt.Errorf("ファイルの内容が%sではありません", strings.ToUpper(ext[1:]))
+		}
+		return nil
+	}
+	_, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("ファイルの内容が画像ではありません: %w", err)
+	}
+	if format != wantFormat {
+		return fmt.Errorf("画像の形式が拡張子と異なります: %s (拡張子 %s)", format, ext)
+	}
+	return nil
+}
+
+// verifySavedはopts.Verifyの場合にverifyFileで保存したファイルを確認し、失敗した場合はファイルを削除します。
+func verifySaved(filePath string, opts DownloadOptions) error {
+	if !opts.Verify {
+		return nil
+	}
+	if err := verifyFile(filePath); err != nil {
+		os.Remove(filePath)
+		return err
+	}
+	return nil
+}
+
+// mediaTypeAliasesは一部のサーバーが返す標準ではないMIMEタイプと、それに対応する標準のMIMEタイプです。
+var mediaTypeAliases = map[string]string{
+	"image/jpg":    "image/jpeg",
+	"image/pjpeg":  "image/jpeg",
+	"image/x-png":  "image/png",
+	"image/x-icon": "image/vnd.microsoft.icon",
+	"audio/x-wav":  "audio/wav",
+	"audio/wave":   "audio/wav",
+	"audio/mp3":    "audio/mpeg",
+}
+
+// contentTypeMatchesは拡張子extがContent-TypeのcontentTypeの形式のファイルの拡張子として正しいかどうかを返します。
+// Content-Typeがない場合や形式を表さない場合（application/octet-stream）、MIMEタイプの分からない拡張子は判断できないためtrueを返します。
+func contentTypeMatches(contentType, ext string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || ext == "" || mediaType == "application/octet-stream" || mediaType == "binary/octet-stream" {
+		return true
+	}
+	extType, _, err := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(ext)))
+	if err != nil {
+		return true
+	}
+	normalize := func(t string) string {
+		t = strings.ToLower(t)
+		if alias, ok := mediaTypeAliases[t]; ok {
+			return alias
+		}
+		return t
+	}
+	mediaType = normalize(mediaType)
+	if normalize(extType) == mediaType || strings.EqualFold(extensionForMIME(mediaType), ext) {
+		return true
+	}
+	exts, _ := mime.ExtensionsByType(mediaType)
+	for _, e := range exts {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContentTypeはContent-TypeのcontentTypeとfileNameの拡張子が一致しない場合にログに出力し、
+// fixの場合はContent-Typeの拡張子に付け替えたファイル名を返します（それ以外はfileNameをそのまま返します）。
+// 画像のURLがログインページにリダイレクトされてHTMLが返された場合などを見つけるために使います。
+func checkContentType(urlStr, fileName, contentType string, fix bool) string {
+	ext := filepath.Ext(fileName)
+	if contentTypeMatches(contentType, ext) {
+		return fileName
+	}
+	if !fix {
+		slog.Warn("Content-Typeがファイルの拡張子と一致しません", "url", displayURL(urlStr), "file", fileName, "content_type", contentType)
+		return fileName
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	fixed := strings.TrimSuffix(fileName, ext) + extensionForMIME(mediaType)
+	slog.Warn("Content-Typeがファイルの拡張子と一致しないため、拡張子を付け替えて保存します", "url", displayURL(urlStr), "file", fixed, "content_type", contentType)
+	return fixed
+}
@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDownloadFileVerifyは-verifyの場合に正しい画像は保存し、画像として保存されたHTMLは削除してエラーにすることを確認します。
+func TestDownloadFileVerify(t *testing.T) {
+	png, err := base64.StdEncoding.DecodeString(onePixelPNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/png-as.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(png)
+		case "/login.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("<!DOCTYPE html><html><body>ログインしてください</body></html>"))
+		case "/doc.pdf":
+			w.Write([]byte("%PDF-1.7\n"))
+		case "/fake.pdf":
+			w.Write([]byte("<html></html>"))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"ok.png", false},
+		{"png-as.jpg", true},
+		{"login.jpg", true},
+		{"doc.pdf", false},
+		{"fake.pdf", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DownloadOptions{OutDir: t.TempDir(), Verify: true}
+			_, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/"+tt.name, tt.name, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			_, statErr := os.Stat(filepath.Join(opts.OutDir, tt.name))
+			if tt.wantErr && statErr == nil {
+				t.Error("確認に失敗したファイルが削除されていません")
+			}
+			if !tt.wantErr && statErr != nil {
+				t.Errorf("ファイルが保存されていません: %v", statErr)
+			}
+		})
+	}
+
+	t.Run("verifyなし", func(t *testing.T) {
+		opts := DownloadOptions{OutDir: t.TempDir()}
+		if _, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/login.jpg", "login.jpg", opts); err != nil {
+			t.Fatalf("DownloadFile: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(opts.OutDir, "login.jpg")); err != nil {
+			t.Errorf("ファイルが保存されていません: %v", err)
+		}
+	})
+}
+
+// TestContentTypeMatchesはContent-Typeと拡張子の組み合わせが一致するかどうかを判定できることを確認します。
+func TestContentTypeMatches(t *testing.T) {
+	tests := []struct {
+		contentType string
+		ext         string
+		want        bool
+	}{
+		{"image/png", ".png", true},
+		{"image/jpeg", ".jpg", true},
+		{"image/jpeg", ".JPEG", true},
+		{"image/jpg", ".jpg", true},
+		{"image/svg+xml; charset=utf-8", ".svg", true},
+		{"application/pdf", ".pdf", true},
+		{"application/octet-stream", ".png", true},
+		{"", ".png", true},
+		{"image/png", ".unknownext", true},
+		{"text/html; charset=utf-8", ".jpg", false},
+		{"image/png", ".jpg", false},
+		{"application/json", ".gif", false},
+	}
+	for _, tt := range tests {
+		if got := contentTypeMatches(tt.contentType, tt.ext); got != tt.want {
+			t.Errorf("contentTypeMatches(%q, %q) = %v, want %v", tt.contentType, tt.ext, got, tt.want)
+		}
+	}
+}
+
+// TestDownloadFileCheckTypeはCheckTypeの場合にContent-Typeと拡張子が一致しなくてもそのまま保存し、
+// FixExtの場合は拡張子をContent-Typeのものに付け替えて保存することを確認します。
+func TestDownloadFileCheckType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/login.jpg":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		fix    bool
+		source string
+		want   string
+	}{
+		{"一致", true, "ok.png", "ok.png"},
+		{"一致しない", false, "login.jpg", "login.jpg"},
+		{"拡張子の付け替え", true, "login.jpg", "login.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DownloadOptions{OutDir: t.TempDir(), CheckType: true, FixExt: tt.fix}
+			result, err := DownloadFile(context.Background(), srv.Client(), srv.URL+"/"+tt.source, tt.source, opts)
+			if err != nil {
+				t.Fatalf("DownloadFile: %v", err)
+			}
+			if result.FileName != tt.want {
+				t.Errorf("FileName = %q, want %q", result.FileName, tt.want)
+			}
+			if got := readFile(t, filepath.Join(opts.OutDir, tt.want)); got != "/"+tt.source {
+				t.Errorf("%s の内容 = %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -3,8 +3,16 @@ module github.com/kznagamori/go_download_attachment
 go 1.23.3
 
 require (
-	github.com/chromedp/cdproto v0.0.0-20250203011601-a3c71a042730 // indirect
-	github.com/chromedp/chromedp v0.12.1 // indirect
+	github.com/andybalholm/brotli v1.2.5
+	github.com/chromedp/cdproto v0.0.0-20250203011601-a3c71a042730
+	github.com/chromedp/chromedp v0.12.1
+	golang.org/x/image v0.23.0
+	golang.org/x/net v0.34.0
+	golang.org/x/time v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/chromedp/sysutil v1.1.0 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
@@ -12,4 +20,5 @@ require (
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.9.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
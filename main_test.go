@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kznagamori/go_download_attachment/downloader"
+)
+
+// TestCompileRegexは空の正規表現はnil、不正な正規表現はフラグ名を含むエラーになることを確認します。
+func TestCompileRegex(t *testing.T) {
+	re, err := compileRegex("url-regex", "")
+	if re != nil || err != nil {
+		t.Errorf("compileRegex(\"\") = %v, %v", re, err)
+	}
+	re, err = compileRegex("url-regex", `/attachment/[0-9a-f]+`)
+	if err != nil || !re.MatchString("/attachment/649abc") {
+		t.Errorf("compileRegex = %v, %v", re, err)
+	}
+	if _, err := compileRegex("url-regex-exclude", `(`); err == nil || !strings.HasPrefix(err.Error(), "-url-regex-exclude:") {
+		t.Errorf("compileRegex(\"(\") = %v", err)
+	}
+}
+
+// TestCreateArchiveは出力先（-out、-zip、-targz）を複数指定した場合と、-dry-runと同時に指定した場合にエラーになることを確認します。
+func TestCreateArchive(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		cfg     config
+		wantErr bool
+	}{
+		{"-outのみ", config{opts: downloader.Options{OutDir: dir}}, false},
+		{"-zipのみ", config{zipPath: filepath.Join(dir, "a.zip")}, false},
+		{"-targzのみ", config{tarGzPath: filepath.Join(dir, "a.tar.gz")}, false},
+		{"-zipと-targz", config{zipPath: filepath.Join(dir, "b.zip"), tarGzPath: filepath.Join(dir, "b.tar.gz")}, true},
+		{"-outと-zip", config{zipPath: filepath.Join(dir, "c.zip"), opts: downloader.Options{OutDir: dir}}, true},
+		{"-zipと-dry-run", config{zipPath: filepath.Join(dir, "d.zip"), opts: downloader.Options{DryRun: true}}, true},
+	}
+	for _, tt := range tests {
+		err := createArchive(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if archive := tt.cfg.opts.Download.Archive; archive != nil {
+			archive.Close()
+		}
+		if tt.wantErr && tt.cfg.opts.Download.Archive != nil {
+			t.Errorf("%s: エラーなのにアーカイブが作成されました", tt.name)
+		}
+	}
+}
+
+// TestParseHeaderは"名前: 値"の形式のヘッダを分け、不正なヘッダはエラーにすることを確認します。
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		in          string
+		name, value string
+		wantErr     bool
+	}{
+		{"X-Forwarded-User: alice", "X-Forwarded-User", "alice", false},
+		{"X-Empty:", "X-Empty", "", false},
+		{"  X-Time : 12:00 ", "X-Time", "12:00", false},
+		{"X-Forwarded-User", "", "", true},
+		{": value", "", "", true},
+		{"X Bad: value", "", "", true},
+		{"X-Bad: a\nb", "", "", true},
+	}
+	for _, tt := range tests {
+		name, value, err := parseHeader(tt.in)
+		if name != tt.name || value != tt.value || (err != nil) != tt.wantErr {
+			t.Errorf("parseHeader(%q) = %q, %q, %v", tt.in, name, value, err)
+		}
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		completed, total, bytes int64
+		want                    string
+	}{
+		{0, 0, 0, "[>                             ] 0/0 files, 0 B"},
+		{12, 40, 3 << 20, "[=========>                    ] 12/40 files, 3.0 MB"},
+		{40, 40, 1536, "[==============================] 40/40 files, 1.5 KB"},
+	}
+	for _, tt := range tests {
+		if got := formatProgress(tt.completed, tt.total, tt.bytes); got != tt.want {
+			t.Errorf("formatProgress(%d, %d, %d) = %q, want %q", tt.completed, tt.total, tt.bytes, got, tt.want)
+		}
+	}
+}
+
+// TestFormatChromeVersionはChromeのバージョンと実行ファイルのパスを1行にし、起動できない場合は理由を出力することを確認します。
+func TestFormatChromeVersion(t *testing.T) {
+	tests := []struct {
+		info downloader.ChromeInfo
+		err  error
+		want string
+	}{
+		{downloader.ChromeInfo{Path: "/usr/bin/chromium", Product: "Chrome/132.0.6834.83"}, nil, "chrome: Chrome/132.0.6834.83 (/usr/bin/chromium)"},
+		{downloader.ChromeInfo{Product: "Chrome/132.0.6834.83"}, nil, "chrome: Chrome/132.0.6834.83 (remote)"},
+		{downloader.ChromeInfo{Path: "/opt/chrome"}, errors.New("exec failed"), "chrome: unavailable at /opt/chrome (exec failed)"},
+		{downloader.ChromeInfo{}, errors.New("not found"), "chrome: unavailable (not found)"},
+	}
+	for _, tt := range tests {
+		if got := formatChromeVersion(tt.info, tt.err); got != tt.want {
+			t.Errorf("formatChromeVersion(%+v, %v) = %q, want %q", tt.info, tt.err, got, tt.want)
+		}
+	}
+}
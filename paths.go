@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	outDateLayout     = "2006-01-02"        // -outの{{date}}の既定の形式
+	outDateTimeLayout = "2006-01-02_150405" // -outの{{datetime}}の既定の形式（Windowsでファイル名に使えない":"を含めない）
+)
+
+// pathFlagsはcfgのファイルやディレクトリのパスを指定するフラグの値です（-configを除く）。
+func pathFlags(cfg *config) []*string {
+	opts := &cfg.opts
+	return []*string{
+		&opts.OutDir, &cfg.urlFile, &cfg.zipPath, &cfg.tarGzPath, &cfg.manifestPath, &cfg.markdownPath, &cfg.statePath,
+		&opts.Screenshot.Path, &opts.PDF.Path, &opts.UserDataDir, &opts.ChromePath, &opts.CACert,
+	}
+}
+
+// expandPathsはcfgのパスを指定するフラグの値の"~"と環境変数を展開します。
+// 設定ファイルに書いた値や、シェルが展開しない書き方（-out=~/images）の場合に備えます。
+func expandPaths(cfg *config) error {
+	for _, p := range pathFlags(cfg) {
+		expanded, err := expandPath(*p)
+		if err != nil {
+			return err
+		}
+		*p = expanded
+	}
+	return nil
+}
+
+// expandPathはpathの先頭の"~"（"~"のみ、または"~/"か"~\"で始まる場合）をホームディレクトリに置き換え、
+// $HOMEや${HOME}、%USERPROFILE%の形式の環境変数をその値に置き換えます。
+// 設定されていない環境変数は、"$"や"%"を含むファイル名を壊さないようそのまま残します。
+func expandPath(path string) (string, error) {
+	path = expandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		if path == "~" {
+			return home, nil
+		}
+		return filepath.Join(home, filepath.FromSlash(path[2:])), nil
+	}
+	return path, nil
+}
+
+// expandEnvはsの$NAME、${NAME}、%NAME%の形式の環境変数のうち、設定されているものを値に置き換えます。
+// Windowsのcmd.exeの形式（%NAME%）もOSによらず展開します。
+func expandEnv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' && s[i] != '%' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		name, n := envRef(s[i:])
+		if value, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(value)
+			i += n
+			continue
+		}
+		// 変数でない"$"や"%"はそのまま残す
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// envRefはsの先頭の$NAME、${NAME}、%NAME%の形式の環境変数の参照の名前と、参照の長さを返します。
+// 参照でない場合は空文字列を返します。
+func envRef(s string) (string, int) {
+	switch {
+	case strings.HasPrefix(s, "${"):
+		if end := strings.IndexByte(s, '}'); end > 2 {
+			return s[2:end], end + 1
+		}
+	case s[0] == '$':
+		n := 1
+		for n < len(s) && isEnvNameByte(s[n]) {
+			n++
+		}
+		return s[1:n], n
+	case s[0] == '%':
+		n := 1
+		for n < len(s) && isEnvNameByte(s[n]) {
+			n++
+		}
+		if n < len(s) && s[n] == '%' {
+			return s[1:n], n + 1
+		}
+	}
+	return "", 0
+}
+
+// isEnvNameByteはcが環境変数の名前に使える文字（英数字と"_"）かどうかを返します。
+func isEnvNameByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// renderOutDirは-outのパスのプレースホルダーを展開します。{{date}}と{{datetime}}は実行を開始した日時now、
+// {{host}}はページのURL（pageURLs）のホスト名にします。{{date "200601"}}のようにGoの時刻の形式も指定できます。
+// 保存先は全てのページで同じにするため、{{host}}はpageURLsのホストが1つの場合のみ使えます。
+func renderOutDir(path string, now time.Time, pageURLs []string) (string, error) {
+	return executeOutDir(path, now, func() (string, error) { return pageHost(pageURLs) })
+}
+
+// executeOutDirは-outのパスをテンプレートとして実行します。{{host}}にはhostの値を使います。
+func executeOutDir(path string, now time.Time, host func() (string, error)) (string, error) {
+	if !strings.Contains(path, "{{") {
+		return path, nil
+	}
+	timeFunc := func(defaultLayout string) func(...string) (string, error) {
+		return func(layout ...string) (string, error) {
+			switch len(layout) {
+			case 0:
+				return now.Format(defaultLayout), nil
+			case 1:
+				return now.Format(layout[0]), nil
+			}
+			return "", errors.New("時刻の形式は1つだけ指定してください")
+		}
+	}
+	tmpl, err := template.New("out").Funcs(template.FuncMap{
+		"date":     timeFunc(outDateLayout),
+		"datetime": timeFunc(outDateTimeLayout),
+		"host":     host,
+	}).Parse(path)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// pageHostはpageURLsに共通のホスト名（ポート番号なし）を返します。
+func pageHost(pageURLs []string) (string, error) {
+	host := ""
+	for _, pageURL := range pageURLs {
+		u, err := url.Parse(pageURL)
+		if err != nil || u.Hostname() == "" {
+			return "", fmt.Errorf("ページのURLからホスト名を取得できません: %q", pageURL)
+		}
+		if host != "" && u.Hostname() != host {
+			return "", fmt.Errorf("{{host}}は全てのページのホストが同じ場合のみ使えます（%sと%s）", host, u.Hostname())
+		}
+		host = u.Hostname()
+	}
+	if host == "" {
+		return "", errors.New("{{host}}は-urlか-url-fileでページを指定した場合のみ使えます")
+	}
+	return host, nil
+}
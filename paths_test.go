@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kznagamori/go_download_attachment/downloader"
+)
+
+// TestExpandPathは"~"と環境変数を展開し、それ以外のパスはそのまま返すことを確認します。
+func TestExpandPath(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "home")
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("GDA_TEST_DIR", "/data/images")
+	t.Setenv("GDA_TEST_UNSET", "")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", home},
+		{"~/", home},
+		{"~/images", filepath.Join(home, "images")},
+		{"~/images/docs", filepath.Join(home, "images", "docs")},
+		{"$HOME/images", home + "/images"},
+		{"${HOME}/images", home + "/images"},
+		{"%USERPROFILE%/images", home + "/images"},
+		{"$GDA_TEST_DIR/docs", "/data/images/docs"},
+		{"%GDA_TEST_DIR%/docs", "/data/images/docs"},
+		{"out/$GDA_TEST_UNSET/docs", "out//docs"},
+		// 展開しないもの
+		{"", ""},
+		{"./images", "./images"},
+		{"~user/images", "~user/images"},
+		{"images/~", "images/~"},
+		{"$GDA_TEST_NOT_DEFINED/images", "$GDA_TEST_NOT_DEFINED/images"},
+		{"${GDA_TEST_NOT_DEFINED}/images", "${GDA_TEST_NOT_DEFINED}/images"},
+		{"%GDA_TEST_NOT_DEFINED%/images", "%GDA_TEST_NOT_DEFINED%/images"},
+		{"100%/images", "100%/images"},
+		{"50%-%GDA_TEST_DIR%", "50%-/data/images"},
+	}
+	for _, tt := range tests {
+		got, err := expandPath(tt.path)
+		if err != nil {
+			t.Errorf("expandPath(%q): %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expandPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestExpandPathsはコマンドライン引数と設定ファイルで指定したパスのフラグを展開することを確認します。
+func TestExpandPaths(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "home")
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	configFile := writeConfig(t, "config.yaml", "manifest: ~/manifest.json\n")
+	cfg, err := parseArgs(t, configFile, "-url", "https://growi.example.com/page", "-out", "~/images", "-state", "$HOME/state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expandPaths(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range []struct{ name, value, want string }{
+		{"out", cfg.opts.OutDir, filepath.Join(home, "images")},
+		{"state", cfg.statePath, home + "/state.json"},
+		{"manifest", cfg.manifestPath, filepath.Join(home, "manifest.json")},
+		{"url", cfg.pageURL, "https://growi.example.com/page"},
+	} {
+		if got.value != got.want {
+			t.Errorf("-%s = %q, want %q", got.name, got.value, got.want)
+		}
+	}
+}
+
+// TestRenderOutDirは-outのプレースホルダーを実行を開始した日時とページのホスト名に展開することを確認します。
+func TestRenderOutDir(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 30, 5, 0, time.Local)
+	pages := []string{"https://growi.example.com/Docs/a", "https://growi.example.com:8443/Docs/b"}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"./archive", "./archive"},
+		{"./archive/{{date}}", "./archive/2024-06-01"},
+		{"./archive/{{datetime}}", "./archive/2024-06-01_093005"},
+		{`./archive/{{date "200601"}}/{{date "02"}}`, "./archive/202406/01"},
+		{"./archive/{{host}}/{{date}}", "./archive/growi.example.com/2024-06-01"},
+	}
+	for _, tt := range tests {
+		got, err := renderOutDir(tt.path, now, pages)
+		if err != nil {
+			t.Errorf("renderOutDir(%q): %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("renderOutDir(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		path  string
+		pages []string
+	}{
+		{"./archive/{{host}}", []string{"https://a.example.com/page", "https://b.example.com/page"}},
+		{"./archive/{{host}}", nil},
+		{"./archive/{{time}}", pages},
+		{"./archive/{{date", pages},
+	} {
+		if got, err := renderOutDir(tt.path, now, tt.pages); err == nil {
+			t.Errorf("renderOutDir(%q, %q) = %q, want error", tt.path, tt.pages, got)
+		}
+	}
+}
+
+// TestRenderOutDirCreateは展開した-outのディレクトリに保存先が作成されることを確認します。
+func TestRenderOutDirCreate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 30, 0, 0, time.Local)
+	root := t.TempDir()
+	outDir, err := renderOutDir(filepath.Join(root, "archive", "{{host}}", "{{date}}"), now, []string{"https://growi.example.com/page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := downloader.DefaultOptions()
+	opts.OutDir = outDir
+	opts.NoBrowser = true
+	d, err := downloader.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	want := filepath.Join(root, "archive", "growi.example.com", "2024-06-01")
+	if info, err := os.Stat(want); err != nil || !info.IsDir() {
+		t.Errorf("%sが作成されていません: %v", want, err)
+	}
+}
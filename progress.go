@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kznagamori/go_download_attachment/downloader"
+)
+
+const (
+	progressInterval    = 200 * time.Millisecond // 端末に進捗バーを描き直す間隔
+	progressLogInterval = 10 * time.Second       // 端末でない場合に進捗をログに出力する間隔
+	progressBarWidth    = 30
+)
+
+// startProgressは-progressの進捗の表示を始め、表示を終える関数を返します。
+// 標準エラー出力が端末の場合は進捗バーを同じ行に描き直し、ファイルなどにリダイレクトされている場合は
+// エスケープシーケンスを使わず、一定の間隔で進捗をログに出力します。bytesにはダウンロードしたバイト数の合計を返す関数を渡します。
+func startProgress(p *downloader.Progress, bytes func() int64) func() {
+	terminal := isTerminal(os.Stderr)
+	interval := progressLogInterval
+	if terminal {
+		interval = progressInterval
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				if terminal {
+					drawProgress(os.Stderr, p, bytes())
+					fmt.Fprintln(os.Stderr)
+				}
+				return
+			}
+			if terminal {
+				drawProgress(os.Stderr, p, bytes())
+			} else {
+				completed, total := p.Counts()
+				slog.Info("進捗", "done", completed, "total", total, "bytes", downloader.FormatSize(bytes()))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// drawProgressはwの現在の行を消して進捗バーを描きます（改行はしません）。
+func drawProgress(w io.Writer, p *downloader.Progress, bytes int64) {
+	completed, total := p.Counts()
+	fmt.Fprintf(w, "\r\033[K%s", formatProgress(completed, total, bytes))
+}
+
+// formatProgressは"[=========>          ] 12/40 files, 3.4 MB"の形式の進捗バーを返します。
+func formatProgress(completed, total, bytes int64) string {
+	filled := 0
+	if total > 0 {
+		filled = int(min(completed, total) * progressBarWidth / total)
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %d/%d files, %s", bar, completed, total, downloader.FormatSize(bytes))
+}
+
+// isTerminalはfが端末（キャラクタデバイス）かどうかを返します。
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
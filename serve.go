@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/kznagamori/go_download_attachment/downloader"
+)
+
+const (
+	maxServeRequestBody = 1 << 20          // POST /downloadの本文の大きさの上限
+	serveShutdownWait   = 30 * time.Second // 終了時に処理中のリクエストを待つ時間の上限
+)
+
+// serveRequestは-serveのPOST /downloadの本文です。
+type serveRequest struct {
+	URL     string       `json:"url"`
+	Options serveOptions `json:"options"`
+}
+
+// serveOptionsはリクエストごとに変えられる設定です（指定しない項目はコマンドライン引数の値を使います）。
+type serveOptions struct {
+	DryRun       bool   `json:"dry_run"`
+	List         bool   `json:"list"`
+	Selector     string `json:"selector"`
+	Attr         string `json:"attr"`
+	WaitSelector string `json:"wait_selector"`
+	Subdir       string `json:"subdir"` // -out配下の保存先のディレクトリ（-outの外は指定できない）
+}
+
+// serveErrorはエラーの場合のレスポンスの本文です。
+type serveError struct {
+	Error string `json:"error"`
+}
+
+// serverは-serveのHTTPサーバーです。全てのリクエストでdのブラウザを使い、リクエストごとに新しいタブを開きます。
+type server struct {
+	d    *downloader.Downloader
+	base downloader.Options // コマンドライン引数の設定（リクエストごとの設定の元にする）
+	tabs chan struct{}      // 同時に処理するリクエスト（開くタブ）の数の上限
+}
+
+// newServerはdでページを処理するserverを作成します。同時に処理するリクエストはmaxTabs件までにします。
+func newServer(d *downloader.Downloader, base downloader.Options, maxTabs int) *server {
+	// 同じディレクトリに保存する別のリクエストの画像の名前が重ならないよう、全てのリクエストで同じ記録を使う
+	if base.Download.Names == nil {
+		base.Download.Names = downloader.NewNameRegistry()
+	}
+	// 同時に処理するリクエストの画像も合わせて、ホストごとの同時ダウンロード数を制限する
+	if base.Download.HostLimit == nil {
+		base.Download.HostLimit = downloader.NewHostLimiter(base.PerHostLimit)
+	}
+	// 対象の一覧はレスポンスで返すため、標準出力には出さない
+	base.Output = io.Discard
+	return &server{d: d, base: base, tabs: make(chan struct{}, maxTabs)}
+}
+
+// ServeHTTPはPOST /downloadでページの画像をダウンロードし、-manifestと同じ形式の結果をJSONで返します。
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/download" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, serveError{Error: "POSTで送信してください"})
+		return
+	}
+	var req serveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeRequestBody)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, serveError{Error: fmt.Sprintf("リクエストの本文を解析できません: %v", err)})
+		return
+	}
+	if req.URL == "" {
+		writeJSON(w, http.StatusBadRequest, serveError{Error: "urlを指定してください"})
+		return
+	}
+	opts, err := s.requestOptions(req.Options)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, serveError{Error: err.Error()})
+		return
+	}
+
+	// タブを開きすぎないよう、空くまで待つ（待っている間にクライアントが切断した場合は処理しない）
+	select {
+	case s.tabs <- struct{}{}:
+		defer func() { <-s.tabs }()
+	case <-r.Context().Done():
+		return
+	}
+	d, err := s.d.WithOptions(opts)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, serveError{Error: err.Error()})
+		return
+	}
+	defer d.Close()
+	slog.Info("ページを処理します", "page", req.URL, "remote", r.RemoteAddr)
+	results, _, err := d.DownloadPage(r.Context(), req.URL)
+	switch {
+	case errors.Is(err, downloader.ErrRobotsDisallowed):
+		writeJSON(w, http.StatusForbidden, serveError{Error: err.Error()})
+		return
+	case err != nil && !errors.Is(err, downloader.ErrFailFast):
+		writeJSON(w, http.StatusBadGateway, serveError{Error: err.Error()})
+		return
+	}
+	if results == nil {
+		results = []downloader.Result{}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// requestOptionsはコマンドライン引数の設定にリクエストごとの設定oを反映した設定を返します。
+func (s *server) requestOptions(o serveOptions) (downloader.Options, error) {
+	opts := s.base
+	opts.DryRun = opts.DryRun || o.DryRun
+	opts.List = opts.List || o.List
+	if o.Selector != "" {
+		opts.Extract.Selector = o.Selector
+		opts.Extract.WaitSelector = o.Selector
+	}
+	if o.Attr != "" {
+		opts.Extract.Attr = o.Attr
+	}
+	if o.WaitSelector != "" {
+		opts.Extract.WaitSelector = o.WaitSelector
+	}
+	if o.Subdir != "" {
+		if !filepath.IsLocal(o.Subdir) {
+			return opts, fmt.Errorf("subdirには-out配下の相対パスを指定してください: %q", o.Subdir)
+		}
+		opts.OutDir = filepath.Join(opts.OutDir, o.Subdir)
+	}
+	return opts, nil
+}
+
+// writeJSONはvをJSONにしてstatusのレスポンスで返します。
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Debug("レスポンスの書き込みに失敗しました", "error", err)
+	}
+}
+
+// serveは-serveのHTTPサーバーをaddrで起動し、Ctrl-Cなどで中断されるまでリクエストを処理します。終了コードを返します。
+func serve(d *downloader.Downloader, cfg *config) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", cfg.serveAddr)
+	if err != nil {
+		slog.Error("待ち受けを開始できません", "addr", cfg.serveAddr, "error", err)
+		return 1
+	}
+	srv := &http.Server{
+		Handler:           newServer(d, cfg.opts, cfg.serveTabs),
+		ReadHeaderTimeout: 10 * time.Second,
+		// 処理中のページは中断時にキャンセルする
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(listener) }()
+	fmt.Printf("serving on http://%s (POST /download)\n", listener.Addr())
+
+	select {
+	case err := <-errCh:
+		slog.Error("HTTPサーバーが停止しました", "error", err)
+		return 1
+	case <-ctx.Done():
+	}
+	stop()
+	slog.Info("終了します")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownWait)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("処理中のリクエストを待てませんでした", "error", err)
+	}
+	return 0
+}
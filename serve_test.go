@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kznagamori/go_download_attachment/downloader"
+)
+
+// TestServeDownloadはPOST /downloadでページの画像を-out配下のsubdirに保存し、結果をJSONで返すことと、
+// 不正なリクエストにはエラーを返すことを確認します。
+func TestServeDownload(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Write([]byte(`<img src="/a.png"><img src="/b.png">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer site.Close()
+
+	opts := downloader.DefaultOptions()
+	opts.OutDir = t.TempDir()
+	opts.NoBrowser = true
+	opts.RespectRobots = false
+	opts.Download.Retry.Retries = 0
+	d, err := downloader.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	srv := httptest.NewServer(newServer(d, opts, 2))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/download", "application/json", strings.NewReader(`{"url": "`+site.URL+`/page", "options": {"subdir": "docs"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []downloader.Result
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, err = %v", resp.StatusCode, err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+	for _, r := range results {
+		if !r.Success {
+			t.Errorf("%s: %s", r.URL, r.Error)
+		}
+		if _, err := os.Stat(filepath.Join(opts.OutDir, "docs", r.File)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, method, body string
+		want               int
+	}{
+		{"GET", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"不正なJSON", http.MethodPost, `{`, http.StatusBadRequest},
+		{"urlなし", http.MethodPost, `{}`, http.StatusBadRequest},
+		{"-outの外", http.MethodPost, `{"url": "` + site.URL + `/page", "options": {"subdir": "../x"}}`, http.StatusBadRequest},
+		{"読み込めないページ", http.MethodPost, `{"url": "http://127.0.0.1:0/page"}`, http.StatusBadGateway},
+	} {
+		req, _ := http.NewRequest(tt.method, srv.URL+"/download", strings.NewReader(tt.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body serveError
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want || body.Error == "" {
+			t.Errorf("%s: status = %d, error = %q, want %d", tt.name, resp.StatusCode, body.Error, tt.want)
+		}
+	}
+}
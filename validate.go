@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kznagamori/go_download_attachment/downloader"
+)
+
+// validateOptionsはコマンドライン引数と設定ファイルで指定した設定を確認し、誤りがあれば全ての誤りをまとめたエラー
+// （1行に1つ）を返します。実行の途中で分かりにくいエラーにならないよう、Chromeを起動する前に呼びます。
+func validateOptions(cfg *config) error {
+	opts := &cfg.opts
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	// 対象のページと保存先
+	switch {
+	case cfg.serveAddr != "" && (cfg.pageURL != "" || cfg.urlFile != ""):
+		add("-serveは-urlや-url-fileと同時に指定できません（ページのURLはリクエストで指定します）")
+	case cfg.pageURL == "" && cfg.urlFile == "" && cfg.serveAddr == "":
+		add("-url、-url-file、-serveのいずれかを指定してください")
+	}
+	if cfg.pageURL != "" {
+		if u, err := url.Parse(cfg.pageURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("-urlにはhttp://かhttps://で始まるURLを指定してください: %q", cfg.pageURL)
+		}
+	}
+	if cfg.urlFile != "" {
+		if _, err := os.Stat(cfg.urlFile); err != nil {
+			add("-url-fileを読み込めません: %v", err)
+		}
+	}
+	outputs := 0
+	for _, output := range []string{opts.OutDir, cfg.zipPath, cfg.tarGzPath} {
+		if output != "" {
+			outputs++
+		}
+	}
+	switch {
+	case outputs > 1:
+		add("-out、-zip、-targzは同時に指定できません")
+	case outputs == 0 && !opts.List:
+		add("-out、-zip、-targzのいずれかを指定してください（-listの場合は不要）")
+	}
+	archive := cfg.zipPath != "" || cfg.tarGzPath != ""
+	if archive && (opts.DryRun || opts.List) {
+		add("-zipと-targzは-dry-runや-listと同時に指定できません")
+	}
+	if archive && cfg.serveAddr != "" {
+		add("-serveは-zipや-targzと同時に指定できません")
+	}
+	if cfg.jsonl && (opts.DryRun || opts.List || cfg.serveAddr != "") {
+		add("-jsonlは-dry-run、-list、-serveと同時に指定できません")
+	}
+	if opts.List && opts.DryRun {
+		add("-listと-dry-runは同時に指定できません")
+	}
+	if (cfg.gallery || cfg.markdownPath != "" || cfg.checksums || cfg.dedupContent || cfg.dedupSimilar || cfg.thumbnails != "" || opts.Mirror) && (opts.OutDir == "" || opts.DryRun || opts.List) {
+		add("-gallery、-markdown、-checksums、-dedup-content、-dedup-similar、-thumbnails、-mirrorは-outに保存する場合のみ使えます（-dry-run、-list、-zip、-targzとは同時に指定できません）")
+	}
+
+	if opts.Download.FixExt && !opts.Download.CheckType {
+		add("-fix-extensionは-check-typeと同時に指定してください")
+	}
+
+	// 数値の範囲
+	for _, r := range []struct {
+		name     string
+		value    int
+		min, max int
+	}{
+		{"concurrency", opts.Concurrency, 1, 0},
+		{"per-host-concurrency", opts.PerHostLimit, 0, 0},
+		{"max-idle-conns", opts.Transport.MaxIdleConns, 0, 0},
+		{"max-idle-conns-per-host", opts.Transport.MaxIdlePerHost, 0, 0},
+		{"retries", opts.Download.Retry.Retries, 0, 0},
+		{"max-redirects", opts.MaxRedirects, 1, 0},
+		{"depth", opts.Depth, 0, 0},
+		{"similar-threshold", cfg.similarDist, 0, 0},
+		{"jpeg-quality", opts.Download.JPEGQuality, 1, 100},
+		{"screenshot-quality", opts.Screenshot.Quality, 1, 100},
+		{"window-width", opts.Extract.WindowWidth, 1, 0},
+		{"window-height", opts.Extract.WindowHeight, 1, 0},
+		{"serve-tabs", cfg.serveTabs, 1, 0},
+	} {
+		switch {
+		case r.max > 0 && (r.value < r.min || r.value > r.max):
+			add("-%sは%d〜%dで指定してください: %d", r.name, r.min, r.max, r.value)
+		case r.value < r.min:
+			add("-%sは%d以上で指定してください: %d", r.name, r.min, r.value)
+		}
+	}
+	if opts.Extract.ScaleFactor <= 0 {
+		add("-scale-factorは0より大きい値で指定してください: %g", opts.Extract.ScaleFactor)
+	}
+	if opts.Download.Timeout < 0 {
+		add("-download-timeoutは0以上で指定してください: %v", opts.Download.Timeout)
+	}
+	if opts.Transport.IdleTimeout < 0 {
+		add("-idle-conn-timeoutは0以上で指定してください: %v", opts.Transport.IdleTimeout)
+	}
+	if opts.Extract.NetworkIdle < 0 {
+		add("-wait-network-idleは0以上で指定してください: %v", opts.Extract.NetworkIdle)
+	}
+
+	// 解析が必要な値
+	for _, r := range []struct{ name, expr string }{{"url-regex", cfg.urlRegex}, {"url-regex-exclude", cfg.urlExclude}} {
+		if _, err := compileRegex(r.name, r.expr); err != nil {
+			add("正規表現が不正です: %v", err)
+		}
+	}
+	if cfg.nameTemplate != downloader.DefaultNameTemplate {
+		if _, err := downloader.ParseNameTemplate(cfg.nameTemplate); err != nil {
+			add("-name-templateが不正です: %v", err)
+		}
+	}
+	sampleHost := func() (string, error) {
+		if cfg.serveAddr != "" {
+			return "", errors.New("{{host}}は-serveでは使えません")
+		}
+		return "growi.example.com", nil
+	}
+	if _, err := executeOutDir(opts.OutDir, time.Now(), sampleHost); err != nil {
+		add("-outのプレースホルダーが不正です: %v", err)
+	}
+	if cfg.maxSize != "" {
+		if _, err := downloader.ParseSize(cfg.maxSize); err != nil {
+			add("-max-sizeが不正です: %v", err)
+		}
+	}
+	if cfg.maxRate != "" {
+		if _, err := downloader.ParseSize(strings.TrimSuffix(strings.TrimSpace(cfg.maxRate), "/s")); err != nil {
+			add("-max-rateが不正です: %v", err)
+		}
+	}
+	if cfg.convertTo != "" {
+		if _, err := downloader.ParseConvertFormat(cfg.convertTo); err != nil {
+			add("-convert-toが不正です: %v", err)
+		}
+	}
+	if cfg.thumbnails != "" {
+		if _, err := downloader.ParseThumbnailSize(cfg.thumbnails); err != nil {
+			add("-thumbnailsが不正です: %v", err)
+		}
+	}
+	if opts.Proxy != "" {
+		if _, err := downloader.ParseProxyURL(opts.Proxy); err != nil {
+			add("-proxyが不正です: %v", err)
+		}
+	}
+	if opts.Device != "" {
+		if _, err := downloader.LookupDevice(opts.Device); err != nil {
+			add("-deviceが不正です: %v", err)
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.logLevel)); err != nil {
+		add("-log-levelはdebug、info、warn、errorのいずれかで指定してください: %q", cfg.logLevel)
+	}
+	if cfg.logFormat != "text" && cfg.logFormat != "json" {
+		add("-log-formatはtextかjsonで指定してください: %q", cfg.logFormat)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validTestConfigはvalidateOptionsでエラーにならない設定を返します（フラグの既定値に-urlと-outを指定したもの）。
+func validTestConfig(t *testing.T) config {
+	t.Helper()
+	cfg, err := parseArgs(t, "", "-url", "https://growi.example.com/page", "-out", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// TestValidateOptionsは誤った設定の組み合わせで、全ての誤りを1行ずつまとめたエラーを返すことを確認します。
+func TestValidateOptions(t *testing.T) {
+	cfg := validTestConfig(t)
+	if err := validateOptions(&cfg); err != nil {
+		t.Fatalf("正しい設定でエラーになりました: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		set  func(*config)
+		want []string
+	}{
+		{"URLなし", func(c *config) { c.pageURL = "" }, []string{"-url、-url-file、-serveのいずれか"}},
+		{"URLのスキーム", func(c *config) { c.pageURL = "growi.example.com/page" }, []string{"-urlにはhttp://かhttps://"}},
+		{"存在しないURLファイル", func(c *config) { c.urlFile = filepath.Join(t.TempDir(), "urls.txt") }, []string{"-url-fileを読み込めません"}},
+		{"-zipと-targz", func(c *config) {
+			c.opts.OutDir = ""
+			c.zipPath, c.tarGzPath = "a.zip", "a.tar.gz"
+		}, []string{"-out、-zip、-targzは同時に指定できません"}},
+		{"-zipと-dry-run", func(c *config) {
+			c.opts.OutDir, c.zipPath, c.opts.DryRun = "", "a.zip", true
+		}, []string{"-zipと-targzは-dry-runや-list"}},
+		{"-galleryと-list", func(c *config) { c.gallery, c.opts.List = true, true }, []string{"-outに保存する場合のみ"}},
+		{"-check-typeなしの-fix-extension", func(c *config) { c.opts.Download.FixExt = true }, []string{"-fix-extensionは-check-typeと同時に"}},
+		{"-jsonlと-dry-run", func(c *config) { c.jsonl, c.opts.DryRun = true, true }, []string{"-jsonlは-dry-run、-list、-serveと同時に"}},
+		{"-serveと-url", func(c *config) { c.serveAddr = ":8080" }, []string{"-serveは-urlや-url-file"}},
+		{"-outのプレースホルダー", func(c *config) { c.opts.OutDir = "archive/{{time}}" }, []string{"-outのプレースホルダーが不正です"}},
+		{"-serveと{{host}}", func(c *config) {
+			c.pageURL, c.serveAddr, c.opts.OutDir = "", ":8080", "archive/{{host}}"
+		}, []string{"{{host}}は-serveでは使えません"}},
+		{"複数の誤り", func(c *config) {
+			c.opts.Concurrency = 0
+			c.opts.Download.JPEGQuality = 101
+			c.urlRegex = "("
+			c.maxSize = "10XB"
+			c.opts.Proxy = "ftp://proxy.example.com"
+			c.logLevel = "verbose"
+		}, []string{
+			"-concurrencyは1以上",
+			"-jpeg-qualityは1〜100",
+			"正規表現が不正です: -url-regex:",
+			"-max-sizeが不正です",
+			"-proxyが不正です",
+			"-log-levelは",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig(t)
+			tt.set(&cfg)
+			err := validateOptions(&cfg)
+			if err == nil {
+				t.Fatal("エラーになりませんでした")
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Errorf("誤りの数 = %d, want %d:\n%v", len(lines), len(tt.want), err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("%qが含まれていません:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/kznagamori/go_download_attachment/downloader"
+)
+
+// versionはプログラムのバージョンです。ビルド時に-ldflags "-X main.version=v1.2.3"で設定します。
+var version = "dev"
+
+// chromeVersionTimeoutは-versionでChromeのバージョンを調べる時間の上限です。
+const chromeVersionTimeout = 30 * time.Second
+
+// programVersionはプログラムのバージョンを返します。
+// -ldflagsで設定していない場合は、go installでビルドしたときのモジュールのバージョンを使います。
+func programVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return version
+}
+
+// printVersionは-versionで、プログラムとGoとChromeのバージョンをwに出力します。
+// Chromeはoptsの設定（-chrome-pathや-remote-url）で起動して調べ、起動できない場合はその理由を出力します。
+func printVersion(w io.Writer, opts downloader.Options) {
+	fmt.Fprintf(w, "go_download_attachment %s\n", programVersion())
+	fmt.Fprintf(w, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	ctx, cancel := context.WithTimeout(context.Background(), chromeVersionTimeout)
+	defer cancel()
+	info, err := downloader.DetectChrome(ctx, opts)
+	fmt.Fprintln(w, formatChromeVersion(info, err))
+}
+
+// formatChromeVersionはDetectChromeの結果を-versionで出力する1行にします。
+func formatChromeVersion(info downloader.ChromeInfo, err error) string {
+	path := info.Path
+	if path == "" {
+		path = "remote"
+	}
+	if err != nil {
+		if info.Path == "" {
+			return fmt.Sprintf("chrome: unavailable (%v)", err)
+		}
+		return fmt.Sprintf("chrome: unavailable at %s (%v)", info.Path, err)
+	}
+	return fmt.Sprintf("chrome: %s (%s)", info.Product, path)
+}